@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/example/cf-edgescout/store"
+)
+
+// edgeChange classifies how a key moved between two stores.
+type edgeChange string
+
+const (
+	changeAdded     edgeChange = "added"
+	changeRemoved   edgeChange = "removed"
+	changeImproved  edgeChange = "improved"
+	changeRegressed edgeChange = "regressed"
+)
+
+// edgeDiff describes the best score for a key in each store.
+type edgeDiff struct {
+	Key    string
+	Change edgeChange
+	Before float64
+	After  float64
+}
+
+// Delta returns the score change from the first to the second store.
+func (d edgeDiff) Delta() float64 {
+	return d.After - d.Before
+}
+
+func diffCmd(args []string) {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	pathA := fs.String("a", "", "Baseline JSONL store")
+	pathB := fs.String("b", "", "Comparison JSONL store")
+	by := fs.String("by", "ip", "Group records by ip or colo")
+	fs.Parse(args)
+
+	if *pathA == "" || *pathB == "" {
+		fs.Usage()
+		log.Fatal("both -a and -b are required")
+	}
+	keyFn, err := diffKeyFunc(*by)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx := context.Background()
+	before, err := listExisting(ctx, *pathA)
+	if err != nil {
+		log.Fatalf("read %s: %v", *pathA, err)
+	}
+	after, err := listExisting(ctx, *pathB)
+	if err != nil {
+		log.Fatalf("read %s: %v", *pathB, err)
+	}
+	writeDiff(os.Stdout, diffRecords(before, after, keyFn))
+}
+
+// listExisting reads a JSONL store without creating it when missing.
+func listExisting(ctx context.Context, path string) ([]store.Record, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	return store.NewJSONL(path).List(ctx)
+}
+
+func diffKeyFunc(by string) (func(store.Record) string, error) {
+	switch strings.ToLower(by) {
+	case "ip":
+		return func(r store.Record) string {
+			if r.Measurement.IP == nil {
+				return ""
+			}
+			return r.Measurement.IP.String()
+		}, nil
+	case "colo", "region":
+		return func(r store.Record) string {
+			return strings.ToUpper(r.Measurement.CFColo)
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown -by value %q (want ip or colo)", by)
+	}
+}
+
+// bestByKey keeps the highest score seen for every key.
+func bestByKey(records []store.Record, keyFn func(store.Record) string) map[string]float64 {
+	best := map[string]float64{}
+	for _, record := range records {
+		key := keyFn(record)
+		if key == "" {
+			continue
+		}
+		if current, ok := best[key]; !ok || record.Score > current {
+			best[key] = record.Score
+		}
+	}
+	return best
+}
+
+// diffRecords compares the best score per key between two record sets.
+// Unchanged keys are omitted.
+func diffRecords(before, after []store.Record, keyFn func(store.Record) string) []edgeDiff {
+	a := bestByKey(before, keyFn)
+	b := bestByKey(after, keyFn)
+	var diffs []edgeDiff
+	for key, scoreA := range a {
+		scoreB, ok := b[key]
+		switch {
+		case !ok:
+			diffs = append(diffs, edgeDiff{Key: key, Change: changeRemoved, Before: scoreA})
+		case scoreB-scoreA > 1e-9:
+			diffs = append(diffs, edgeDiff{Key: key, Change: changeImproved, Before: scoreA, After: scoreB})
+		case scoreA-scoreB > 1e-9:
+			diffs = append(diffs, edgeDiff{Key: key, Change: changeRegressed, Before: scoreA, After: scoreB})
+		}
+	}
+	for key, scoreB := range b {
+		if _, ok := a[key]; !ok {
+			diffs = append(diffs, edgeDiff{Key: key, Change: changeAdded, After: scoreB})
+		}
+	}
+	order := map[edgeChange]int{changeImproved: 0, changeRegressed: 1, changeAdded: 2, changeRemoved: 3}
+	sort.Slice(diffs, func(i, j int) bool {
+		if diffs[i].Change != diffs[j].Change {
+			return order[diffs[i].Change] < order[diffs[j].Change]
+		}
+		di, dj := math.Abs(diffs[i].Delta()), math.Abs(diffs[j].Delta())
+		if di != dj {
+			return di > dj
+		}
+		return diffs[i].Key < diffs[j].Key
+	})
+	return diffs
+}
+
+func writeDiff(w io.Writer, diffs []edgeDiff) {
+	if len(diffs) == 0 {
+		fmt.Fprintln(w, "no differences")
+		return
+	}
+	for _, d := range diffs {
+		switch d.Change {
+		case changeAdded:
+			fmt.Fprintf(w, "%-9s %-39s %.3f\n", d.Change, d.Key, d.After)
+		case changeRemoved:
+			fmt.Fprintf(w, "%-9s %-39s %.3f\n", d.Change, d.Key, d.Before)
+		default:
+			fmt.Fprintf(w, "%-9s %-39s %.3f -> %.3f (%+.3f)\n", d.Change, d.Key, d.Before, d.After, d.Delta())
+		}
+	}
+}
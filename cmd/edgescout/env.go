@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// envPrefix namespaces the environment variables mirroring command flags.
+const envPrefix = "EDGESCOUT_"
+
+// envName maps a flag name such as "cache-dir" to EDGESCOUT_CACHE_DIR.
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// applyEnvDefaults seeds every flag in fs from its EDGESCOUT_* environment
+// variable. It must run before fs.Parse so explicit flags still take priority.
+func applyEnvDefaults(fs *flag.FlagSet, lookup func(string) (string, bool)) error {
+	var firstErr error
+	fs.VisitAll(func(f *flag.Flag) {
+		if firstErr != nil {
+			return
+		}
+		value, ok := lookup(envName(f.Name))
+		if !ok {
+			return
+		}
+		if err := fs.Set(f.Name, value); err != nil {
+			firstErr = fmt.Errorf("invalid %s=%q: %w", envName(f.Name), value, err)
+		}
+	})
+	return firstErr
+}
+
+// parseFlags applies environment defaults and then the command line arguments.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := applyEnvDefaults(fs, os.LookupEnv); err != nil {
+		return err
+	}
+	return fs.Parse(args)
+}
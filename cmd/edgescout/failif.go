@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/example/cf-edgescout/exporter"
+	"github.com/example/cf-edgescout/store"
+)
+
+// exitFailIf is the exit status of a scan whose -fail-if condition held.
+const exitFailIf = 3
+
+// failIfOperators lists the comparison operators, two-character ones first so
+// "<=" is not read as "<".
+var failIfOperators = []string{"<=", ">=", "==", "!=", "<", ">"}
+
+// failCondition is one "<metric><op><number>" clause of -fail-if.
+type failCondition struct {
+	metric string
+	op     string
+	value  float64
+}
+
+func (c failCondition) String() string {
+	return c.metric + c.op + strconv.FormatFloat(c.value, 'f', -1, 64)
+}
+
+// parseFailIf parses comma-separated conditions such as
+// "best_score<0.8,count_passing<3". The scan fails when any of them holds.
+func parseFailIf(expr string) ([]failCondition, error) {
+	var conditions []failCondition
+	for _, clause := range strings.Split(expr, ",") {
+		clause = strings.TrimSpace(clause)
+		if clause == "" {
+			continue
+		}
+		condition, err := parseFailCondition(clause)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, condition)
+	}
+	return conditions, nil
+}
+
+func parseFailCondition(clause string) (failCondition, error) {
+	for _, op := range failIfOperators {
+		idx := strings.Index(clause, op)
+		if idx < 0 {
+			continue
+		}
+		metric := strings.ToLower(strings.TrimSpace(clause[:idx]))
+		if !knownFailMetric(metric) {
+			return failCondition{}, fmt.Errorf("unknown fail-if metric %q (known: %s, count_grade_<grade>)", metric, strings.Join(failMetricNames(), ", "))
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(clause[idx+len(op):]), 64)
+		if err != nil {
+			return failCondition{}, fmt.Errorf("invalid fail-if value in %q", clause)
+		}
+		return failCondition{metric: metric, op: op, value: value}, nil
+	}
+	return failCondition{}, fmt.Errorf("fail-if condition %q has no comparison operator", clause)
+}
+
+// failMetrics maps the metric names usable in -fail-if onto the run summary.
+var failMetrics = map[string]func(exporter.Summary) float64{
+	"count":            func(s exporter.Summary) float64 { return float64(s.Total) },
+	"count_passing":    func(s exporter.Summary) float64 { return float64(s.Passing) },
+	"count_successful": func(s exporter.Summary) float64 { return float64(s.Successful) },
+	"best_score":       func(s exporter.Summary) float64 { return s.BestScore },
+	"avg_score":        func(s exporter.Summary) float64 { return s.AverageScore },
+	"success_rate": func(s exporter.Summary) float64 {
+		if s.Total == 0 {
+			return 0
+		}
+		return float64(s.Successful) / float64(s.Total)
+	},
+}
+
+func failMetricNames() []string {
+	names := make([]string, 0, len(failMetrics))
+	for name := range failMetrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func knownFailMetric(metric string) bool {
+	_, ok := failMetrics[metric]
+	return ok || (strings.HasPrefix(metric, "count_grade_") && len(metric) > len("count_grade_"))
+}
+
+// failMetricValue evaluates metric over the scanned records.
+func failMetricValue(metric string, summary exporter.Summary, records []store.Record) float64 {
+	if fn, ok := failMetrics[metric]; ok {
+		return fn(summary)
+	}
+	grade := strings.TrimPrefix(metric, "count_grade_")
+	count := 0
+	for _, record := range records {
+		if strings.EqualFold(record.Grade, grade) {
+			count++
+		}
+	}
+	return float64(count)
+}
+
+func (c failCondition) holds(actual float64) bool {
+	switch c.op {
+	case "<":
+		return actual < c.value
+	case "<=":
+		return actual <= c.value
+	case ">":
+		return actual > c.value
+	case ">=":
+		return actual >= c.value
+	case "==":
+		return actual == c.value
+	default:
+		return actual != c.value
+	}
+}
+
+// evaluateFailIf returns the exit status for the scan and, when a condition
+// held, a message naming every violated condition with its actual value.
+func evaluateFailIf(conditions []failCondition, records []store.Record) (int, string) {
+	summary := exporter.Summarize(records)
+	var violated []string
+	for _, condition := range conditions {
+		actual := failMetricValue(condition.metric, summary, records)
+		if condition.holds(actual) {
+			violated = append(violated, fmt.Sprintf("%s (%s=%s)", condition, condition.metric, strconv.FormatFloat(actual, 'f', -1, 64)))
+		}
+	}
+	if len(violated) == 0 {
+		return 0, ""
+	}
+	return exitFailIf, "fail-if violated: " + strings.Join(violated, "; ")
+}
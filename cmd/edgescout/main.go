@@ -2,11 +2,14 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"sort"
 	"strings"
 	"time"
 
@@ -34,6 +37,14 @@ func main() {
 		daemonCmd(os.Args[2:])
 	case "serve":
 		serveCmd(os.Args[2:])
+	case "diff":
+		diffCmd(os.Args[2:])
+	case "probe":
+		probeCmd(os.Args[2:])
+	case "ranges":
+		rangesCmd(os.Args[2:])
+	case "tail":
+		tailCmd(os.Args[2:])
 	case "help", "-h", "--help":
 		usage()
 	default:
@@ -48,6 +59,10 @@ func usage() {
 	fmt.Fprintf(os.Stderr, "  scan   Perform a one-off scan of Cloudflare edges\n")
 	fmt.Fprintf(os.Stderr, "  daemon Continuously run scans at an interval\n")
 	fmt.Fprintf(os.Stderr, "  serve  Serve stored results via HTTP\n")
+	fmt.Fprintf(os.Stderr, "  diff   Compare the best edges of two JSONL stores\n")
+	fmt.Fprintf(os.Stderr, "  probe  Probe a single IP and print every phase for debugging\n")
+	fmt.Fprintf(os.Stderr, "  ranges Summarise a cached ranges.json (-verbose lists every range)\n")
+	fmt.Fprintf(os.Stderr, "  tail   Follow a JSONL store and print each new record as it is appended\n")
 }
 
 func scanCmd(args []string) {
@@ -55,19 +70,68 @@ func scanCmd(args []string) {
 	domain := fs.String("domain", "", "Target domain to probe")
 	count := fs.Int("count", 32, "Number of candidates to probe")
 	retries := fs.Int("retries", 1, "Probe retries on failure")
+	retryBackoff := fs.Duration("retry-backoff", 100*time.Millisecond, "Initial delay before retrying a transient probe failure (doubles per retry)")
 	rate := fs.Duration("rate", 200*time.Millisecond, "Delay between probes")
 	sourcesFlag := fs.String("sources", strings.Join(defaultSourceNames(), ","), "Comma-separated data sources to use")
 	cacheDir := fs.String("cache-dir", "", "Directory to persist fetched range cache")
-	parallel := fs.Int("parallel", 4, "Number of candidates to probe concurrently")
+	parallel := fs.Int("parallel", 1, "Number of candidates to probe concurrently (1 probes one at a time)")
 	jsonlPath := fs.String("jsonl", "", "Persist results to a JSONL file")
 	csvPath := fs.String("csv", "", "Export results to a CSV file")
+	htmlPath := fs.String("html", "", "Export a static HTML report")
 	providerList := fs.String("providers", "official,bestip,uouin", "Comma separated provider keys (use 'all' for every source)")
-	fs.Parse(args)
+	providerDomains := fs.String("provider-domains", "", "Comma separated provider=domain overrides for the probed SNI")
+	baselineIP := fs.String("baseline-ip", "", "Reference IP probed before each scan; edges gain relative latency/throughput components")
+	historyBias := fs.Bool("history-bias", false, "Favour networks with better past success and scores in the JSONL store")
+	neighbourBias := fs.Bool("neighbour-bias", false, "Favour addresses in the /28 around the best passing IPs in the JSONL store")
+	densityWindow := fs.Duration("density-window", 0, "De-emphasise networks in proportion to their records in the JSONL store from this window (0 disables)")
+	interleave := fs.Bool("interleave-sources", false, "Probe the sampled candidates round-robin across sources")
+	probeOrderFlag := fs.String("probe-order", string(scheduler.ProbeOrderSequential), "Candidate probe order: sequential, random or best-first (by past scores in the JSONL store)")
+	minSources := fs.Int("min-sources", 0, "Only sample networks listed by at least this many distinct sources (0 disables)")
+	excludeList := fs.String("exclude", "", "Comma-separated CIDRs or IPs that are never sampled; overlapping source networks are split around them")
+	proberOpts := addProberFlags(fs)
+	scorerOpts := addScorerFlags(fs)
+	ptrLookup := fs.Bool("ptr", false, "Record the reverse DNS (PTR) name of every probed IP")
+	outputFlag := fs.String("output", outputTable, "Result output: table, json or quiet")
+	openMetricsPath := fs.String("openmetrics", "", "Write result metrics in OpenMetrics format, with best-IP exemplars per region, to this file")
+	pushgateway := fs.String("pushgateway", "", "Pushgateway URL to POST result metrics to after the scan (user:pass@ for basic auth)")
+	pushJob := fs.String("pushgateway-job", exporter.DefaultPushJob, "Job label used when pushing to the Pushgateway")
+	pushToken := fs.String("pushgateway-token", "", "Bearer token for the Pushgateway")
+	failIf := fs.String("fail-if", "", "Exit with status 3 when any comma-separated condition on the run summary holds, e.g. \"best_score<0.8,count_passing<3\"")
+	publishOpts := addPublishFlags(fs)
+	quick := fs.Bool("quick", false, "Fast reachability scan: fewer candidates, more parallelism, HEAD requests and short timeouts (explicit flags still win)")
+	if err := parseFlags(fs, args); err != nil {
+		log.Fatal(err)
+	}
+	output, err := parseOutputFormat(*outputFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	say := func(format string, args ...any) {
+		if output == outputTable {
+			fmt.Printf(format, args...)
+		}
+	}
+	failConditions, err := parseFailIf(*failIf)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *quick {
+		effective, err := applyPreset(fs, quickPreset)
+		if err != nil {
+			log.Fatalf("quick preset: %v", err)
+		}
+		proberOpts.method = quickMethod
+		say("quick preset: %s method=%s\n", strings.Join(effective, " "), quickMethod)
+	}
 
 	if *domain == "" {
 		fs.Usage()
 		log.Fatal("domain is required")
 	}
+	probeOrder, err := scheduler.ParseProbeOrder(*probeOrderFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	ctx := context.Background()
 	rangeFetcher := fetcher.New(nil)
@@ -80,18 +144,10 @@ func scanCmd(args []string) {
 	if err != nil {
 		log.Fatalf("providers: %v", err)
 	}
-	sources, fetchErr := rangeFetcher.FetchAll(ctx, providers)
-	if fetchErr != nil {
-		log.Printf("数据源告警: %v", fetchErr)
-	}
-	if len(sources) == 0 {
-		if fallback, err := fetchRanges(ctx, rangeFetcher); err == nil {
-			fallbackProvider := fetcher.ProviderSpec{Name: "aggregated", DisplayName: "Aggregated Sources", Kind: fetcher.SourceKindOfficial, Weight: 1}
-			sources = []fetcher.SourceRange{{Provider: fallbackProvider, RangeSet: fallback}}
-		} else {
-			log.Fatalf("未能获取任何可用数据源: %v", err)
-		}
+	if err := applyProviderDomains(providers, *providerDomains); err != nil {
+		log.Fatalf("provider domains: %v", err)
 	}
+	ranges := withAggregatedFallback(&fetcher.ProviderSource{Fetcher: rangeFetcher, Providers: providers}, rangeFetcher)
 
 	var st store.Store
 	if *jsonlPath != "" {
@@ -99,21 +155,40 @@ func scanCmd(args []string) {
 	} else {
 		st = store.NewMemory()
 	}
+	st = withPublisher(st, publishOpts)
 
 	sched := &scheduler.Scheduler{
-		Sampler:     sampler.New(nil),
-		Prober:      prober.New(*domain),
-		Scorer:      scorer.New(),
-		Store:       st,
-		RateLimit:   *rate,
-		Retries:     *retries,
-		Parallelism: *parallel,
-	}
-	results, err := sched.Scan(ctx, sources, *domain, *count)
+		Sampler:           newSampler(*minSources, 0, parseExcludeList(*excludeList)),
+		Prober:            newProber(*domain, proberOpts),
+		PTRResolver:       ptrResolver(*ptrLookup),
+		Scorer:            newScorer(scorerOpts),
+		Store:             st,
+		RateLimit:         *rate,
+		Retries:           *retries,
+		RetryPolicy:       retryPolicy(*retryBackoff),
+		HistoryBias:       *historyBias,
+		NeighbourBias:     *neighbourBias,
+		DensityWindow:     *densityWindow,
+		InterleaveSources: *interleave,
+		ProbeOrder:        probeOrder,
+		BaselineIP:        parseBaselineIP(*baselineIP),
+		Parallelism:       *parallel,
+	}
+	results, err := sched.ScanFrom(ctx, ranges, *domain, *count)
 	if err != nil {
-		log.Fatalf("scan: %v", err)
+		if len(results) == 0 {
+			log.Fatalf("scan: %v", err)
+		}
+		log.Printf("部分探测失败: %v", err)
+	}
+	say("scanned %d candidates\n", len(results))
+	scanned := make([]store.Record, 0, len(results))
+	for _, result := range results {
+		scanned = append(scanned, result.Record)
+	}
+	if err := writeScanOutput(os.Stdout, output, scanned, colorEnabled(isTerminal(os.Stdout), os.Getenv)); err != nil {
+		log.Fatalf("write output: %v", err)
 	}
-	fmt.Printf("scanned %d candidates\n", len(results))
 
 	if *csvPath != "" {
 		records, err := st.List(ctx)
@@ -128,7 +203,49 @@ func scanCmd(args []string) {
 		if err := exporter.ToCSV(records, file); err != nil {
 			log.Fatalf("export csv: %v", err)
 		}
-		fmt.Printf("exported CSV to %s\n", *csvPath)
+		say("exported CSV to %s\n", *csvPath)
+	}
+
+	if *htmlPath != "" {
+		records, err := st.List(ctx)
+		if err != nil {
+			log.Fatalf("list results: %v", err)
+		}
+		file, err := os.Create(*htmlPath)
+		if err != nil {
+			log.Fatalf("create html: %v", err)
+		}
+		defer file.Close()
+		if err := exporter.ToHTML(exporter.Summarize(records), records, file); err != nil {
+			log.Fatalf("export html: %v", err)
+		}
+		say("exported HTML report to %s\n", *htmlPath)
+	}
+
+	if *openMetricsPath != "" {
+		file, err := os.Create(*openMetricsPath)
+		if err != nil {
+			log.Fatalf("create openmetrics: %v", err)
+		}
+		defer file.Close()
+		if err := exporter.ToOpenMetrics(scanned, file); err != nil {
+			log.Fatalf("export openmetrics: %v", err)
+		}
+		say("exported OpenMetrics to %s\n", *openMetricsPath)
+	}
+
+	if *pushgateway != "" {
+		pusher := &exporter.Pushgateway{URL: *pushgateway, Job: *pushJob, BearerToken: *pushToken, Client: &http.Client{Timeout: 10 * time.Second}}
+		if err := pusher.Push(ctx, scanned); err != nil {
+			log.Printf("推送 Pushgateway 失败: %v", err)
+		} else {
+			say("pushed metrics to %s\n", *pushgateway)
+		}
+	}
+
+	if code, message := evaluateFailIf(failConditions, scanned); code != 0 {
+		fmt.Fprintln(os.Stderr, message)
+		os.Exit(code)
 	}
 }
 
@@ -137,30 +254,69 @@ func daemonCmd(args []string) {
 	domain := fs.String("domain", "", "Target domain to probe")
 	count := fs.Int("count", 32, "Number of candidates per scan")
 	retries := fs.Int("retries", 1, "Probe retries on failure")
+	retryBackoff := fs.Duration("retry-backoff", 100*time.Millisecond, "Initial delay before retrying a transient probe failure (doubles per retry)")
 	rate := fs.Duration("rate", 200*time.Millisecond, "Delay between probes")
 	interval := fs.Duration("interval", 5*time.Minute, "Interval between scans")
+	maxLifetime := fs.Duration("max-lifetime", 0, "Exit cleanly after running this long (0 runs forever)")
+	maxTotalProbes := fs.Int("max-total-probes", 0, "Exit cleanly once this many candidates have been probed across all cycles (0 is unlimited)")
+	historyTTL := fs.Duration("history-ttl", 0, "Let probed IPs be sampled again after this long (0 never forgets)")
 	sourcesFlag := fs.String("sources", strings.Join(defaultSourceNames(), ","), "Comma-separated data sources to use")
 	cacheDir := fs.String("cache-dir", "edges-cache", "Directory to persist fetched range cache")
-	parallel := fs.Int("parallel", 4, "Number of candidates to probe concurrently")
+	parallel := fs.Int("parallel", 1, "Number of candidates to probe concurrently (1 probes one at a time)")
 	jsonlPath := fs.String("jsonl", "edges.jsonl", "Path to JSONL store")
 	providerList := fs.String("providers", "official,bestip,uouin", "Comma separated provider keys (use 'all' for every source)")
-	fs.Parse(args)
+	providerDomains := fs.String("provider-domains", "", "Comma separated provider=domain overrides for the probed SNI")
+	baselineIP := fs.String("baseline-ip", "", "Reference IP probed before each scan; edges gain relative latency/throughput components")
+	historyBias := fs.Bool("history-bias", false, "Favour networks with better past success and scores in the JSONL store")
+	neighbourBias := fs.Bool("neighbour-bias", false, "Favour addresses in the /28 around the best passing IPs in the JSONL store")
+	densityWindow := fs.Duration("density-window", 0, "De-emphasise networks in proportion to their records in the JSONL store from this window (0 disables)")
+	interleave := fs.Bool("interleave-sources", false, "Probe the sampled candidates round-robin across sources")
+	probeOrderFlag := fs.String("probe-order", string(scheduler.ProbeOrderSequential), "Candidate probe order: sequential, random or best-first (by past scores in the JSONL store)")
+	minSources := fs.Int("min-sources", 0, "Only sample networks listed by at least this many distinct sources (0 disables)")
+	excludeList := fs.String("exclude", "", "Comma-separated CIDRs or IPs that are never sampled; overlapping source networks are split around them")
+	proberOpts := addProberFlags(fs)
+	scorerOpts := addScorerFlags(fs)
+	ptrLookup := fs.Bool("ptr", false, "Record the reverse DNS (PTR) name of every probed IP")
+	adminAddr := fs.String("admin-addr", "", "Address for the admin API (pause/resume/status); empty disables it")
+	adminToken := fs.String("admin-token", "", "Bearer token required by the admin API")
+	publishOpts := addPublishFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		log.Fatal(err)
+	}
 
 	if *domain == "" {
 		fs.Usage()
 		log.Fatal("domain is required")
 	}
+	probeOrder, err := scheduler.ParseProbeOrder(*probeOrderFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *adminAddr != "" && *adminToken == "" {
+		log.Fatal("admin-token is required when admin-addr is set")
+	}
 
 	ctx := context.Background()
-	st := store.NewJSONL(*jsonlPath)
+	st := withPublisher(store.NewJSONL(*jsonlPath), publishOpts)
+	control := &scheduler.DaemonControl{}
 	sched := &scheduler.Scheduler{
-		Sampler:     sampler.New(nil),
-		Prober:      prober.New(*domain),
-		Scorer:      scorer.New(),
-		Store:       st,
-		RateLimit:   *rate,
-		Retries:     *retries,
-		Parallelism: *parallel,
+		Sampler:           newSampler(*minSources, *historyTTL, parseExcludeList(*excludeList)),
+		Prober:            newProber(*domain, proberOpts),
+		PTRResolver:       ptrResolver(*ptrLookup),
+		Scorer:            newScorer(scorerOpts),
+		Store:             st,
+		RateLimit:         *rate,
+		Retries:           *retries,
+		RetryPolicy:       retryPolicy(*retryBackoff),
+		HistoryBias:       *historyBias,
+		NeighbourBias:     *neighbourBias,
+		DensityWindow:     *densityWindow,
+		InterleaveSources: *interleave,
+		ProbeOrder:        probeOrder,
+		BaselineIP:        parseBaselineIP(*baselineIP),
+		Parallelism:       *parallel,
+		Control:           control,
+		MaxTotalProbes:    *maxTotalProbes,
 	}
 
 	providerKeys := parseProviderKeys(*providerList)
@@ -168,41 +324,62 @@ func daemonCmd(args []string) {
 	if err != nil {
 		log.Fatalf("providers: %v", err)
 	}
+	if err := applyProviderDomains(providers, *providerDomains); err != nil {
+		log.Fatalf("provider domains: %v", err)
+	}
+	if *adminAddr != "" {
+		admin := &api.Server{Store: st, Daemon: control, AdminToken: *adminToken}
+		go func() {
+			if err := http.ListenAndServe(*adminAddr, admin.Handler()); err != nil {
+				log.Printf("admin api stopped: %v", err)
+			}
+		}()
+		fmt.Printf("admin api listening on %s\n", *adminAddr)
+	}
 	rangeFetcher := fetcher.New(nil)
 	if err := configureFetcher(rangeFetcher, *sourcesFlag, *cacheDir); err != nil {
 		log.Fatalf("configure fetcher: %v", err)
 	}
+	if timing := scheduler.CheckDaemonTiming(*count, *rate, *interval); timing.Warning != "" {
+		log.Printf("调度告警: %s", timing.Warning)
+	}
 	fmt.Printf("starting daemon with interval %s\n", interval.String())
 
-	fetchFunc := func(ctx context.Context) ([]fetcher.SourceRange, error) {
-		sources, fetchErr := rangeFetcher.FetchAll(ctx, providers)
-		if fetchErr != nil {
-			log.Printf("数据源告警: %v", fetchErr)
-		}
-		if len(sources) == 0 {
-			fallback, err := fetchRanges(ctx, rangeFetcher)
-			if err != nil {
-				return nil, fmt.Errorf("未能获取任何可用数据源: %w", err)
-			}
-			fallbackProvider := fetcher.ProviderSpec{Name: "aggregated", DisplayName: "Aggregated Sources", Kind: fetcher.SourceKindOfficial, Weight: 1}
-			sources = []fetcher.SourceRange{{Provider: fallbackProvider, RangeSet: fallback}}
-		}
-		return sources, nil
+	ranges := withAggregatedFallback(&fetcher.ProviderSource{Fetcher: rangeFetcher, Providers: providers}, rangeFetcher)
+	err = runWithLifetime(ctx, *maxLifetime, func(ctx context.Context) error {
+		return sched.RunDaemon(ctx, ranges.Fetch, *domain, *count, *interval)
+	})
+	if errors.Is(err, scheduler.ErrProbeQuotaReached) {
+		log.Printf("探测配额已用尽: %v", err)
+		return
 	}
-
-	if err := sched.RunDaemon(ctx, fetchFunc, *domain, *count, *interval); err != nil {
+	if err != nil {
 		log.Fatalf("daemon stopped: %v", err)
 	}
 }
 
 func serveCmd(args []string) {
 	fs := flag.NewFlagSet("serve", flag.ExitOnError)
-	jsonlPath := fs.String("jsonl", "edges.jsonl", "JSONL store path")
+	jsonlPath := fs.String("jsonl", "edges.jsonl", "JSONL store path; a comma-separated list merges several stores, the first receiving writes")
+	dedupe := fs.Bool("dedupe", false, "When merging several JSONL stores, keep only the newest record per IP")
 	addr := fs.String("addr", ":8080", "Address to listen on")
-	fs.Parse(args)
+	maxAge := fs.Duration("max-age", 0, "Mark records older than this as stale (0 disables)")
+	maxDataAge := fs.Duration("max-data-age", 0, "Report /healthz as unhealthy (503) when the newest record is older than this (0 disables)")
+	cacheTTL := fs.Duration("cache-ttl", 0, "Cache API responses for this long (0 disables)")
+	rangeCacheDir := fs.String("cache-dir", "", "Fetcher cache directory to expose via /ranges")
+	compactJSON := fs.Bool("compact-json", false, "Encode API responses without indentation (clients may override with ?pretty=)")
+	storeTimeout := fs.Duration("store-timeout", 0, "Fail API requests with 503 when listing the store takes longer than this (0 disables)")
+	if err := parseFlags(fs, args); err != nil {
+		log.Fatal(err)
+	}
 
-	st := store.NewJSONL(*jsonlPath)
-	server := &api.Server{Store: st}
+	var st store.Store = store.NewJSONL(*jsonlPath)
+	if paths := parseSourceList(*jsonlPath); len(paths) > 1 {
+		multi := store.NewMultiJSONL(paths...)
+		multi.Dedupe = *dedupe
+		st = multi
+	}
+	server := &api.Server{Store: st, MaxAge: *maxAge, MaxDataAge: *maxDataAge, CacheTTL: *cacheTTL, RangeCacheDir: *rangeCacheDir, StoreTimeout: *storeTimeout, CompactJSON: *compactJSON}
 	fmt.Printf("serving results on %s\n", *addr)
 	if err := http.ListenAndServe(*addr, server.Handler()); err != nil {
 		log.Fatalf("serve: %v", err)
@@ -242,6 +419,259 @@ func defaultSourceNames() []string {
 	return names
 }
 
+// runWithLifetime runs fn under a context that expires after lifetime. Reaching
+// the lifetime is a clean stop and yields nil; zero disables the limit.
+func runWithLifetime(ctx context.Context, lifetime time.Duration, fn func(context.Context) error) error {
+	if lifetime <= 0 {
+		return fn(ctx)
+	}
+	ctx, cancel := context.WithTimeout(ctx, lifetime)
+	defer cancel()
+	err := fn(ctx)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return nil
+	}
+	return err
+}
+
+func parseBaselineIP(value string) net.IP {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil
+	}
+	ip := net.ParseIP(value)
+	if ip == nil {
+		log.Fatalf("invalid baseline-ip %q", value)
+	}
+	return ip
+}
+
+// parseExcludeList parses the -exclude networks; bare IPs exclude a single
+// address.
+func parseExcludeList(value string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, entry := range parseSourceList(value) {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				log.Fatalf("invalid exclude entry %q", entry)
+			}
+			if v4 := ip.To4(); v4 != nil {
+				ip = v4
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			log.Fatalf("invalid exclude entry %q: %v", entry, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+func retryPolicy(backoff time.Duration) *scheduler.RetryPolicy {
+	policy := scheduler.DefaultRetryPolicy()
+	policy.Backoff = backoff
+	return &policy
+}
+
+// newSampler returns a fresh sampler restricted to networks corroborated by
+// at least minSources sources whose probed IPs expire after historyTTL.
+func newSampler(minSources int, historyTTL time.Duration, exclude []*net.IPNet) *sampler.Sampler {
+	s := sampler.New(nil)
+	s.MinSourceCount = minSources
+	s.HistoryTTL = historyTTL
+	s.Exclude = exclude
+	return s
+}
+
+// ptrResolver returns the system resolver when PTR enrichment is enabled.
+func ptrResolver(enabled bool) scheduler.PTRResolver {
+	if !enabled {
+		return nil
+	}
+	return net.DefaultResolver
+}
+
+// proberOptions holds the prober flags shared by scan and daemon.
+type proberOptions struct {
+	warmPool       bool
+	caBundle       string
+	connectTimeout time.Duration
+	tlsTimeout     time.Duration
+	httpTimeout    time.Duration
+	headers        headerFlag
+	httpTrace      bool
+	websocketPath  string
+	noRedirects    bool
+	// reliabilityProbes and reliabilityTimeout feed the scorer's
+	// reliabilityWeight, which only applies to measurements that ran them.
+	reliabilityProbes  int
+	reliabilityTimeout time.Duration
+	// method overrides the probe request method; it is set by presets
+	// rather than a flag.
+	method string
+}
+
+// headerFlag collects repeated -header "Name: value" flags.
+type headerFlag map[string]string
+
+func (h headerFlag) String() string {
+	pairs := make([]string, 0, len(h))
+	for key, value := range h {
+		pairs = append(pairs, key+": "+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ", ")
+}
+
+func (h headerFlag) Set(raw string) error {
+	key, value, ok := strings.Cut(raw, ":")
+	key = strings.TrimSpace(key)
+	if !ok || key == "" {
+		return fmt.Errorf("header %q must look like \"Name: value\"", raw)
+	}
+	if strings.EqualFold(key, "Host") {
+		return errors.New("the Host header always follows -domain and cannot be overridden")
+	}
+	h[http.CanonicalHeaderKey(key)] = strings.TrimSpace(value)
+	return nil
+}
+
+func addProberFlags(fs *flag.FlagSet) *proberOptions {
+	opts := &proberOptions{headers: headerFlag{}}
+	fs.BoolVar(&opts.warmPool, "warm-pool", false, "Reuse TLS sessions within a /24 and keep per-IP connections warm between probes")
+	fs.StringVar(&opts.caBundle, "ca-bundle", "", "PEM file of root certificates used instead of the system roots")
+	fs.DurationVar(&opts.connectTimeout, "connect-timeout", 0, "Timeout for the TCP connect phase (0 keeps the 10s dialer default)")
+	fs.DurationVar(&opts.tlsTimeout, "tls-timeout", 0, "Timeout for the TLS handshake phase (0 keeps the 10s dialer default)")
+	fs.DurationVar(&opts.httpTimeout, "http-timeout", 0, "Timeout for the HTTP request phase (0 keeps the 15s client default)")
+	fs.BoolVar(&opts.httpTrace, "http-trace", false, "Record connection setup, request write, first byte and body read times of the HTTP phase")
+	fs.Var(opts.headers, "header", "Extra request header \"Name: value\" sent on every probe (repeatable)")
+	fs.BoolVar(&opts.noRedirects, "capture-redirects", false, "Do not follow redirects; record 3xx responses and their Location instead")
+	fs.StringVar(&opts.websocketPath, "websocket-path", "", "Also attempt a WebSocket Upgrade handshake on this path and record whether it succeeded")
+	fs.IntVar(&opts.reliabilityProbes, "reliability-probes", 0, "Extra TCP connects per IP whose success ratio feeds the scorer's reliabilityWeight (0 disables)")
+	fs.DurationVar(&opts.reliabilityTimeout, "reliability-timeout", 0, "Timeout for each reliability connect (0 uses the 2s default)")
+	return opts
+}
+
+func newProber(domain string, opts *proberOptions) *prober.Prober {
+	p := prober.New(domain)
+	if err := p.UseCABundle(opts.caBundle); err != nil {
+		log.Fatal(err)
+	}
+	if opts.warmPool {
+		p.WarmPool = prober.NewWarmPool()
+	}
+	p.ConnectTimeout = opts.connectTimeout
+	p.TLSTimeout = opts.tlsTimeout
+	p.HTTPTimeout = opts.httpTimeout
+	p.TraceHTTP = opts.httpTrace
+	p.CaptureRedirects = opts.noRedirects
+	p.ReliabilityProbes = opts.reliabilityProbes
+	p.ReliabilityTimeout = opts.reliabilityTimeout
+	if opts.method != "" {
+		p.HTTPMethod = opts.method
+	}
+	if path := strings.TrimSpace(opts.websocketPath); path != "" {
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+		p.WebSocketPath = path
+	}
+	if len(opts.headers) > 0 {
+		p.RequestHeaders = opts.headers
+	}
+	return p
+}
+
+// scorerOptions holds the scorer configuration flags shared by scan and daemon.
+type scorerOptions struct {
+	configPath string
+	dumpPath   string
+}
+
+func addScorerFlags(fs *flag.FlagSet) *scorerOptions {
+	opts := &scorerOptions{}
+	fs.StringVar(&opts.configPath, "config", "", "JSON scorer configuration to load (see -dump-config)")
+	fs.StringVar(&opts.dumpPath, "dump-config", "", "Write the active scorer configuration as JSON to this file ('-' prints it and exits)")
+	return opts
+}
+
+// newScorer builds the scorer from -config and honours -dump-config. Dumping
+// to "-" prints the configuration and exits without running.
+func newScorer(opts *scorerOptions) *scorer.Scorer {
+	s := scorer.New()
+	if opts.configPath != "" {
+		cfg, err := scorer.LoadConfig(opts.configPath)
+		if err != nil {
+			log.Fatalf("scorer config: %v", err)
+		}
+		s.Config = cfg
+	}
+	switch opts.dumpPath {
+	case "":
+	case "-":
+		data, err := scorer.MarshalConfig(s.Config)
+		if err != nil {
+			log.Fatalf("dump scorer config: %v", err)
+		}
+		_, _ = os.Stdout.Write(data)
+		os.Exit(0)
+	default:
+		if err := scorer.SaveConfig(opts.dumpPath, s.Config); err != nil {
+			log.Fatalf("dump scorer config: %v", err)
+		}
+	}
+	return s
+}
+
+// publishOptions holds the message bus flags shared by scan and daemon.
+type publishOptions struct {
+	natsURL string
+	subject string
+	strict  bool
+}
+
+func addPublishFlags(fs *flag.FlagSet) *publishOptions {
+	opts := &publishOptions{}
+	fs.StringVar(&opts.natsURL, "nats-url", "", "Also publish every saved record as JSON to this NATS server (e.g. nats://localhost:4222)")
+	fs.StringVar(&opts.subject, "nats-subject", store.DefaultNATSSubject, "NATS subject records are published to")
+	fs.BoolVar(&opts.strict, "publish-strict", false, "Treat publish failures as save errors instead of logging them")
+	return opts
+}
+
+// withPublisher wraps st so saves are also published when -nats-url is set.
+func withPublisher(st store.Store, opts *publishOptions) store.Store {
+	if opts.natsURL == "" {
+		return st
+	}
+	published := store.NewPublishing(st, store.NewNATSPublisher(opts.natsURL, opts.subject))
+	published.Strict = opts.strict
+	return published
+}
+
+// withAggregatedFallback logs partial provider failures and, when no provider
+// produced ranges, falls back to the fetcher's aggregated source configs.
+func withAggregatedFallback(primary scheduler.RangeProvider, f *fetcher.Fetcher) scheduler.RangeProvider {
+	return scheduler.RangeProviderFunc(func(ctx context.Context) ([]fetcher.SourceRange, error) {
+		sources, fetchErr := primary.Fetch(ctx)
+		if fetchErr != nil {
+			log.Printf("数据源告警: %v", fetchErr)
+		}
+		if len(sources) > 0 {
+			return sources, nil
+		}
+		fallback, err := fetchRanges(ctx, f)
+		if err != nil {
+			return nil, fmt.Errorf("未能获取任何可用数据源: %w", err)
+		}
+		fallbackProvider := fetcher.ProviderSpec{Name: "aggregated", DisplayName: "Aggregated Sources", Kind: fetcher.SourceKindOfficial, Weight: 1}
+		return []fetcher.SourceRange{{Provider: fallbackProvider, RangeSet: fallback}}, nil
+	})
+}
+
 func fetchRanges(ctx context.Context, f *fetcher.Fetcher) (fetcher.RangeSet, error) {
 	aggregated, err := f.Fetch(ctx)
 	if err != nil {
@@ -253,6 +683,28 @@ func fetchRanges(ctx context.Context, f *fetcher.Fetcher) (fetcher.RangeSet, err
 	return aggregated, nil
 }
 
+// applyProviderDomains assigns per-provider domains from "name=domain" pairs.
+func applyProviderDomains(providers []fetcher.ProviderSpec, value string) error {
+	for _, pair := range parseSourceList(value) {
+		name, domain, ok := strings.Cut(pair, "=")
+		name, domain = strings.TrimSpace(name), strings.TrimSpace(domain)
+		if !ok || name == "" || domain == "" {
+			return fmt.Errorf("invalid provider domain %q (want name=domain)", pair)
+		}
+		found := false
+		for i := range providers {
+			if strings.EqualFold(providers[i].Name, name) {
+				providers[i].Domain = domain
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("provider %q is not selected", name)
+		}
+	}
+	return nil
+}
+
 func parseProviderKeys(input string) []string {
 	parts := strings.Split(input, ",")
 	out := make([]string, 0, len(parts))
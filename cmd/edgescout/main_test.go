@@ -3,12 +3,35 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
 	"log"
+	"math"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/example/cf-edgescout/fetcher"
+	"github.com/example/cf-edgescout/prober"
+	"github.com/example/cf-edgescout/sampler"
+	"github.com/example/cf-edgescout/scheduler"
+	"github.com/example/cf-edgescout/scorer"
+	"github.com/example/cf-edgescout/store"
 )
 
 func TestParseSourceList(t *testing.T) {
@@ -84,3 +107,519 @@ func TestFetchRangesError(t *testing.T) {
 		t.Fatalf("expected error when no sources succeed")
 	}
 }
+
+func saveRecords(t *testing.T, path string, records ...store.Record) {
+	t.Helper()
+	st := store.NewJSONL(path)
+	for _, record := range records {
+		if err := st.Save(context.Background(), record); err != nil {
+			t.Fatalf("save: %v", err)
+		}
+	}
+}
+
+func scoredRecord(ip string, score float64) store.Record {
+	return store.Record{Timestamp: time.Now(), Score: score, Measurement: prober.Measurement{IP: net.ParseIP(ip), CFColo: "SJC"}}
+}
+
+func TestDiffRecordsReportsChanges(t *testing.T) {
+	dir := t.TempDir()
+	pathA := filepath.Join(dir, "a.jsonl")
+	pathB := filepath.Join(dir, "b.jsonl")
+	saveRecords(t, pathA, scoredRecord("1.1.1.1", 0.5), scoredRecord("1.1.1.1", 0.6), scoredRecord("2.2.2.2", 0.7), scoredRecord("3.3.3.3", 0.8))
+	saveRecords(t, pathB, scoredRecord("1.1.1.1", 0.9), scoredRecord("3.3.3.3", 0.8), scoredRecord("4.4.4.4", 0.4))
+
+	before, err := listExisting(context.Background(), pathA)
+	if err != nil {
+		t.Fatalf("list a: %v", err)
+	}
+	after, err := listExisting(context.Background(), pathB)
+	if err != nil {
+		t.Fatalf("list b: %v", err)
+	}
+	keyFn, _ := diffKeyFunc("ip")
+	diffs := diffRecords(before, after, keyFn)
+	got := map[string]edgeDiff{}
+	for _, d := range diffs {
+		got[d.Key] = d
+	}
+	if d := got["1.1.1.1"]; d.Change != changeImproved || math.Abs(d.Delta()-0.3) > 1e-9 {
+		t.Fatalf("expected 1.1.1.1 improved by 0.3, got %+v", d)
+	}
+	if d := got["2.2.2.2"]; d.Change != changeRemoved {
+		t.Fatalf("expected 2.2.2.2 removed, got %+v", d)
+	}
+	if d := got["4.4.4.4"]; d.Change != changeAdded {
+		t.Fatalf("expected 4.4.4.4 added, got %+v", d)
+	}
+	if _, ok := got["3.3.3.3"]; ok {
+		t.Fatalf("expected unchanged edge to be omitted")
+	}
+
+	var buf bytes.Buffer
+	writeDiff(&buf, diffs)
+	if !strings.Contains(buf.String(), "improved  1.1.1.1") || !strings.Contains(buf.String(), "(+0.300)") {
+		t.Fatalf("unexpected diff output:\n%s", buf.String())
+	}
+}
+
+func TestParseFlagsEnvDefaults(t *testing.T) {
+	newFlags := func() (*flag.FlagSet, *string, *time.Duration, *string) {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		domain := fs.String("domain", "", "")
+		interval := fs.Duration("interval", 5*time.Minute, "")
+		sources := fs.String("sources", "cloudflare", "")
+		return fs, domain, interval, sources
+	}
+	t.Setenv("EDGESCOUT_DOMAIN", "env.example.com")
+	t.Setenv("EDGESCOUT_INTERVAL", "30s")
+	t.Setenv("EDGESCOUT_SOURCES", "bestip,uouin")
+
+	fs, domain, interval, sources := newFlags()
+	if err := parseFlags(fs, nil); err != nil {
+		t.Fatalf("parseFlags error = %v", err)
+	}
+	if *domain != "env.example.com" || *interval != 30*time.Second || *sources != "bestip,uouin" {
+		t.Fatalf("expected env defaults, got %q %s %q", *domain, *interval, *sources)
+	}
+
+	fs, domain, interval, _ = newFlags()
+	if err := parseFlags(fs, []string{"-domain", "flag.example.com"}); err != nil {
+		t.Fatalf("parseFlags error = %v", err)
+	}
+	if *domain != "flag.example.com" {
+		t.Fatalf("expected flag to override env, got %q", *domain)
+	}
+	if *interval != 30*time.Second {
+		t.Fatalf("expected untouched flag to keep env value, got %s", *interval)
+	}
+
+	t.Setenv("EDGESCOUT_INTERVAL", "soon")
+	fs, _, _, _ = newFlags()
+	if err := parseFlags(fs, nil); err == nil || !strings.Contains(err.Error(), "EDGESCOUT_INTERVAL") {
+		t.Fatalf("expected invalid env error, got %v", err)
+	}
+}
+
+func TestApplyProviderDomains(t *testing.T) {
+	providers := []fetcher.ProviderSpec{{Name: "official"}, {Name: "bestip"}}
+	if err := applyProviderDomains(providers, "bestip=mirror.example.net"); err != nil {
+		t.Fatalf("applyProviderDomains error = %v", err)
+	}
+	if providers[0].Domain != "" || providers[1].Domain != "mirror.example.net" {
+		t.Fatalf("unexpected domains %+v", providers)
+	}
+	if err := applyProviderDomains(providers, "uouin=x.example.net"); err == nil {
+		t.Fatalf("expected error for unselected provider")
+	}
+	if err := applyProviderDomains(providers, "bestip"); err == nil {
+		t.Fatalf("expected error for malformed pair")
+	}
+}
+
+func TestWriteScanOutput(t *testing.T) {
+	fast := scoredRecord("1.1.1.1", 0.9)
+	fast.Measurement.CFColo = "SJC"
+	fast.Measurement.TCPDuration = 12 * time.Millisecond
+	records := []store.Record{scoredRecord("1.0.0.1", 0.4), fast}
+
+	var table bytes.Buffer
+	if err := writeScanOutput(&table, outputTable, records, false); err != nil {
+		t.Fatalf("table: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(table.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("expected summary, header and 2 rows, got %q", table.String())
+	}
+	if fields := strings.Fields(lines[2]); fields[0] != "1" || fields[1] != "1.1.1.1" || fields[2] != "0.900" || fields[4] != "SJC" || fields[5] != "12.0" {
+		t.Fatalf("unexpected first ranked row %q", lines[2])
+	}
+
+	var report bytes.Buffer
+	if err := writeScanOutput(&report, outputJSON, records, false); err != nil {
+		t.Fatalf("json: %v", err)
+	}
+	var decoded scanReport
+	if err := json.Unmarshal(report.Bytes(), &decoded); err != nil {
+		t.Fatalf("decode report: %v", err)
+	}
+	if decoded.Summary.Total != 2 || len(decoded.Top) != 2 || decoded.Top[0].Score != 0.9 {
+		t.Fatalf("unexpected report %+v", decoded)
+	}
+
+	var quiet bytes.Buffer
+	if err := writeScanOutput(&quiet, outputQuiet, records, false); err != nil || quiet.Len() != 0 {
+		t.Fatalf("expected quiet output to be empty, got %q (%v)", quiet.String(), err)
+	}
+	if _, err := parseOutputFormat("yaml"); err == nil {
+		t.Fatalf("expected unknown output to be rejected")
+	}
+}
+
+func TestRunWithLifetimeStopsCleanly(t *testing.T) {
+	_, ipv4, _ := net.ParseCIDR("192.0.2.0/24")
+	fetch := func(ctx context.Context) ([]fetcher.SourceRange, error) {
+		return []fetcher.SourceRange{{Provider: fetcher.ProviderSpec{Name: "official"}, RangeSet: fetcher.RangeSet{IPv4: []*net.IPNet{ipv4}}}}, nil
+	}
+	sched := &scheduler.Scheduler{
+		Sampler: sampler.New(nil),
+		Prober:  stubRunner{},
+		Scorer:  scorer.New(),
+		Store:   store.NewMemory(),
+	}
+	start := time.Now()
+	err := runWithLifetime(context.Background(), 50*time.Millisecond, func(ctx context.Context) error {
+		return sched.RunDaemon(ctx, fetch, "example.com", 1, 10*time.Millisecond)
+	})
+	if err != nil {
+		t.Fatalf("expected clean stop after lifetime, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > 2*time.Second {
+		t.Fatalf("unexpected daemon runtime %s", elapsed)
+	}
+
+	boom := errors.New("boom")
+	if err := runWithLifetime(context.Background(), time.Second, func(context.Context) error { return boom }); !errors.Is(err, boom) {
+		t.Fatalf("expected errors before the lifetime to propagate, got %v", err)
+	}
+}
+
+type stubRunner struct{}
+
+func (stubRunner) Probe(ctx context.Context, ip net.IP, domain string) (*prober.Measurement, error) {
+	return &prober.Measurement{IP: ip, Domain: domain, Success: true, Timestamp: time.Now()}, nil
+}
+
+func TestProbeReportAgainstLocalTLS(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "edge.example.com"},
+		DNSNames:     []string{"edge.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("CF-Ray", "8a1b2c3d4e5f-SJC")
+		w.Header().Set("Server", "cloudflare")
+		w.Write([]byte("ok"))
+	}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	server.StartTLS()
+	defer server.Close()
+
+	host, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	p := prober.New("edge.example.com")
+	p.Port = port
+	p.TLSConfig.InsecureSkipVerify = true
+	report, err := runProbe(context.Background(), p, net.ParseIP(host), "edge.example.com", "", []string{"edge.example.com"})
+	if err != nil {
+		t.Fatalf("runProbe error = %v", err)
+	}
+
+	var text bytes.Buffer
+	if err := writeProbeReport(&text, "text", report); err != nil {
+		t.Fatalf("text report: %v", err)
+	}
+	for _, want := range []string{"Colo:          SJC", "cn=edge.example.com", "Cf-Ray: 8a1b2c3d4e5f-SJC", "success=true"} {
+		if !strings.Contains(text.String(), want) {
+			t.Fatalf("expected %q in report:\n%s", want, text.String())
+		}
+	}
+
+	var js bytes.Buffer
+	if err := writeProbeReport(&js, "json", report); err != nil {
+		t.Fatalf("json report: %v", err)
+	}
+	var decoded probeReport
+	if err := json.Unmarshal(js.Bytes(), &decoded); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if decoded.Measurement.CFColo != "SJC" || decoded.Measurement.CertificateCN != "edge.example.com" || !decoded.Measurement.Validation.CertificateMatch {
+		t.Fatalf("unexpected json report %+v", decoded.Measurement)
+	}
+}
+
+func TestScanTableColors(t *testing.T) {
+	record := scoredRecord("1.1.1.1", 0.9)
+	record.Grade = "A"
+	records := []store.Record{record}
+
+	var plain bytes.Buffer
+	if err := writeScanOutput(&plain, outputTable, records, false); err != nil {
+		t.Fatalf("table: %v", err)
+	}
+	if strings.Contains(plain.String(), "\x1b[") {
+		t.Fatalf("expected no ANSI codes without color, got %q", plain.String())
+	}
+	var colored bytes.Buffer
+	if err := writeScanOutput(&colored, outputTable, records, true); err != nil {
+		t.Fatalf("table: %v", err)
+	}
+	if !strings.Contains(colored.String(), "\x1b["+ansiGreen+"mA"+ansiReset) {
+		t.Fatalf("expected grade A in green, got %q", colored.String())
+	}
+	if colo := record.Measurement.CFColo; colo == "" || !strings.Contains(colored.String(), "\x1b["+ansiBlue+"m"+colo+ansiReset) {
+		t.Fatalf("expected colo %q in blue, got %q", colo, colored.String())
+	}
+	if stripped := regexp.MustCompile("\x1b\\[[0-9]+m").ReplaceAllString(colored.String(), ""); stripped != plain.String() {
+		t.Fatalf("expected colored columns to line up with the plain table:\n%s\nvs\n%s", stripped, plain.String())
+	}
+
+	env := func(values map[string]string) func(string) string {
+		return func(key string) string { return values[key] }
+	}
+	if !colorEnabled(true, env(nil)) {
+		t.Fatalf("expected colors on a terminal")
+	}
+	if colorEnabled(false, env(nil)) {
+		t.Fatalf("expected no colors when output is not a terminal")
+	}
+	if colorEnabled(true, env(map[string]string{"NO_COLOR": "1"})) {
+		t.Fatalf("expected NO_COLOR to disable colors")
+	}
+	if isTerminal(mustTempFile(t)) {
+		t.Fatalf("expected a regular file not to be a terminal")
+	}
+}
+
+func mustTempFile(t *testing.T) *os.File {
+	t.Helper()
+	f, err := os.CreateTemp(t.TempDir(), "out")
+	if err != nil {
+		t.Fatalf("create temp: %v", err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func TestRangesSummaryFromCache(t *testing.T) {
+	aggregator := fetcher.NewAggregator()
+	record := func(cidr, source string) fetcher.RangeRecord {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatalf("parse %s: %v", cidr, err)
+		}
+		return fetcher.RangeRecord{Network: network, Metadata: fetcher.RangeMetadata{Source: source, Endpoint: "https://example.com/" + source}}
+	}
+	aggregator.Add([]fetcher.RangeRecord{
+		record("173.245.48.0/20", "official"),
+		record("104.16.0.0/13", "official"),
+		record("104.16.0.0/13", "bestip"),
+		record("2400:cb00::/32", "official"),
+	})
+	dir := t.TempDir()
+	if err := aggregator.Result().Persist(dir); err != nil {
+		t.Fatalf("persist: %v", err)
+	}
+	set, err := fetcher.LoadAggregatedFromCache(dir)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	summary := summariseRanges(set)
+	if summary.Total != 3 || summary.IPv4 != 2 || summary.IPv6 != 1 || summary.Invalid != 0 {
+		t.Fatalf("unexpected summary %+v", summary)
+	}
+	var buf bytes.Buffer
+	if err := writeRangesSummary(&buf, summary, set, true); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	lines := map[string]bool{}
+	for _, line := range strings.Split(buf.String(), "\n") {
+		lines[strings.Join(strings.Fields(line), " ")] = true
+	}
+	for _, want := range []string{"entries 3", "ipv4 2", "ipv6 1", "source bestip 1", "source official 3", "104.16.0.0/13 bestip,official"} {
+		if !lines[want] {
+			t.Fatalf("expected line %q in output:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestProberTimeoutFlags(t *testing.T) {
+	fs := flag.NewFlagSet("scan", flag.ContinueOnError)
+	opts := addProberFlags(fs)
+	if err := parseFlags(fs, []string{"-connect-timeout=3s", "-tls-timeout=4s", "-http-timeout=20s", "-reliability-probes=5", "-reliability-timeout=500ms"}); err != nil {
+		t.Fatalf("parseFlags error = %v", err)
+	}
+	p := newProber("example.com", opts)
+	if p.ConnectTimeout != 3*time.Second || p.TLSTimeout != 4*time.Second || p.HTTPTimeout != 20*time.Second {
+		t.Fatalf("unexpected prober timeouts connect=%s tls=%s http=%s", p.ConnectTimeout, p.TLSTimeout, p.HTTPTimeout)
+	}
+	if p.ReliabilityProbes != 5 || p.ReliabilityTimeout != 500*time.Millisecond {
+		t.Fatalf("unexpected reliability settings probes=%d timeout=%s", p.ReliabilityProbes, p.ReliabilityTimeout)
+	}
+
+	defaults := newProber("example.com", addProberFlags(flag.NewFlagSet("scan", flag.ContinueOnError)))
+	if defaults.ConnectTimeout != 0 || defaults.TLSTimeout != 0 || defaults.HTTPTimeout != 0 || defaults.ReliabilityProbes != 0 {
+		t.Fatalf("expected unset flags to keep the prober defaults")
+	}
+}
+
+func TestProberHeaderFlags(t *testing.T) {
+	fs := flag.NewFlagSet("scan", flag.ContinueOnError)
+	opts := addProberFlags(fs)
+	if err := parseFlags(fs, []string{"-header", "accept-language: en-GB", "-header=Accept:text/html"}); err != nil {
+		t.Fatalf("parseFlags error = %v", err)
+	}
+	p := newProber("example.com", opts)
+	if p.RequestHeaders["Accept-Language"] != "en-GB" || p.RequestHeaders["Accept"] != "text/html" {
+		t.Fatalf("unexpected request headers %v", p.RequestHeaders)
+	}
+	for _, bad := range []string{"Host: evil.example", "no-colon"} {
+		fs := flag.NewFlagSet("scan", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		addProberFlags(fs)
+		if err := fs.Parse([]string{"-header", bad}); err == nil {
+			t.Fatalf("expected -header %q to be rejected", bad)
+		}
+	}
+}
+
+func TestQuickPresetReducesScanSettings(t *testing.T) {
+	fs := flag.NewFlagSet("scan", flag.ContinueOnError)
+	count := fs.Int("count", 32, "")
+	parallel := fs.Int("parallel", 4, "")
+	rate := fs.Duration("rate", 200*time.Millisecond, "")
+	retries := fs.Int("retries", 1, "")
+	opts := addProberFlags(fs)
+	if err := parseFlags(fs, []string{"-retries=2"}); err != nil {
+		t.Fatalf("parseFlags error = %v", err)
+	}
+	effective, err := applyPreset(fs, quickPreset)
+	if err != nil {
+		t.Fatalf("applyPreset error = %v", err)
+	}
+	opts.method = quickMethod
+	if *count != 8 || *parallel != 16 || *rate != 0 {
+		t.Fatalf("unexpected scan settings count=%d parallel=%d rate=%s", *count, *parallel, *rate)
+	}
+	if *retries != 2 {
+		t.Fatalf("expected the explicit -retries to win over the preset, got %d", *retries)
+	}
+	p := newProber("example.com", opts)
+	if p.HTTPMethod != http.MethodHead || p.ConnectTimeout != 2*time.Second || p.TLSTimeout != 2*time.Second || p.HTTPTimeout != 3*time.Second {
+		t.Fatalf("unexpected prober method=%s connect=%s tls=%s http=%s", p.HTTPMethod, p.ConnectTimeout, p.TLSTimeout, p.HTTPTimeout)
+	}
+	if len(effective) != len(quickPreset) || effective[0] != "count=8" || effective[3] != "retries=2" {
+		t.Fatalf("unexpected effective settings %v", effective)
+	}
+}
+
+func TestFailIfExitCode(t *testing.T) {
+	records := []store.Record{
+		{Score: 0.92, Grade: "A", Status: "pass", Measurement: prober.Measurement{Success: true}},
+		{Score: 0.74, Grade: "B", Status: "pass", Measurement: prober.Measurement{Success: true}},
+		{Score: 0.2, Grade: "F", Status: "fail"},
+	}
+	cases := map[string]int{
+		"best_score<0.8":                     0,
+		"best_score<0.95":                    exitFailIf,
+		"count_passing<3":                    exitFailIf,
+		"count_passing<2":                    0,
+		"count_grade_a<1":                    0,
+		"count_grade_a<2, success_rate>=0.9": exitFailIf,
+		"":                                   0,
+	}
+	for expr, want := range cases {
+		conditions, err := parseFailIf(expr)
+		if err != nil {
+			t.Fatalf("parseFailIf(%q) error = %v", expr, err)
+		}
+		code, message := evaluateFailIf(conditions, records)
+		if code != want {
+			t.Fatalf("%q: exit code %d, want %d (%s)", expr, code, want, message)
+		}
+		if code != 0 && !strings.Contains(message, "fail-if violated") {
+			t.Fatalf("%q: expected a clear message, got %q", expr, message)
+		}
+	}
+	for _, bad := range []string{"fastest<1", "best_score", "best_score<high"} {
+		if _, err := parseFailIf(bad); err == nil {
+			t.Fatalf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestRecordTailerFollowsAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "edges.jsonl")
+	line := func(ip string, score float64, grade, colo string) string {
+		data, err := json.Marshal(store.Record{Timestamp: time.Now(), Score: score, Grade: grade, Measurement: prober.Measurement{IP: net.ParseIP(ip), Success: true, CFColo: colo}})
+		if err != nil {
+			t.Fatalf("marshal: %v", err)
+		}
+		return string(data) + "\n"
+	}
+	appendTo := func(p, text string) {
+		f, err := os.OpenFile(p, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			t.Fatalf("open: %v", err)
+		}
+		defer f.Close()
+		if _, err := f.WriteString(text); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	poll := func(tailer *recordTailer) []string {
+		records, err := tailer.poll()
+		if err != nil {
+			t.Fatalf("poll error = %v", err)
+		}
+		lines := make([]string, 0, len(records))
+		for _, record := range records {
+			lines = append(lines, renderTailLine(record, false))
+		}
+		return lines
+	}
+	expect := func(lines []string, want ...string) {
+		t.Helper()
+		if len(lines) != len(want) {
+			t.Fatalf("expected %d rendered lines, got %q", len(want), lines)
+		}
+		for i, fragment := range want {
+			if !strings.Contains(lines[i], fragment) {
+				t.Fatalf("line %q does not mention %q", lines[i], fragment)
+			}
+		}
+	}
+
+	appendTo(path, line("104.16.0.1", 0.5, "C", "LHR"))
+	tailer, err := newRecordTailer(path, false)
+	if err != nil {
+		t.Fatalf("newRecordTailer error = %v", err)
+	}
+	defer tailer.close()
+	expect(poll(tailer))
+
+	second := line("104.16.0.2", 0.91, "A", "SJC")
+	appendTo(path, second[:20])
+	expect(poll(tailer))
+	appendTo(path, second[20:]+line("104.16.0.3", 0.72, "B", "SIN"))
+	rendered := poll(tailer)
+	expect(rendered, "104.16.0.2", "104.16.0.3")
+	for _, fragment := range []string{"0.910", "A", "SJC"} {
+		if !strings.Contains(rendered[0], fragment) {
+			t.Fatalf("expected %q in %q", fragment, rendered[0])
+		}
+	}
+
+	if err := os.WriteFile(path, []byte(line("104.16.0.4", 0.6, "C", "HKG")), 0o644); err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+	expect(poll(tailer), "104.16.0.4")
+
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	appendTo(path+".1", line("104.16.0.5", 0.8, "B", "SJC"))
+	appendTo(path, line("104.16.0.6", 0.9, "A", "SJC"))
+	expect(poll(tailer), "104.16.0.5", "104.16.0.6")
+}
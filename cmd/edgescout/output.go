@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/example/cf-edgescout/exporter"
+	"github.com/example/cf-edgescout/store"
+)
+
+// Scan output formats accepted by -output.
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputQuiet = "quiet"
+)
+
+// tableTopEdges bounds the number of rows printed by the table output.
+const tableTopEdges = 20
+
+// scanReport is the document written by -output json.
+type scanReport struct {
+	Summary exporter.Summary `json:"summary"`
+	Top     []store.Record   `json:"top"`
+}
+
+func parseOutputFormat(value string) (string, error) {
+	format := strings.ToLower(strings.TrimSpace(value))
+	switch format {
+	case outputTable, outputJSON, outputQuiet:
+		return format, nil
+	default:
+		return "", fmt.Errorf("unknown output %q (want table, json or quiet)", value)
+	}
+}
+
+// writeScanOutput renders the records of a scan in the requested format. color
+// enables ANSI colors in the table output.
+func writeScanOutput(w io.Writer, format string, records []store.Record, color bool) error {
+	switch format {
+	case outputQuiet:
+		return nil
+	case outputJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(scanReport{Summary: exporter.Summarize(records), Top: exporter.TopRecords(records, tableTopEdges)})
+	case outputTable:
+		return writeScanTable(w, records, color)
+	default:
+		return fmt.Errorf("unknown output %q", format)
+	}
+}
+
+func writeScanTable(w io.Writer, records []store.Record, color bool) error {
+	summary := exporter.Summarize(records)
+	fmt.Fprintf(w, "%d records, %d passing, average score %.3f\n", summary.Total, summary.Passing, summary.AverageScore)
+	paint := func(code, value string) string {
+		if !color {
+			return value
+		}
+		return "\x1b[" + code + "m" + value + ansiReset
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	// tabwriter counts escape bytes as width, so the header cells of colored
+	// columns carry a same-length code to keep every row of a column padded
+	// alike.
+	fmt.Fprintf(tw, "#\tIP\t%s\t%s\t%s\t%s\tSOURCE\n",
+		paint(ansiDefault, "SCORE"), paint(ansiDefault, "GRADE"), paint(ansiDefault, "COLO"), paint(ansiDefault, "LATENCY(ms)"))
+	for i, record := range exporter.TopRecords(records, tableTopEdges) {
+		m := record.Measurement
+		latency := (m.TCPDuration + m.TLSDuration + m.HTTPDuration).Seconds() * 1000
+		tone := gradeColor(record.Grade)
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", i+1, m.IP,
+			paint(tone, fmt.Sprintf("%.3f", record.Score)),
+			paint(tone, orDash(record.Grade)),
+			paint(coloColor(m.CFColo), orDash(m.CFColo)),
+			paint(latencyColor(latency), fmt.Sprintf("%.1f", latency)),
+			orDash(m.Source))
+	}
+	return tw.Flush()
+}
+
+// ANSI foreground colors. Every code has the same length so colored columns
+// stay aligned by tabwriter.
+const (
+	ansiRed     = "31"
+	ansiGreen   = "32"
+	ansiYellow  = "33"
+	ansiBlue    = "34"
+	ansiCyan    = "36"
+	ansiDefault = "39"
+	ansiReset   = "\x1b[0m"
+)
+
+func gradeColor(grade string) string {
+	switch grade {
+	case "A":
+		return ansiGreen
+	case "B":
+		return ansiCyan
+	case "C", "D":
+		return ansiYellow
+	case "":
+		return ansiDefault
+	default:
+		return ansiRed
+	}
+}
+
+// coloColor highlights known colos and leaves a missing one uncolored.
+func coloColor(colo string) string {
+	if colo == "" {
+		return ansiDefault
+	}
+	return ansiBlue
+}
+
+func latencyColor(ms float64) string {
+	switch {
+	case ms < 100:
+		return ansiGreen
+	case ms < 200:
+		return ansiYellow
+	default:
+		return ansiRed
+	}
+}
+
+// colorEnabled reports whether table output should use ANSI colors: only on a
+// terminal, and never when NO_COLOR is set or TERM is "dumb".
+func colorEnabled(isTTY bool, getenv func(string) string) bool {
+	if getenv("NO_COLOR") != "" || getenv("TERM") == "dumb" {
+		return false
+	}
+	return isTTY
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
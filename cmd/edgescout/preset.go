@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+)
+
+// presetSetting is one flag value applied by a preset.
+type presetSetting struct {
+	flag  string
+	value string
+}
+
+// quickPreset is applied by scan -quick: a small sample probed quickly with
+// short phase timeouts, favouring a reachability check over measurement
+// depth. Probes additionally use HEAD so no response body is read.
+var quickPreset = []presetSetting{
+	{"count", "8"},
+	{"parallel", "16"},
+	{"rate", "0s"},
+	{"retries", "0"},
+	{"connect-timeout", "2s"},
+	{"tls-timeout", "2s"},
+	{"http-timeout", "3s"},
+}
+
+// quickMethod is the probe request method used by the quick preset.
+const quickMethod = http.MethodHead
+
+// applyPreset sets every preset flag that was not given explicitly, on the
+// command line or through the environment, and returns the effective
+// "name=value" pairs in preset order for reporting.
+func applyPreset(fs *flag.FlagSet, preset []presetSetting) ([]string, error) {
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	effective := make([]string, 0, len(preset))
+	for _, setting := range preset {
+		if !explicit[setting.flag] {
+			if err := fs.Set(setting.flag, setting.value); err != nil {
+				return nil, err
+			}
+		}
+		effective = append(effective, setting.flag+"="+fs.Lookup(setting.flag).Value.String())
+	}
+	return effective, nil
+}
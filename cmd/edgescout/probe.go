@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/example/cf-edgescout/prober"
+	"github.com/example/cf-edgescout/scorer"
+)
+
+// probeReport is the outcome of a single debug probe.
+type probeReport struct {
+	Measurement prober.Measurement `json:"measurement"`
+	Score       float64            `json:"score"`
+	Grade       string             `json:"grade"`
+	Status      string             `json:"status"`
+	Failures    []string           `json:"failures,omitempty"`
+	Components  map[string]float64 `json:"components"`
+}
+
+func probeCmd(args []string) {
+	fs := flag.NewFlagSet("probe", flag.ExitOnError)
+	ipFlag := fs.String("ip", "", "Edge IP to probe")
+	domain := fs.String("domain", "", "Domain used for SNI and the Host header")
+	port := fs.String("port", "443", "TCP port to connect to")
+	insecure := fs.Bool("insecure", false, "Skip certificate verification during the handshake")
+	expectedOrigin := fs.String("expected-origin", "", "Expected origin host for validation")
+	trustedCNs := fs.String("trusted-cns", "", "Comma separated certificate CNs accepted by validation")
+	format := fs.String("format", "text", "Output format: text or json")
+	timeout := fs.Duration("timeout", 20*time.Second, "Overall probe timeout")
+	caBundle := fs.String("ca-bundle", "", "PEM file of root certificates used instead of the system roots")
+	headers := headerFlag{}
+	fs.Var(headers, "header", "Extra request header \"Name: value\" sent with the probe (repeatable)")
+	reliabilityProbes := fs.Int("reliability-probes", 0, "Extra TCP connects whose success ratio is reported as reliability (0 disables)")
+	reliabilityTimeout := fs.Duration("reliability-timeout", 0, "Timeout for each reliability connect (0 uses the 2s default)")
+	if err := parseFlags(fs, args); err != nil {
+		log.Fatal(err)
+	}
+
+	ip := net.ParseIP(strings.TrimSpace(*ipFlag))
+	if ip == nil || *domain == "" {
+		fs.Usage()
+		log.Fatal("valid -ip and -domain are required")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	p := prober.New(*domain)
+	p.Port = *port
+	p.TLSConfig.InsecureSkipVerify = *insecure
+	p.RequestHeaders = headers
+	p.ReliabilityProbes = *reliabilityProbes
+	p.ReliabilityTimeout = *reliabilityTimeout
+	if err := p.UseCABundle(*caBundle); err != nil {
+		log.Fatal(err)
+	}
+	report, err := runProbe(ctx, p, ip, *domain, *expectedOrigin, parseSourceList(*trustedCNs))
+	if err != nil {
+		log.Fatalf("probe: %v", err)
+	}
+	if err := writeProbeReport(os.Stdout, *format, report); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// runProbe probes a single IP, applies validation and scores the result.
+func runProbe(ctx context.Context, p *prober.Prober, ip net.IP, domain, expectedOrigin string, trustedCNs []string) (probeReport, error) {
+	m, err := p.Probe(ctx, ip, domain)
+	if err != nil {
+		return probeReport{}, err
+	}
+	m.ApplyValidation(expectedOrigin, trustedCNs)
+	result := scorer.New().Score(*m)
+	return probeReport{
+		Measurement: result.Measurement,
+		Score:       result.Score,
+		Grade:       result.Grade,
+		Status:      result.Status,
+		Failures:    result.Failures,
+		Components:  result.Components,
+	}, nil
+}
+
+func writeProbeReport(w io.Writer, format string, report probeReport) error {
+	switch strings.ToLower(format) {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	case "text", "":
+	default:
+		return errors.New("unknown format " + format + " (want text or json)")
+	}
+	m := report.Measurement
+	ms := func(d time.Duration) string { return fmt.Sprintf("%.1fms", d.Seconds()*1000) }
+	fmt.Fprintf(w, "IP:            %s\n", m.IP)
+	fmt.Fprintf(w, "Domain:        %s\n", m.Domain)
+	fmt.Fprintf(w, "URL:           %s (Host: %s)\n", orDash(m.EffectiveURL), orDash(m.RequestHost))
+	fmt.Fprintf(w, "Result:        success=%t status=%s score=%.3f grade=%s\n", m.Success, report.Status, report.Score, report.Grade)
+	if m.Error != "" {
+		fmt.Fprintf(w, "Error:         %s (%s)\n", m.Error, m.FailureCategory())
+	}
+	fmt.Fprintf(w, "Phases:        tcp=%s tls=%s http=%s total=%s\n", ms(m.TCPDuration), ms(m.TLSDuration), ms(m.HTTPDuration), ms(m.TotalDuration))
+	fmt.Fprintf(w, "TLS:           version=%s alpn=%s sni=%s resumed=%t\n", m.TLSVersion, m.ALPN, m.SNI, m.TLSResumed)
+	fmt.Fprintf(w, "Certificate:   cn=%s sans=%s matchesSni=%t\n", m.CertificateCN, strings.Join(m.CertificateDNSNames, ","), m.Integrity.MatchesSNI)
+	if m.Integrity.VerifyError != "" {
+		fmt.Fprintf(w, "Verify error:  %s\n", m.Integrity.VerifyError)
+	}
+	fmt.Fprintf(w, "Colo:          %s %s %s\n", orDash(m.CFColo), m.Location.City, m.Location.Country)
+	fmt.Fprintf(w, "CF-Ray:        %s\n", orDash(m.CFRay))
+	if m.ReliabilityAttempts > 0 {
+		fmt.Fprintf(w, "Reliability:   %.2f of %d connects\n", m.Reliability, m.ReliabilityAttempts)
+	}
+	fmt.Fprintf(w, "HTTP:          status=%d bytes=%d throughput=%.0fbps\n", m.Integrity.HTTPStatus, m.BytesRead, m.Throughput)
+	keys := make([]string, 0, len(m.HTTPFingerprint.Headers))
+	for key := range m.HTTPFingerprint.Headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "  %s: %s\n", key, m.HTTPFingerprint.Headers[key])
+	}
+	fmt.Fprintf(w, "Validation:    certificateMatch=%t originMatch=%t failures=%s\n", m.Validation.CertificateMatch, m.Validation.OriginMatch, orDash(strings.Join(report.Failures, ",")))
+	components := make([]string, 0, len(report.Components))
+	for name := range report.Components {
+		components = append(components, name)
+	}
+	sort.Strings(components)
+	fmt.Fprintln(w, "Score components:")
+	for _, name := range components {
+		fmt.Fprintf(w, "  %-16s %.3f\n", name, report.Components[name])
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/example/cf-edgescout/fetcher"
+)
+
+// rangesSummary counts the entries of a cached aggregated set.
+type rangesSummary struct {
+	Total     int
+	IPv4      int
+	IPv6      int
+	Invalid   int
+	PerSource map[string]int
+}
+
+func rangesCmd(args []string) {
+	fs := flag.NewFlagSet("ranges", flag.ExitOnError)
+	cacheDir := fs.String("cache-dir", "edges-cache", "Fetcher cache directory containing ranges.json")
+	verbose := fs.Bool("verbose", false, "List every range with its sources")
+	if err := parseFlags(fs, args); err != nil {
+		log.Fatal(err)
+	}
+	set, err := fetcher.LoadAggregatedFromCache(*cacheDir)
+	if err != nil {
+		log.Fatalf("load ranges: %v", err)
+	}
+	summary := summariseRanges(set)
+	if err := writeRangesSummary(os.Stdout, summary, set, *verbose); err != nil {
+		log.Fatal(err)
+	}
+	if summary.Invalid > 0 {
+		os.Exit(1)
+	}
+}
+
+// summariseRanges counts entries per family and per source. Entries without a
+// network are counted as invalid; an entry reported by several sources counts
+// once for each of them.
+func summariseRanges(set fetcher.AggregatedSet) rangesSummary {
+	summary := rangesSummary{PerSource: map[string]int{}}
+	for _, entry := range set.Entries {
+		summary.Total++
+		if entry.Network == nil || entry.Network.IP == nil {
+			summary.Invalid++
+			continue
+		}
+		if entry.Network.IP.To4() != nil {
+			summary.IPv4++
+		} else {
+			summary.IPv6++
+		}
+		for _, source := range entrySources(entry) {
+			summary.PerSource[source]++
+		}
+	}
+	return summary
+}
+
+func entrySources(entry fetcher.RangeEntry) []string {
+	seen := map[string]bool{}
+	var sources []string
+	for _, meta := range entry.Metadata {
+		name := meta.Source
+		if name == "" {
+			name = "unknown"
+		}
+		if !seen[name] {
+			seen[name] = true
+			sources = append(sources, name)
+		}
+	}
+	return sources
+}
+
+func writeRangesSummary(w io.Writer, summary rangesSummary, set fetcher.AggregatedSet, verbose bool) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "entries\t%d\n", summary.Total)
+	fmt.Fprintf(tw, "ipv4\t%d\n", summary.IPv4)
+	fmt.Fprintf(tw, "ipv6\t%d\n", summary.IPv6)
+	if summary.Invalid > 0 {
+		fmt.Fprintf(tw, "invalid\t%d\n", summary.Invalid)
+	}
+	names := make([]string, 0, len(summary.PerSource))
+	for name := range summary.PerSource {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(tw, "source %s\t%d\n", name, summary.PerSource[name])
+	}
+	if verbose {
+		fmt.Fprintln(tw)
+		for _, entry := range set.Entries {
+			if entry.Network == nil || entry.Network.IP == nil {
+				continue
+			}
+			fmt.Fprintf(tw, "%s\t%s\n", entry.Network, strings.Join(entrySources(entry), ","))
+		}
+	}
+	return tw.Flush()
+}
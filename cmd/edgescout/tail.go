@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/example/cf-edgescout/store"
+)
+
+func tailCmd(args []string) {
+	fs := flag.NewFlagSet("tail", flag.ExitOnError)
+	jsonlPath := fs.String("jsonl", "edges.jsonl", "JSONL store to follow")
+	interval := fs.Duration("interval", time.Second, "How often to check the file for new records")
+	fromStart := fs.Bool("from-start", false, "Print the records already in the file before following it")
+	noColor := fs.Bool("no-color", false, "Disable ANSI colors (also off when stdout is not a terminal or NO_COLOR is set)")
+	if err := parseFlags(fs, args); err != nil {
+		log.Fatal(err)
+	}
+	if strings.HasSuffix(*jsonlPath, ".gz") {
+		log.Fatal("tail cannot follow gzip-compressed stores")
+	}
+	if *interval <= 0 {
+		log.Fatal("interval must be > 0")
+	}
+	color := !*noColor && colorEnabled(isTerminal(os.Stdout), os.Getenv)
+	tailer, err := newRecordTailer(*jsonlPath, *fromStart)
+	if err != nil {
+		log.Fatalf("tail: %v", err)
+	}
+	defer tailer.close()
+	for {
+		records, err := tailer.poll()
+		for _, record := range records {
+			fmt.Fprintln(os.Stdout, renderTailLine(record, color))
+		}
+		if err != nil {
+			log.Printf("tail: %v", err)
+		}
+		time.Sleep(*interval)
+	}
+}
+
+// recordTailer follows a JSONL store like tail -f. It keeps the read offset
+// and any incomplete trailing line between polls, starts over when the file
+// is truncated and switches to the new file when the path is rotated.
+type recordTailer struct {
+	path    string
+	file    *os.File
+	offset  int64
+	partial []byte
+}
+
+// newRecordTailer prepares to follow path. Unless fromStart is set, records
+// already in the file are skipped. The file does not need to exist yet.
+func newRecordTailer(path string, fromStart bool) (*recordTailer, error) {
+	t := &recordTailer{path: path}
+	if fromStart {
+		return t, nil
+	}
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return t, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := t.open(); err != nil {
+		return nil, err
+	}
+	t.offset = info.Size()
+	return t, nil
+}
+
+// poll returns the records completed since the previous call. Malformed lines
+// are skipped and reported in the returned error.
+func (t *recordTailer) poll() ([]store.Record, error) {
+	info, err := os.Stat(t.path)
+	if errors.Is(err, os.ErrNotExist) {
+		// Rotation in progress: drain the old file and wait for the new one.
+		if t.file == nil {
+			return nil, nil
+		}
+		records, readErr := t.read()
+		t.close()
+		return records, readErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	var records []store.Record
+	var errs []error
+	if t.file != nil {
+		current, statErr := t.file.Stat()
+		switch {
+		case statErr != nil || !os.SameFile(current, info):
+			drained, readErr := t.read()
+			records, errs = append(records, drained...), append(errs, readErr)
+			t.close()
+		case info.Size() < t.offset:
+			t.offset, t.partial = 0, nil
+		}
+	}
+	if t.file == nil {
+		if err := t.open(); err != nil {
+			return records, errors.Join(append(errs, err)...)
+		}
+	}
+	fresh, readErr := t.read()
+	return append(records, fresh...), errors.Join(append(errs, readErr)...)
+}
+
+// read consumes the bytes appended after the offset and parses every complete
+// line.
+func (t *recordTailer) read() ([]store.Record, error) {
+	if _, err := t.file.Seek(t.offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(t.file)
+	t.offset += int64(len(data))
+	if err != nil {
+		return nil, err
+	}
+	buf := append(t.partial, data...)
+	var records []store.Record
+	var errs []error
+	for {
+		idx := bytes.IndexByte(buf, '\n')
+		if idx < 0 {
+			break
+		}
+		line := bytes.TrimSpace(buf[:idx])
+		buf = buf[idx+1:]
+		if len(line) == 0 {
+			continue
+		}
+		var record store.Record
+		if err := json.Unmarshal(line, &record); err != nil {
+			errs = append(errs, fmt.Errorf("skip malformed record: %w", err))
+			continue
+		}
+		records = append(records, record)
+	}
+	t.partial = append([]byte(nil), buf...)
+	return records, errors.Join(errs...)
+}
+
+func (t *recordTailer) open() error {
+	f, err := os.Open(t.path)
+	if err != nil {
+		return err
+	}
+	t.file, t.offset, t.partial = f, 0, nil
+	return nil
+}
+
+func (t *recordTailer) close() {
+	if t.file != nil {
+		_ = t.file.Close()
+		t.file = nil
+	}
+}
+
+// renderTailLine formats a record as a one-line summary: time, IP, score,
+// grade and colo. With color set the line is tinted by grade.
+func renderTailLine(record store.Record, color bool) string {
+	m := record.Measurement
+	ip := "-"
+	if m.IP != nil {
+		ip = m.IP.String()
+	}
+	colo := m.CFColo
+	if colo == "" {
+		colo = m.Location.Colo
+	}
+	line := fmt.Sprintf("%s  %-39s  %.3f  %-2s  %s", record.Timestamp.Local().Format("2006-01-02 15:04:05"), ip, record.Score, orDash(record.Grade), orDash(colo))
+	if !m.Success {
+		line += "  " + orDash(m.FailureCategory())
+	}
+	if color {
+		return "\x1b[" + gradeColor(record.Grade) + "m" + line + ansiReset
+	}
+	return line
+}
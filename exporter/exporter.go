@@ -22,10 +22,19 @@ func ToJSONL(records []store.Record, w io.Writer) error {
 	return nil
 }
 
+// csvComponents are the scorer components exported as component_<name>
+// columns, in column order.
+var csvComponents = []string{"latency", "success", "throughput", "integrity", "sourcePreference", "sourceWeight"}
+
 // ToCSV writes a CSV representation of the records.
 func ToCSV(records []store.Record, w io.Writer) error {
 	writer := csv.NewWriter(w)
-	header := []string{"timestamp", "score", "grade", "status", "failures", "ip", "domain", "source", "provider", "success", "http_status", "latency_ms", "throughput_bps", "bytes", "colo", "city", "country", "response_hash"}
+	// New columns go at the end of the row so existing column positions stay
+	// stable for consumers that read the CSV by index.
+	header := []string{"timestamp", "score", "grade", "status", "failures", "ip", "domain", "source", "provider", "success", "http_status", "latency_ms", "throughput_bps", "bytes", "colo", "city", "country", "response_hash", "total_ms", "alpn", "tls_version", "cipher_suite", "run_id", "effective_url", "baseline_ms"}
+	for _, name := range csvComponents {
+		header = append(header, "component_"+name)
+	}
 	if err := writer.Write(header); err != nil {
 		return err
 	}
@@ -52,6 +61,16 @@ func ToCSV(records []store.Record, w io.Writer) error {
 			m.Location.City,
 			m.Location.Country,
 			m.Integrity.ResponseHash,
+			fmt.Sprintf("%.2f", m.TotalDuration.Seconds()*1000),
+			m.ALPN,
+			m.TLSVersion,
+			m.CipherSuite,
+			record.RunID,
+			m.EffectiveURL,
+			baselineMs(record.BaselineLatency),
+		}
+		for _, name := range csvComponents {
+			row = append(row, componentValue(record.Components, name))
 		}
 		if err := writer.Write(row); err != nil {
 			return err
@@ -60,3 +79,22 @@ func ToCSV(records []store.Record, w io.Writer) error {
 	writer.Flush()
 	return writer.Error()
 }
+
+// componentValue formats one score component, leaving the cell empty when the
+// scorer did not produce it.
+func componentValue(components map[string]float64, name string) string {
+	value, ok := components[name]
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("%.4f", value)
+}
+
+// baselineMs formats a run's baseline latency, leaving the cell empty for
+// runs without a baseline.
+func baselineMs(d time.Duration) string {
+	if d <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%.2f", d.Seconds()*1000)
+}
@@ -2,6 +2,11 @@ package exporter
 
 import (
     "bytes"
+    "context"
+    "encoding/csv"
+    "io"
+    "net/http"
+    "net/http/httptest"
     "strings"
     "testing"
     "time"
@@ -15,6 +20,7 @@ func sampleRecord() store.Record {
         Timestamp:  time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
         Score:      0.8,
         Components: map[string]float64{"latency": 0.7},
+        BaselineLatency: 45 * time.Millisecond,
         Measurement: prober.Measurement{
             Domain:       "example.com",
             Source:       "official",
@@ -25,6 +31,10 @@ func sampleRecord() store.Record {
             TLSDuration:  20 * time.Millisecond,
             HTTPDuration: 30 * time.Millisecond,
             Throughput:   1000,
+            ALPN:         "h2",
+            TLSVersion:   "TLS1.3",
+            CipherSuite:  "TLS_AES_128_GCM_SHA256",
+            EffectiveURL: "https://example.com:443/",
             Location:     prober.LocationInfo{Colo: "SJC", City: "San Jose", Country: "US"},
             Integrity:     prober.IntegrityReport{HTTPStatus: 200, ResponseHash: "abcd"},
         },
@@ -57,3 +67,189 @@ func TestToCSV(t *testing.T) {
         t.Fatalf("expected provider column")
     }
 }
+
+func TestToHTML(t *testing.T) {
+    record := sampleRecord()
+    record.Measurement.CFColo = "SJC"
+    malicious := sampleRecord()
+    malicious.Score = 0.95
+    malicious.Measurement.Source = `<script>alert("x")</script>`
+    records := []store.Record{record, malicious}
+
+    var buf bytes.Buffer
+    if err := ToHTML(Summarize(records), records, &buf); err != nil {
+        t.Fatalf("ToHTML error = %v", err)
+    }
+    output := buf.String()
+    if !strings.Contains(output, `<td class="total">2</td>`) {
+        t.Fatalf("expected total count in html")
+    }
+    if !strings.Contains(output, `<tr class="region"><td>SJC</td><td>San Jose</td>`) {
+        t.Fatalf("expected SJC region row in html:\n%s", output)
+    }
+    if strings.Contains(output, "<script>") {
+        t.Fatalf("expected source name to be escaped")
+    }
+    if !strings.Contains(output, "&lt;script&gt;") {
+        t.Fatalf("expected escaped source name in html")
+    }
+}
+
+func TestPushgatewayPostsMetrics(t *testing.T) {
+    var gotPath, gotAuth, gotType, gotBody string
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        body, _ := io.ReadAll(r.Body)
+        gotPath, gotAuth, gotType, gotBody = r.URL.Path, r.Header.Get("Authorization"), r.Header.Get("Content-Type"), string(body)
+        if r.Method != http.MethodPost {
+            w.WriteHeader(http.StatusMethodNotAllowed)
+        }
+    }))
+    defer server.Close()
+
+    older := sampleRecord()
+    older.Timestamp = older.Timestamp.Add(-time.Hour)
+    older.Score = 0.1
+    pusher := &Pushgateway{URL: server.URL + "/", Job: "nightly scan", BearerToken: "secret"}
+    if err := pusher.Push(context.Background(), []store.Record{older, sampleRecord()}); err != nil {
+        t.Fatalf("Push error = %v", err)
+    }
+    if gotPath != "/metrics/job/nightly scan" {
+        t.Fatalf("unexpected push path %q", gotPath)
+    }
+    if gotAuth != "Bearer secret" || gotType != PrometheusContentType {
+        t.Fatalf("unexpected headers auth=%q type=%q", gotAuth, gotType)
+    }
+    want := `edgescout_edge_score{ip="1.1.1.1",domain="example.com",source="official",colo="SJC"} 0.8`
+    if !strings.Contains(gotBody, want+"\n") {
+        t.Fatalf("expected the latest score line %q in payload:\n%s", want, gotBody)
+    }
+    if strings.Count(gotBody, "edgescout_edge_score{") != 1 {
+        t.Fatalf("expected repeated probes to collapse to one series:\n%s", gotBody)
+    }
+
+    basic := &Pushgateway{URL: strings.Replace(server.URL, "http://", "http://user:pass@", 1)}
+    if err := basic.Push(context.Background(), []store.Record{sampleRecord()}); err != nil {
+        t.Fatalf("Push error = %v", err)
+    }
+    if gotPath != "/metrics/job/"+DefaultPushJob || !strings.HasPrefix(gotAuth, "Basic ") {
+        t.Fatalf("expected basic auth and the default job, got path=%q auth=%q", gotPath, gotAuth)
+    }
+
+    failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        http.Error(w, "unauthorized", http.StatusUnauthorized)
+    }))
+    defer failing.Close()
+    err := (&Pushgateway{URL: failing.URL}).Push(context.Background(), []store.Record{sampleRecord()})
+    if err == nil || !strings.Contains(err.Error(), "401") {
+        t.Fatalf("expected a 401 error, got %v", err)
+    }
+}
+
+func TestToCSVProtocolColumns(t *testing.T) {
+    var buf bytes.Buffer
+    if err := ToCSV([]store.Record{sampleRecord()}, &buf); err != nil {
+        t.Fatalf("ToCSV error = %v", err)
+    }
+    rows, err := csv.NewReader(&buf).ReadAll()
+    if err != nil || len(rows) != 2 {
+        t.Fatalf("expected header and one row, got %d rows (%v)", len(rows), err)
+    }
+    values := map[string]string{}
+    for i, column := range rows[0] {
+        values[column] = rows[1][i]
+    }
+    want := map[string]string{"alpn": "h2", "tls_version": "TLS1.3", "cipher_suite": "TLS_AES_128_GCM_SHA256", "effective_url": "https://example.com:443/", "baseline_ms": "45.00", "component_latency": "0.7000", "component_throughput": ""}
+    for column, expected := range want {
+        if values[column] != expected {
+            t.Fatalf("column %s = %q, want %q", column, values[column], expected)
+        }
+    }
+}
+
+func TestToCSVBaselineAndEffectiveURLColumns(t *testing.T) {
+    withBaseline := sampleRecord()
+    withoutBaseline := sampleRecord()
+    withoutBaseline.BaselineLatency = 0
+    withoutBaseline.Measurement.EffectiveURL = "https://example.com:8443/health"
+    var buf bytes.Buffer
+    if err := ToCSV([]store.Record{withBaseline, withoutBaseline}, &buf); err != nil {
+        t.Fatalf("ToCSV error = %v", err)
+    }
+    rows, err := csv.NewReader(&buf).ReadAll()
+    if err != nil || len(rows) != 3 {
+        t.Fatalf("expected header and two rows, got %d rows (%v)", len(rows), err)
+    }
+    index := map[string]int{}
+    for i, column := range rows[0] {
+        index[column] = i
+    }
+    for _, column := range []string{"baseline_ms", "effective_url"} {
+        if _, ok := index[column]; !ok {
+            t.Fatalf("expected a %s column in %v", column, rows[0])
+        }
+    }
+    if got := rows[1][index["baseline_ms"]]; got != "45.00" {
+        t.Fatalf("baseline_ms = %q, want 45.00", got)
+    }
+    if got := rows[2][index["baseline_ms"]]; got != "" {
+        t.Fatalf("expected a blank baseline_ms without a baseline, got %q", got)
+    }
+    if got := rows[1][index["effective_url"]]; got != "https://example.com:443/" {
+        t.Fatalf("effective_url = %q, want https://example.com:443/", got)
+    }
+    if got := rows[2][index["effective_url"]]; got != "https://example.com:8443/health" {
+        t.Fatalf("effective_url = %q, want https://example.com:8443/health", got)
+    }
+}
+
+func TestToCSVKeepsLegacyColumnPositions(t *testing.T) {
+    var buf bytes.Buffer
+    if err := ToCSV(nil, &buf); err != nil {
+        t.Fatalf("ToCSV error = %v", err)
+    }
+    rows, err := csv.NewReader(&buf).ReadAll()
+    if err != nil || len(rows) != 1 {
+        t.Fatalf("expected a header row, got %d rows (%v)", len(rows), err)
+    }
+    legacy := "timestamp,score,grade,status,failures,ip,domain,source,provider,success,http_status,latency_ms,throughput_bps,bytes,colo,city,country,response_hash"
+    if head := strings.Join(rows[0][:18], ","); head != legacy {
+        t.Fatalf("expected the original columns first, got %s", head)
+    }
+}
+
+func TestToCSVComponentColumnsFollowHeader(t *testing.T) {
+    var buf bytes.Buffer
+    if err := ToCSV([]store.Record{sampleRecord()}, &buf); err != nil {
+        t.Fatalf("ToCSV error = %v", err)
+    }
+    rows, err := csv.NewReader(&buf).ReadAll()
+    if err != nil || len(rows) != 2 {
+        t.Fatalf("expected header and one row, got %d rows (%v)", len(rows), err)
+    }
+    header := rows[0]
+    tail := strings.Join(header[len(header)-len(csvComponents):], ",")
+    if tail != "component_latency,component_success,component_throughput,component_integrity,component_sourcePreference,component_sourceWeight" {
+        t.Fatalf("unexpected component column order %s", tail)
+    }
+    if got := rows[1][len(header)-len(csvComponents)]; got != "0.7000" {
+        t.Fatalf("component_latency = %q, want 0.7000", got)
+    }
+}
+
+func TestToOpenMetricsExemplars(t *testing.T) {
+    weaker := sampleRecord()
+    weaker.Score = 0.4
+    weaker.Measurement.IP = []byte{1, 0, 0, 2}
+    var buf bytes.Buffer
+    if err := ToOpenMetrics([]store.Record{weaker, sampleRecord()}, &buf); err != nil {
+        t.Fatalf("ToOpenMetrics error = %v", err)
+    }
+    output := buf.String()
+    want := `edgescout_region_probes_total{region="SJC"} 2 # {ip="1.1.1.1"} 0.8` + "\n"
+    if !strings.Contains(output, want) {
+        t.Fatalf("expected exemplar line %q in:\n%s", want, output)
+    }
+    if !strings.HasSuffix(output, "# EOF\n") {
+        t.Fatalf("expected the OpenMetrics terminator")
+    }
+}
@@ -0,0 +1,172 @@
+package exporter
+
+import (
+	"html/template"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/example/cf-edgescout/geo"
+	"github.com/example/cf-edgescout/store"
+)
+
+// Summary aggregates a set of records for reports.
+type Summary struct {
+	GeneratedAt  time.Time       `json:"generatedAt"`
+	Total        int             `json:"total"`
+	Passing      int             `json:"passing"`
+	Successful   int             `json:"successful"`
+	AverageScore float64         `json:"averageScore"`
+	BestScore    float64         `json:"bestScore"`
+	Regions      []RegionSummary `json:"regions"`
+}
+
+// RegionSummary aggregates the records observed for a single colo.
+type RegionSummary struct {
+	Region      string  `json:"region"`
+	City        string  `json:"city,omitempty"`
+	Country     string  `json:"country,omitempty"`
+	Count       int     `json:"count"`
+	SuccessRate float64 `json:"successRate"`
+	AvgScore    float64 `json:"avgScore"`
+}
+
+// Summarize computes the report summary for the records.
+func Summarize(records []store.Record) Summary {
+	summary := Summary{GeneratedAt: time.Now().UTC(), Total: len(records)}
+	regions := map[string]*RegionSummary{}
+	var scoreSum float64
+	for _, record := range records {
+		scoreSum += record.Score
+		if record.Score > summary.BestScore {
+			summary.BestScore = record.Score
+		}
+		if record.Status == "pass" {
+			summary.Passing++
+		}
+		if record.Measurement.Success {
+			summary.Successful++
+		}
+		key := regionKey(record)
+		region := regions[key]
+		if region == nil {
+			region = &RegionSummary{Region: key}
+			if info, ok := geo.LookupColo(key); ok {
+				region.City = info.City
+				region.Country = info.Country
+			}
+			regions[key] = region
+		}
+		region.Count++
+		region.AvgScore += record.Score
+		if record.Measurement.Success {
+			region.SuccessRate++
+		}
+	}
+	if summary.Total > 0 {
+		summary.AverageScore = scoreSum / float64(summary.Total)
+	}
+	for _, region := range regions {
+		region.AvgScore /= float64(region.Count)
+		region.SuccessRate /= float64(region.Count)
+		summary.Regions = append(summary.Regions, *region)
+	}
+	sort.Slice(summary.Regions, func(i, j int) bool {
+		if summary.Regions[i].AvgScore != summary.Regions[j].AvgScore {
+			return summary.Regions[i].AvgScore > summary.Regions[j].AvgScore
+		}
+		return summary.Regions[i].Region < summary.Regions[j].Region
+	})
+	return summary
+}
+
+// TopRecords returns up to n records ordered by descending score.
+func TopRecords(records []store.Record, n int) []store.Record {
+	sorted := append([]store.Record(nil), records...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Score > sorted[j].Score
+	})
+	if n > 0 && len(sorted) > n {
+		sorted = sorted[:n]
+	}
+	return sorted
+}
+
+func regionKey(record store.Record) string {
+	m := record.Measurement
+	for _, candidate := range []string{m.CFColo, m.Location.Colo, m.Location.City} {
+		if info, ok := geo.Resolve(candidate); ok {
+			return info.Code
+		}
+	}
+	for _, candidate := range []string{m.CFColo, m.Location.Colo} {
+		if trimmed := strings.TrimSpace(candidate); trimmed != "" {
+			return strings.ToUpper(trimmed)
+		}
+	}
+	return "UNKNOWN"
+}
+
+// htmlTopEdges is the number of records listed in the HTML snapshot.
+const htmlTopEdges = 20
+
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"inc":   func(i int) int { return i + 1 },
+	"pct":   func(v float64) string { return formatFloat(v*100, 1) + "%" },
+	"score": func(v float64) string { return formatFloat(v, 3) },
+	"ms": func(r store.Record) string {
+		m := r.Measurement
+		return formatFloat((m.TCPDuration+m.TLSDuration+m.HTTPDuration).Seconds()*1000, 1)
+	},
+	"ts": func(t time.Time) string { return t.Format(time.RFC3339) },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>cf-edgescout report</title>
+<style>
+body{font-family:system-ui,sans-serif;margin:2rem;color:#1f2937}
+table{border-collapse:collapse;margin-bottom:2rem}
+th,td{border:1px solid #d1d5db;padding:.3rem .6rem;text-align:left}
+th{background:#f3f4f6}
+</style>
+</head>
+<body>
+<h1>cf-edgescout report</h1>
+<p>Generated at {{ts .Summary.GeneratedAt}}</p>
+<h2>Summary</h2>
+<table>
+<tr><th>Total</th><td class="total">{{.Summary.Total}}</td></tr>
+<tr><th>Passing</th><td>{{.Summary.Passing}}</td></tr>
+<tr><th>Successful</th><td>{{.Summary.Successful}}</td></tr>
+<tr><th>Average score</th><td>{{score .Summary.AverageScore}}</td></tr>
+<tr><th>Best score</th><td>{{score .Summary.BestScore}}</td></tr>
+</table>
+<h2>Regions</h2>
+<table>
+<tr><th>Region</th><th>City</th><th>Country</th><th>Count</th><th>Success rate</th><th>Average score</th></tr>
+{{range .Summary.Regions}}<tr class="region"><td>{{.Region}}</td><td>{{.City}}</td><td>{{.Country}}</td><td>{{.Count}}</td><td>{{pct .SuccessRate}}</td><td>{{score .AvgScore}}</td></tr>
+{{end}}</table>
+<h2>Top edges</h2>
+<table>
+<tr><th>#</th><th>IP</th><th>Score</th><th>Grade</th><th>Colo</th><th>Latency (ms)</th><th>Source</th><th>Provider</th></tr>
+{{range $i, $r := .Top}}<tr><td>{{inc $i}}</td><td>{{$r.Measurement.IP}}</td><td>{{score $r.Score}}</td><td>{{$r.Grade}}</td><td>{{$r.Measurement.CFColo}}</td><td>{{ms $r}}</td><td>{{$r.Measurement.Source}}</td><td>{{$r.Measurement.Provider}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+func formatFloat(v float64, prec int) string {
+	return strconv.FormatFloat(v, 'f', prec, 64)
+}
+
+// ToHTML renders a self-contained HTML snapshot of the summary and the
+// highest scoring records. All values are escaped by html/template.
+func ToHTML(summary Summary, records []store.Record, w io.Writer) error {
+	return htmlTemplate.Execute(w, struct {
+		Summary Summary
+		Top     []store.Record
+	}{Summary: summary, Top: TopRecords(records, htmlTopEdges)})
+}
@@ -0,0 +1,213 @@
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/example/cf-edgescout/store"
+)
+
+// PrometheusContentType is the text exposition format written by ToPrometheus.
+const PrometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// OpenMetricsContentType is the format written by ToOpenMetrics.
+const OpenMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// DefaultPushJob is the job label used when Pushgateway.Job is empty.
+const DefaultPushJob = "edgescout"
+
+// edgeMetric describes one per-edge gauge.
+type edgeMetric struct {
+	name  string
+	help  string
+	value func(store.Record) float64
+}
+
+var edgeMetrics = []edgeMetric{
+	{"edgescout_edge_score", "Composite score of the latest probe per edge.", func(r store.Record) float64 { return r.Score }},
+	{"edgescout_edge_success", "Whether the latest probe per edge succeeded (1) or not (0).", func(r store.Record) float64 {
+		if r.Measurement.Success {
+			return 1
+		}
+		return 0
+	}},
+	{"edgescout_edge_latency_seconds", "TCP+TLS+HTTP latency of the latest probe per edge.", func(r store.Record) float64 {
+		m := r.Measurement
+		return (m.TCPDuration + m.TLSDuration + m.HTTPDuration).Seconds()
+	}},
+	{"edgescout_edge_throughput_bps", "Measured throughput in bits per second of the latest probe per edge.", func(r store.Record) float64 { return r.Measurement.Throughput }},
+}
+
+// ToPrometheus writes per-edge gauges in the Prometheus text exposition
+// format. Repeated probes of the same IP and domain keep only the latest one.
+func ToPrometheus(records []store.Record, w io.Writer) error {
+	var buf bytes.Buffer
+	writeEdgeMetrics(&buf, records)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// ToOpenMetrics writes the ToPrometheus gauges in the OpenMetrics format and
+// adds per-region metrics. The region probe counter carries an exemplar
+// pointing at the region's best-scoring IP, with its score as the value.
+func ToOpenMetrics(records []store.Record, w io.Writer) error {
+	var buf bytes.Buffer
+	writeEdgeMetrics(&buf, records)
+	regions := bestPerRegion(records)
+	buf.WriteString("# HELP edgescout_region_probes Probes per region; the exemplar references the best-scoring IP.\n# TYPE edgescout_region_probes counter\n")
+	for _, region := range regions {
+		best := region.best.Measurement.IP.String()
+		fmt.Fprintf(&buf, "edgescout_region_probes_total{region=\"%s\"} %d # {ip=\"%s\"} %s\n", escapeLabel(region.key), region.count, escapeLabel(best), formatSample(region.best.Score))
+	}
+	buf.WriteString("# HELP edgescout_region_best_score Best score observed per region.\n# TYPE edgescout_region_best_score gauge\n")
+	for _, region := range regions {
+		fmt.Fprintf(&buf, "edgescout_region_best_score{region=\"%s\"} %s\n", escapeLabel(region.key), formatSample(region.best.Score))
+	}
+	buf.WriteString("# EOF\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func writeEdgeMetrics(buf *bytes.Buffer, records []store.Record) {
+	latest := latestPerEdge(records)
+	for _, metric := range edgeMetrics {
+		fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s gauge\n", metric.name, metric.help, metric.name)
+		for _, record := range latest {
+			fmt.Fprintf(buf, "%s{%s} %s\n", metric.name, edgeLabels(record), formatSample(metric.value(record)))
+		}
+	}
+	fmt.Fprintf(buf, "# HELP edgescout_scan_records Records included in this export.\n# TYPE edgescout_scan_records gauge\nedgescout_scan_records %d\n", len(records))
+}
+
+func formatSample(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// regionBest tracks the probe count and best record of one region.
+type regionBest struct {
+	key   string
+	count int
+	best  store.Record
+}
+
+func bestPerRegion(records []store.Record) []regionBest {
+	index := map[string]*regionBest{}
+	for _, record := range records {
+		key := regionKey(record)
+		entry := index[key]
+		if entry == nil {
+			entry = &regionBest{key: key, best: record}
+			index[key] = entry
+		} else if record.Score > entry.best.Score {
+			entry.best = record
+		}
+		entry.count++
+	}
+	out := make([]regionBest, 0, len(index))
+	for _, entry := range index {
+		out = append(out, *entry)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].key < out[j].key })
+	return out
+}
+
+func latestPerEdge(records []store.Record) []store.Record {
+	index := map[string]int{}
+	var latest []store.Record
+	for _, record := range records {
+		key := record.Measurement.IP.String() + "|" + record.Measurement.Domain
+		if i, ok := index[key]; ok {
+			if record.Timestamp.After(latest[i].Timestamp) {
+				latest[i] = record
+			}
+			continue
+		}
+		index[key] = len(latest)
+		latest = append(latest, record)
+	}
+	sort.SliceStable(latest, func(i, j int) bool {
+		return latest[i].Measurement.IP.String() < latest[j].Measurement.IP.String()
+	})
+	return latest
+}
+
+func edgeLabels(record store.Record) string {
+	m := record.Measurement
+	pairs := [][2]string{{"ip", m.IP.String()}, {"domain", m.Domain}, {"source", m.Source}, {"colo", m.Location.Colo}}
+	parts := make([]string, 0, len(pairs))
+	for _, pair := range pairs {
+		parts = append(parts, pair[0]+`="`+escapeLabel(pair[1])+`"`)
+	}
+	return strings.Join(parts, ",")
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+// Pushgateway pushes exported metrics to a Prometheus Pushgateway. Basic auth
+// credentials may be embedded in URL; BearerToken takes precedence when set.
+type Pushgateway struct {
+	URL         string
+	Job         string
+	BearerToken string
+	Client      *http.Client
+}
+
+// Push POSTs the records as a metrics group under /metrics/job/<job>.
+func (p *Pushgateway) Push(ctx context.Context, records []store.Record) error {
+	endpoint, err := url.Parse(strings.TrimRight(p.URL, "/"))
+	if err != nil {
+		return fmt.Errorf("pushgateway url: %w", err)
+	}
+	if endpoint.Scheme == "" || endpoint.Host == "" {
+		return fmt.Errorf("pushgateway url: %q is not absolute", p.URL)
+	}
+	job := p.Job
+	if job == "" {
+		job = DefaultPushJob
+	}
+	user := endpoint.User
+	endpoint.User = nil
+	endpoint.RawPath = endpoint.EscapedPath() + "/metrics/job/" + url.PathEscape(job)
+	endpoint.Path += "/metrics/job/" + job
+
+	var body bytes.Buffer
+	if err := ToPrometheus(records, &body); err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.String(), &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", PrometheusContentType)
+	if p.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+p.BearerToken)
+	} else if user != nil {
+		password, _ := user.Password()
+		req.SetBasicAuth(user.Username(), password)
+	}
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("pushgateway: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("pushgateway 响应异常: %d %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
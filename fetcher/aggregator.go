@@ -25,7 +25,7 @@ type AggregatedSet struct {
 // RangeSet extracts the IPv4/IPv6 slices from the aggregated entries and groups
 // them per upstream source so the sampler can apply policies later.
 func (a AggregatedSet) RangeSet() RangeSet {
-	rs := RangeSet{}
+	rs := RangeSet{SourceCounts: map[string]int{}}
 	perSource := map[string]*SourceRangeSet{}
 	for _, entry := range a.Entries {
 		if entry.Network == nil {
@@ -37,10 +37,15 @@ func (a AggregatedSet) RangeSet() RangeSet {
 		} else {
 			rs.IPv6 = append(rs.IPv6, cloned)
 		}
+		seen := map[string]struct{}{}
 		for _, meta := range entry.Metadata {
 			if meta.Source == "" {
 				continue
 			}
+			if _, dup := seen[meta.Source]; !dup {
+				seen[meta.Source] = struct{}{}
+				rs.SourceCounts[cloned.String()]++
+			}
 			sr, ok := perSource[meta.Source]
 			if !ok {
 				sr = &SourceRangeSet{Name: meta.Source, Credibility: meta.Credibility}
@@ -62,8 +67,23 @@ func (a AggregatedSet) RangeSet() RangeSet {
 	return rs
 }
 
+// SourceCount reports how many distinct sources listed network. Networks
+// without recorded provenance count as a single source.
+func (rs RangeSet) SourceCount(network *net.IPNet) int {
+	if count, ok := rs.SourceCounts[network.String()]; ok {
+		return count
+	}
+	return 1
+}
+
 // Aggregator deduplicates networks and enriches them with metadata.
 type Aggregator struct {
+	// Coalesce makes Result fold subnets into any listed supernet and join
+	// adjacent blocks that form a larger prefix, so overlapping sources do
+	// not inflate the sampled space. Merged entries keep the union of their
+	// contributors' metadata.
+	Coalesce bool
+
 	mu      sync.Mutex
 	entries map[string]*RangeEntry
 }
@@ -97,14 +117,19 @@ func (a *Aggregator) Result() AggregatedSet {
 	defer a.mu.Unlock()
 	entries := make([]RangeEntry, 0, len(a.entries))
 	for _, entry := range a.entries {
-		meta := append([]RangeMetadata(nil), entry.Metadata...)
+		entries = append(entries, RangeEntry{Network: cloneIPNet(entry.Network), Metadata: append([]RangeMetadata(nil), entry.Metadata...)})
+	}
+	if a.Coalesce {
+		entries = coalesceEntries(entries)
+	}
+	for _, entry := range entries {
+		meta := entry.Metadata
 		sort.Slice(meta, func(i, j int) bool {
 			if meta[i].Source == meta[j].Source {
 				return meta[i].Endpoint < meta[j].Endpoint
 			}
 			return meta[i].Source < meta[j].Source
 		})
-		entries = append(entries, RangeEntry{Network: cloneIPNet(entry.Network), Metadata: meta})
 	}
 	sort.Slice(entries, func(i, j int) bool {
 		return entries[i].Network.String() < entries[j].Network.String()
@@ -0,0 +1,96 @@
+package fetcher
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultBreakerThreshold is the number of consecutive failures after
+	// which a source is skipped.
+	DefaultBreakerThreshold = 3
+	// DefaultBreakerCooldown is how long a tripped source is skipped.
+	DefaultBreakerCooldown = 10 * time.Minute
+)
+
+// SourceHealth reports the recent fetch history of a configured source.
+type SourceHealth struct {
+	Name                string    `json:"name"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	LastSuccess         time.Time `json:"lastSuccess,omitempty"`
+	LastFailure         time.Time `json:"lastFailure,omitempty"`
+	LastError           string    `json:"lastError,omitempty"`
+	// SkippedUntil is set while the circuit is open and the source is skipped.
+	SkippedUntil time.Time `json:"skippedUntil,omitempty"`
+}
+
+// sourceBreaker tracks per-source failures and opens a circuit after
+// threshold consecutive failures, skipping the source until cooldown elapses.
+type sourceBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+	health    map[string]*SourceHealth
+}
+
+func newSourceBreaker(threshold int, cooldown time.Duration) *sourceBreaker {
+	return &sourceBreaker{threshold: threshold, cooldown: cooldown, now: time.Now, health: make(map[string]*SourceHealth)}
+}
+
+func (b *sourceBreaker) entry(name string) *SourceHealth {
+	h, ok := b.health[name]
+	if !ok {
+		h = &SourceHealth{Name: name}
+		b.health[name] = h
+	}
+	return h
+}
+
+// allow reports whether the source may be fetched now. Once the cooldown has
+// elapsed the source gets a single trial; another failure reopens the circuit.
+func (b *sourceBreaker) allow(name string) (bool, time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	h, ok := b.health[name]
+	if !ok || h.SkippedUntil.IsZero() {
+		return true, time.Time{}
+	}
+	if b.now().Before(h.SkippedUntil) {
+		return false, h.SkippedUntil
+	}
+	h.SkippedUntil = time.Time{}
+	return true, time.Time{}
+}
+
+func (b *sourceBreaker) record(name string, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	h := b.entry(name)
+	now := b.now()
+	if err == nil {
+		h.ConsecutiveFailures = 0
+		h.LastSuccess = now
+		h.LastError = ""
+		h.SkippedUntil = time.Time{}
+		return
+	}
+	h.ConsecutiveFailures++
+	h.LastFailure = now
+	h.LastError = err.Error()
+	if b.threshold > 0 && h.ConsecutiveFailures >= b.threshold {
+		h.SkippedUntil = now.Add(b.cooldown)
+	}
+}
+
+func (b *sourceBreaker) snapshot() []SourceHealth {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	out := make([]SourceHealth, 0, len(b.health))
+	for _, h := range b.health {
+		out = append(out, *h)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
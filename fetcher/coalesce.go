@@ -0,0 +1,118 @@
+package fetcher
+
+import (
+	"net"
+	"net/netip"
+	"sort"
+)
+
+// coalesceEntries merges entries whose networks are contained in another
+// entry's network into that supernet, then repeatedly joins sibling blocks
+// (two halves of the same larger prefix) into their parent. Every merged
+// entry carries the union of its contributors' metadata. The result is in
+// address order; callers re-sort as needed.
+func coalesceEntries(entries []RangeEntry) []RangeEntry {
+	type block struct {
+		prefix   netip.Prefix
+		metadata []RangeMetadata
+	}
+	blocks := make([]block, 0, len(entries))
+	var invalid []RangeEntry
+	for _, entry := range entries {
+		prefix, ok := prefixOf(entry.Network)
+		if !ok {
+			invalid = append(invalid, entry)
+			continue
+		}
+		blocks = append(blocks, block{prefix: prefix, metadata: entry.Metadata})
+	}
+	// Address order with supernets ahead of the subnets they contain, so
+	// containment only needs checking against the last kept block.
+	sort.Slice(blocks, func(i, j int) bool {
+		if c := blocks[i].prefix.Addr().Compare(blocks[j].prefix.Addr()); c != 0 {
+			return c < 0
+		}
+		return blocks[i].prefix.Bits() < blocks[j].prefix.Bits()
+	})
+	var merged []block
+	for _, b := range blocks {
+		if n := len(merged); n > 0 {
+			last := &merged[n-1]
+			if last.prefix.Addr().BitLen() == b.prefix.Addr().BitLen() && last.prefix.Contains(b.prefix.Addr()) {
+				last.metadata = unionMetadata(last.metadata, b.metadata)
+				continue
+			}
+		}
+		merged = append(merged, b)
+		for n := len(merged); n >= 2; n = len(merged) {
+			parent, ok := siblingParent(merged[n-2].prefix, merged[n-1].prefix)
+			if !ok {
+				break
+			}
+			joined := block{prefix: parent, metadata: unionMetadata(merged[n-2].metadata, merged[n-1].metadata)}
+			merged = append(merged[:n-2], joined)
+		}
+	}
+	out := make([]RangeEntry, 0, len(merged)+len(invalid))
+	for _, b := range merged {
+		out = append(out, RangeEntry{Network: ipNetOf(b.prefix), Metadata: b.metadata})
+	}
+	return append(out, invalid...)
+}
+
+// siblingParent reports the parent prefix when a and b are its two halves.
+func siblingParent(a, b netip.Prefix) (netip.Prefix, bool) {
+	if a.Bits() != b.Bits() || a.Bits() == 0 || a.Addr().BitLen() != b.Addr().BitLen() || a == b {
+		return netip.Prefix{}, false
+	}
+	parent := netip.PrefixFrom(a.Addr(), a.Bits()-1).Masked()
+	if parent.Addr() != a.Addr() || !parent.Contains(b.Addr()) {
+		return netip.Prefix{}, false
+	}
+	return parent, true
+}
+
+// unionMetadata appends the metadata of b missing from a, treating entries
+// with the same source and endpoint as one contributor.
+func unionMetadata(a, b []RangeMetadata) []RangeMetadata {
+	out := append([]RangeMetadata(nil), a...)
+	for _, meta := range b {
+		duplicate := false
+		for _, existing := range out {
+			if existing.Source == meta.Source && existing.Endpoint == meta.Endpoint {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			out = append(out, meta)
+		}
+	}
+	return out
+}
+
+func prefixOf(network *net.IPNet) (netip.Prefix, bool) {
+	if network == nil {
+		return netip.Prefix{}, false
+	}
+	addr, ok := netip.AddrFromSlice(network.IP)
+	if !ok {
+		return netip.Prefix{}, false
+	}
+	ones, bits := network.Mask.Size()
+	if bits == 0 {
+		return netip.Prefix{}, false
+	}
+	if bits == 32 {
+		addr = addr.Unmap()
+	}
+	if addr.BitLen() != bits {
+		return netip.Prefix{}, false
+	}
+	return netip.PrefixFrom(addr, ones).Masked(), true
+}
+
+func ipNetOf(prefix netip.Prefix) *net.IPNet {
+	addr := prefix.Addr()
+	return &net.IPNet{IP: net.IP(addr.AsSlice()), Mask: net.CIDRMask(prefix.Bits(), addr.BitLen())}
+}
@@ -0,0 +1,79 @@
+package fetcher
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// conditionalCache remembers the ETag and Last-Modified validators of the last
+// successful fetch of each endpoint together with the records parsed from it,
+// so unchanged range lists are answered with 304 instead of re-downloaded.
+type conditionalCache struct {
+	mu      sync.Mutex
+	entries map[string]conditionalEntry
+}
+
+type conditionalEntry struct {
+	etag         string
+	lastModified string
+	records      []RangeRecord
+}
+
+func newConditionalCache() *conditionalCache {
+	return &conditionalCache{entries: make(map[string]conditionalEntry)}
+}
+
+// conditionalKey scopes entries to the source as well as the URL, since the
+// cached records carry the source's metadata.
+func conditionalKey(source, endpoint string) string {
+	return source + "\x00" + endpoint
+}
+
+// prepare adds If-None-Match and If-Modified-Since to req when the endpoint
+// was fetched before. endpoint is the configured URL rather than req.URL,
+// whose normalised form may not match the key used by remember.
+func (c *conditionalCache) prepare(source, endpoint string, req *http.Request) {
+	c.mu.Lock()
+	entry, ok := c.entries[conditionalKey(source, endpoint)]
+	c.mu.Unlock()
+	if !ok {
+		return
+	}
+	if entry.etag != "" {
+		req.Header.Set("If-None-Match", entry.etag)
+	}
+	if entry.lastModified != "" {
+		req.Header.Set("If-Modified-Since", entry.lastModified)
+	}
+}
+
+// notModified returns copies of the records cached for the endpoint, stamped
+// with the time the server confirmed them as current.
+func (c *conditionalCache) notModified(source, endpoint string, now time.Time) ([]RangeRecord, bool) {
+	c.mu.Lock()
+	entry, ok := c.entries[conditionalKey(source, endpoint)]
+	c.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	records := make([]RangeRecord, 0, len(entry.records))
+	for _, record := range entry.records {
+		record.Network = cloneIPNet(record.Network)
+		record.Metadata.RetrievedAt = now
+		records = append(records, record)
+	}
+	return records, true
+}
+
+// remember stores the validators of a successful response with its records.
+// Responses without validators are not cached.
+func (c *conditionalCache) remember(source, endpoint string, header http.Header, records []RangeRecord) {
+	etag, lastModified := header.Get("ETag"), header.Get("Last-Modified")
+	if etag == "" && lastModified == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[conditionalKey(source, endpoint)] = conditionalEntry{etag: etag, lastModified: lastModified, records: records}
+}
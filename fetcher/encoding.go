@@ -0,0 +1,91 @@
+package fetcher
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// acceptEncoding is advertised on every range request. Setting it explicitly
+// disables the transport's own gzip handling, so decodeBody must run before
+// the body is parsed.
+const acceptEncoding = "gzip, deflate"
+
+// decodeBody replaces resp.Body with a reader that undoes the response's
+// Content-Encoding. Closing the new body closes the decompressors and the
+// original body. Unknown encodings are rejected rather than parsed as-is.
+func decodeBody(resp *http.Response) error {
+	header := strings.TrimSpace(resp.Header.Get("Content-Encoding"))
+	if header == "" {
+		return nil
+	}
+	codings := strings.Split(header, ",")
+	body := resp.Body
+	closers := []io.Closer{body}
+	var reader io.Reader = body
+	// Codings are listed in the order they were applied, so undo them backwards.
+	for i := len(codings) - 1; i >= 0; i-- {
+		coding := strings.ToLower(strings.TrimSpace(codings[i]))
+		switch coding {
+		case "", "identity":
+			continue
+		case "gzip", "x-gzip":
+			zr, err := gzip.NewReader(reader)
+			if err != nil {
+				return fmt.Errorf("decode gzip response: %w", err)
+			}
+			closers = append(closers, zr)
+			reader = zr
+		case "deflate":
+			zr, err := newDeflateReader(reader)
+			if err != nil {
+				return fmt.Errorf("decode deflate response: %w", err)
+			}
+			closers = append(closers, zr)
+			reader = zr
+		default:
+			return fmt.Errorf("unsupported Content-Encoding %q", coding)
+		}
+	}
+	resp.Body = &decodedBody{Reader: reader, closers: closers}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return nil
+}
+
+// newDeflateReader accepts both zlib-wrapped data, which is what HTTP
+// "deflate" means, and the raw DEFLATE streams some servers send instead.
+func newDeflateReader(r io.Reader) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(r)
+	head, err := buffered.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	if head[0]&0x0f == 8 && (uint16(head[0])<<8|uint16(head[1]))%31 == 0 {
+		return zlib.NewReader(buffered)
+	}
+	return flate.NewReader(buffered), nil
+}
+
+// decodedBody closes the decompressors innermost-last after the original body.
+type decodedBody struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (b *decodedBody) Close() error {
+	var first error
+	for i := len(b.closers) - 1; i >= 0; i-- {
+		if err := b.closers[i].Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
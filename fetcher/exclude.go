@@ -0,0 +1,106 @@
+package fetcher
+
+import (
+	"net"
+	"net/netip"
+)
+
+// SubtractRanges removes the excluded networks from rs. Networks fully inside
+// an exclusion are dropped; networks that only partly overlap one are split
+// into the largest prefixes covering what remains. The per-source sets are
+// trimmed the same way and every remaining piece keeps the SourceCounts entry
+// of the network it came from.
+func SubtractRanges(rs RangeSet, exclude []*net.IPNet) RangeSet {
+	var blocked []netip.Prefix
+	for _, network := range exclude {
+		if prefix, ok := prefixOf(network); ok {
+			blocked = append(blocked, prefix)
+		}
+	}
+	if len(blocked) == 0 {
+		return rs
+	}
+	out := RangeSet{
+		IPv4: subtractNetworks(rs.IPv4, blocked),
+		IPv6: subtractNetworks(rs.IPv6, blocked),
+	}
+	for _, source := range rs.Sources {
+		source.IPv4 = subtractNetworks(source.IPv4, blocked)
+		source.IPv6 = subtractNetworks(source.IPv6, blocked)
+		out.Sources = append(out.Sources, source)
+	}
+	if rs.SourceCounts != nil {
+		out.SourceCounts = map[string]int{}
+		for _, network := range append(append([]*net.IPNet{}, rs.IPv4...), rs.IPv6...) {
+			count, ok := rs.SourceCounts[network.String()]
+			if !ok {
+				continue
+			}
+			for _, piece := range subtractNetworks([]*net.IPNet{network}, blocked) {
+				out.SourceCounts[piece.String()] = max(out.SourceCounts[piece.String()], count)
+			}
+		}
+	}
+	return out
+}
+
+// subtractNetworks removes blocked from every network, keeping networks that
+// cannot be parsed as they are.
+func subtractNetworks(networks []*net.IPNet, blocked []netip.Prefix) []*net.IPNet {
+	var out []*net.IPNet
+	for _, network := range networks {
+		prefix, ok := prefixOf(network)
+		if !ok {
+			if network != nil {
+				out = append(out, network)
+			}
+			continue
+		}
+		pieces := []netip.Prefix{prefix}
+		for _, b := range blocked {
+			var next []netip.Prefix
+			for _, piece := range pieces {
+				next = append(next, subtractPrefix(piece, b)...)
+			}
+			pieces = next
+		}
+		for _, piece := range pieces {
+			out = append(out, ipNetOf(piece))
+		}
+	}
+	return out
+}
+
+// subtractPrefix returns p minus b. When b lies strictly inside p, p is halved
+// repeatedly towards b, keeping the half that does not contain b each time.
+func subtractPrefix(p, b netip.Prefix) []netip.Prefix {
+	if p.Addr().BitLen() != b.Addr().BitLen() || !p.Overlaps(b) {
+		return []netip.Prefix{p}
+	}
+	if b.Bits() <= p.Bits() {
+		return nil
+	}
+	var remaining []netip.Prefix
+	current := p
+	for current.Bits() < b.Bits() {
+		low := netip.PrefixFrom(current.Addr(), current.Bits()+1)
+		high := netip.PrefixFrom(upperHalf(current), current.Bits()+1)
+		if low.Contains(b.Addr()) {
+			remaining = append(remaining, high)
+			current = low
+		} else {
+			remaining = append(remaining, low)
+			current = high
+		}
+	}
+	return remaining
+}
+
+// upperHalf returns the first address of the upper half of p.
+func upperHalf(p netip.Prefix) netip.Addr {
+	bytes := p.Addr().AsSlice()
+	bit := p.Bits()
+	bytes[bit/8] |= 0x80 >> (bit % 8)
+	addr, _ := netip.AddrFromSlice(bytes)
+	return addr
+}
@@ -9,6 +9,7 @@ import (
         "net/http"
         "strings"
         "sync"
+        "time"
 )
 
 // RangeSet groups IPv4 and IPv6 networks for downstream consumers.
@@ -16,6 +17,9 @@ type RangeSet struct {
 	IPv4    []*net.IPNet
 	IPv6    []*net.IPNet
 	Sources []SourceRangeSet
+	// SourceCounts records how many distinct sources listed each network,
+	// keyed by CIDR. It is only populated for aggregated sets.
+	SourceCounts map[string]int
 }
 
 // SourceRangeSet groups networks that originate from the same upstream source.
@@ -33,13 +37,23 @@ type Fetcher struct {
 	cacheDir string
 	mu       sync.RWMutex
 	client   *http.Client
+	breaker  *sourceBreaker
+	retry    retryPolicy
+	coalesce bool
 }
 
 // New creates a fetcher using the provided HTTP client and default sources.
 func New(client *http.Client) *Fetcher {
 	factory := NewProviderFactory(client)
 	cfgs := DefaultSources()
-	return &Fetcher{factory: factory, configs: cfgs, client: factory.client}
+	return &Fetcher{factory: factory, configs: cfgs, client: factory.client, breaker: newSourceBreaker(DefaultBreakerThreshold, DefaultBreakerCooldown), retry: defaultEndpointRetry()}
+}
+
+// NewWithTransport creates a fetcher whose HTTP transport uses the provided
+// connection pool settings.
+func NewWithTransport(client *http.Client, opts TransportOptions) *Fetcher {
+	factory := NewProviderFactoryWithTransport(client, opts)
+	return &Fetcher{factory: factory, configs: DefaultSources(), client: factory.client, breaker: newSourceBreaker(DefaultBreakerThreshold, DefaultBreakerCooldown), retry: defaultEndpointRetry()}
 }
 
 // SetCacheDir enables persistence of aggregated results to disk.
@@ -56,6 +70,51 @@ func (f *Fetcher) CacheDir() string {
 	return f.cacheDir
 }
 
+// SetCircuitBreaker skips a source for cooldown after threshold consecutive
+// fetch failures. A threshold of zero disables skipping; failures are still
+// tracked for SourceHealth.
+func (f *Fetcher) SetCircuitBreaker(threshold int, cooldown time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.breaker = newSourceBreaker(threshold, cooldown)
+}
+
+// SetEndpointRetry sets how often FetchProvider retries a transient endpoint
+// failure (network error, 5xx or 429) and the initial backoff, which doubles
+// per retry. Zero retries disables retrying.
+func (f *Fetcher) SetEndpointRetry(retries int, backoff time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.retry = retryPolicy{retries: retries, backoff: backoff}
+}
+
+func defaultEndpointRetry() retryPolicy {
+	return retryPolicy{retries: DefaultEndpointRetries, backoff: DefaultEndpointBackoff}
+}
+
+// SetCoalesce makes FetchAggregated merge overlapping and adjacent networks
+// across sources, as described for Aggregator.Coalesce.
+func (f *Fetcher) SetCoalesce(enabled bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.coalesce = enabled
+}
+
+// SetHostRateLimit spaces requests to the same host by at least interval,
+// across all sources. Each source's RateLimit is also enforced per host, so
+// mirrors sharing a host observe the combined limit either way.
+func (f *Fetcher) SetHostRateLimit(interval time.Duration) {
+	f.factory.hosts.setMinimum(interval)
+}
+
+// SourceHealth returns the fetch history of every source seen so far.
+func (f *Fetcher) SourceHealth() []SourceHealth {
+	f.mu.RLock()
+	breaker := f.breaker
+	f.mu.RUnlock()
+	return breaker.snapshot()
+}
+
 // UseSources replaces the current source list.
 func (f *Fetcher) UseSources(configs []SourceConfig) {
 	copies := make([]SourceConfig, 0, len(configs))
@@ -94,14 +153,21 @@ func (f *Fetcher) FetchAggregated(ctx context.Context) (AggregatedSet, error) {
 	configs := make([]SourceConfig, len(f.configs))
 	copy(configs, f.configs)
 	cacheDir := f.cacheDir
+	breaker := f.breaker
+	coalesce := f.coalesce
 	f.mu.RUnlock()
 
 	if len(configs) == 0 {
 		return AggregatedSet{}, errors.New("no sources configured")
 	}
 
+	var errs []error
 	providers := make([]*Provider, 0, len(configs))
 	for _, cfg := range configs {
+		if ok, until := breaker.allow(cfg.Name); !ok {
+			errs = append(errs, fmt.Errorf("%s skipped until %s after repeated failures", cfg.Name, until.Format(time.RFC3339)))
+			continue
+		}
 		provider, err := f.factory.Build(cfg)
 		if err != nil {
 			return AggregatedSet{}, err
@@ -110,6 +176,7 @@ func (f *Fetcher) FetchAggregated(ctx context.Context) (AggregatedSet, error) {
 	}
 
 	type result struct {
+		name    string
 		records []RangeRecord
 		err     error
 	}
@@ -121,7 +188,7 @@ func (f *Fetcher) FetchAggregated(ctx context.Context) (AggregatedSet, error) {
 		go func(p *Provider) {
 			defer wg.Done()
 			records, err := p.Fetch(ctx)
-			results <- result{records: records, err: err}
+			results <- result{name: p.config.Name, records: records, err: err}
 		}(provider)
 	}
 	go func() {
@@ -130,8 +197,9 @@ func (f *Fetcher) FetchAggregated(ctx context.Context) (AggregatedSet, error) {
 	}()
 
 	aggregator := NewAggregator()
-	var errs []error
+	aggregator.Coalesce = coalesce
 	for res := range results {
+		breaker.record(res.name, res.err)
 		if len(res.records) > 0 {
 			aggregator.Add(res.records)
 		}
@@ -185,6 +253,19 @@ func (f *Fetcher) FetchProvider(ctx context.Context, provider ProviderSpec) (Sou
 	return SourceRange{Provider: provider, RangeSet: rs}, nil
 }
 
+// ProviderSource fetches a fixed list of providers on every call. It is the
+// network-backed implementation of scheduler.RangeProvider.
+type ProviderSource struct {
+	Fetcher   *Fetcher
+	Providers []ProviderSpec
+}
+
+// Fetch retrieves ranges for every configured provider. Partial failures are
+// returned alongside the ranges that were fetched successfully.
+func (s *ProviderSource) Fetch(ctx context.Context) ([]SourceRange, error) {
+	return s.Fetcher.FetchAll(ctx, s.Providers)
+}
+
 // FetchAll retrieves ranges for the provided set of providers.
 func (f *Fetcher) FetchAll(ctx context.Context, providers []ProviderSpec) ([]SourceRange, error) {
 	if len(providers) == 0 {
@@ -211,10 +292,25 @@ func (f *Fetcher) FetchAll(ctx context.Context, providers []ProviderSpec) ([]Sou
 	return results, nil
 }
 
+// fetchEndpoint fetches and parses one endpoint, retrying transient failures
+// according to the endpoint retry policy.
 func (f *Fetcher) fetchEndpoint(ctx context.Context, endpoint EndpointSpec) ([]*net.IPNet, error) {
 	if endpoint.URL == "" {
 		return nil, nil
 	}
+	f.mu.RLock()
+	policy := f.retry
+	f.mu.RUnlock()
+	var networks []*net.IPNet
+	err := policy.do(ctx, func() error {
+		var err error
+		networks, err = f.fetchEndpointOnce(ctx, endpoint)
+		return err
+	})
+	return networks, err
+}
+
+func (f *Fetcher) fetchEndpointOnce(ctx context.Context, endpoint EndpointSpec) ([]*net.IPNet, error) {
 	client := f.client
 	if client == nil {
 		client = http.DefaultClient
@@ -223,20 +319,29 @@ func (f *Fetcher) fetchEndpoint(ctx context.Context, endpoint EndpointSpec) ([]*
 	if err != nil {
 		return nil, err
 	}
+	req.Header.Set("Accept-Encoding", acceptEncoding)
 	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
+	defer func() { resp.Body.Close() }()
+	// Check the status before decoding so an error response with an empty or
+	// truncated compressed body still surfaces as a retryable statusError.
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
-		return nil, fmt.Errorf("%s 响应异常: %d %s", endpoint.URL, resp.StatusCode, strings.TrimSpace(string(body)))
+		var body []byte
+		if decodeBody(resp) == nil {
+			body, _ = io.ReadAll(io.LimitReader(resp.Body, 1024))
+		}
+		return nil, &statusError{url: endpoint.URL, status: resp.StatusCode, body: strings.TrimSpace(string(body))}
+	}
+	if err := decodeBody(resp); err != nil {
+		return nil, fmt.Errorf("%s: %w", endpoint.URL, err)
 	}
 	switch endpoint.Format {
 	case "", FormatPlainCIDR:
 		return parsePlainCIDR(resp.Body)
 	case FormatJSONArray:
-		return parseJSONArray(resp.Body, endpoint.JSONPath)
+		return parseJSONEndpoint(resp.Body, endpoint)
 	default:
 		return nil, fmt.Errorf("不支持的响应格式: %s", endpoint.Format)
 	}
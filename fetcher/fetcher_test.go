@@ -1,10 +1,14 @@
 package fetcher
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -54,6 +58,14 @@ func TestFetcherFetchAggregatedSuccess(t *testing.T) {
 	if len(rs.IPv4) != 2 || len(rs.IPv6) != 1 {
 		t.Fatalf("unexpected range set sizes: %+v", rs)
 	}
+	_, shared, _ := net.ParseCIDR("1.1.1.0/24")
+	_, single, _ := net.ParseCIDR("8.8.8.0/24")
+	if got := rs.SourceCount(shared); got != 2 {
+		t.Fatalf("expected 1.1.1.0/24 to be listed by 2 sources, got %d", got)
+	}
+	if got := rs.SourceCount(single); got != 1 {
+		t.Fatalf("expected 8.8.8.0/24 to be listed by 1 source, got %d", got)
+	}
 }
 
 func TestFetcherFetchAggregatedFallback(t *testing.T) {
@@ -132,6 +144,208 @@ func TestFetcherFetchProvider(t *testing.T) {
 	}
 }
 
+func gzipBytes(t *testing.T, payload string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(payload)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestFetchDecodesGzipResponses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			t.Errorf("expected Accept-Encoding to advertise gzip, got %q", r.Header.Get("Accept-Encoding"))
+		}
+		switch r.URL.Path {
+		case "/plain":
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write(gzipBytes(t, "1.2.3.0/24\n5.6.7.0/24\n"))
+		case "/json":
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write(gzipBytes(t, `{"result":{"ipv4_cidrs":["9.9.9.0/24","8.8.8.8"]}}`))
+		case "/brotli":
+			w.Header().Set("Content-Encoding", "br")
+			w.Write([]byte("not really brotli"))
+		}
+	}))
+	defer server.Close()
+
+	f := New(server.Client())
+	f.UseSources([]SourceConfig{{Name: "mirror", Endpoints: []string{server.URL + "/plain"}, Parser: ParseCIDRList, Credibility: 1}})
+	set, err := f.FetchAggregated(context.Background())
+	if err != nil {
+		t.Fatalf("FetchAggregated() error = %v", err)
+	}
+	if len(set.Entries) != 2 {
+		t.Fatalf("expected 2 entries from the gzip CIDR list, got %d", len(set.Entries))
+	}
+
+	src, err := f.FetchProvider(context.Background(), ProviderSpec{
+		Name: "bestip",
+		IPv4: EndpointSpec{URL: server.URL + "/json", Format: FormatJSONArray, JSONPath: []string{"result", "ipv4_cidrs"}},
+	})
+	if err != nil {
+		t.Fatalf("FetchProvider() error = %v", err)
+	}
+	if len(src.RangeSet.IPv4) != 2 || src.RangeSet.IPv4[0].String() != "9.9.9.0/24" {
+		t.Fatalf("unexpected networks from the gzip JSON array: %v", src.RangeSet.IPv4)
+	}
+
+	f.SetEndpointRetry(0, 0)
+	_, err = f.FetchProvider(context.Background(), ProviderSpec{Name: "odd", IPv4: EndpointSpec{URL: server.URL + "/brotli"}})
+	if err == nil || !strings.Contains(err.Error(), `unsupported Content-Encoding "br"`) {
+		t.Fatalf("expected an unsupported encoding error, got %v", err)
+	}
+}
+
+func TestFetchProviderRetriesTransientFailures(t *testing.T) {
+	var mu sync.Mutex
+	hits := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		n := hits[r.URL.Path]
+		mu.Unlock()
+		switch {
+		case r.URL.Path == "/flaky" && n == 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case r.URL.Path == "/compressed" && n == 1:
+			w.Header().Set("Content-Encoding", "gzip")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case r.URL.Path == "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.Write([]byte("1.2.3.0/24\n"))
+		}
+	}))
+	defer server.Close()
+
+	f := New(server.Client())
+	f.SetEndpointRetry(2, time.Millisecond)
+	src, err := f.FetchProvider(context.Background(), ProviderSpec{Name: "flaky", IPv4: EndpointSpec{URL: server.URL + "/flaky", Format: FormatPlainCIDR}})
+	if err != nil {
+		t.Fatalf("expected the retry to succeed, got %v", err)
+	}
+	if len(src.RangeSet.IPv4) != 1 || hits["/flaky"] != 2 {
+		t.Fatalf("expected one retry and the ranges, got %d hits %+v", hits["/flaky"], src.RangeSet)
+	}
+
+	src, err = f.FetchProvider(context.Background(), ProviderSpec{Name: "compressed", IPv4: EndpointSpec{URL: server.URL + "/compressed", Format: FormatPlainCIDR}})
+	if err != nil {
+		t.Fatalf("expected a compressed 503 with an empty body to be retried, got %v", err)
+	}
+	if len(src.RangeSet.IPv4) != 1 || hits["/compressed"] != 2 {
+		t.Fatalf("expected one retry and the ranges, got %d hits %+v", hits["/compressed"], src.RangeSet)
+	}
+
+	if _, err := f.FetchProvider(context.Background(), ProviderSpec{Name: "missing", IPv4: EndpointSpec{URL: server.URL + "/missing", Format: FormatPlainCIDR}}); err == nil {
+		t.Fatal("expected a 404 to fail")
+	}
+	if hits["/missing"] != 1 {
+		t.Fatalf("expected 4xx not to be retried, got %d requests", hits["/missing"])
+	}
+}
+
+func TestAggregatorCoalesce(t *testing.T) {
+	record := func(cidr, source string) RangeRecord {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return RangeRecord{Network: network, Metadata: RangeMetadata{Source: source, Endpoint: "https://" + source, Credibility: 1}}
+	}
+	agg := NewAggregator()
+	agg.Coalesce = true
+	agg.Add([]RangeRecord{
+		// Containment: the /24 and /20 fold into the /16.
+		record("1.1.0.0/16", "official"),
+		record("1.1.1.0/24", "mirror"),
+		record("1.1.16.0/20", "official"),
+		// Adjacency: four /24s collapse into one /22.
+		record("10.0.0.0/24", "a"),
+		record("10.0.1.0/24", "b"),
+		record("10.0.2.0/24", "a"),
+		record("10.0.3.0/24", "c"),
+		// Adjacent but not siblings: these stay apart.
+		record("10.0.5.0/24", "a"),
+		record("10.0.6.0/24", "a"),
+		record("2001:db8::/33", "official"),
+		record("2001:db8:8000::/33", "mirror"),
+	})
+	set := agg.Result()
+	var got []string
+	for _, entry := range set.Entries {
+		got = append(got, entry.Network.String())
+	}
+	want := []string{"1.1.0.0/16", "10.0.0.0/22", "10.0.5.0/24", "10.0.6.0/24", "2001:db8::/32"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("coalesced networks = %v, want %v", got, want)
+	}
+	sources := func(entry RangeEntry) string {
+		var names []string
+		for _, meta := range entry.Metadata {
+			names = append(names, meta.Source)
+		}
+		return strings.Join(names, ",")
+	}
+	if s := sources(set.Entries[0]); s != "mirror,official" {
+		t.Fatalf("expected the /16 to keep the union of its contributors, got %s", s)
+	}
+	if s := sources(set.Entries[1]); s != "a,b,c" {
+		t.Fatalf("expected the /22 to keep the union of its contributors, got %s", s)
+	}
+	if s := sources(set.Entries[4]); s != "mirror,official" {
+		t.Fatalf("expected the IPv6 /32 to keep both halves' metadata, got %s", s)
+	}
+
+	plain := NewAggregator()
+	plain.Add([]RangeRecord{record("1.1.0.0/16", "official"), record("1.1.1.0/24", "mirror")})
+	if n := len(plain.Result().Entries); n != 2 {
+		t.Fatalf("expected entries to stay separate without Coalesce, got %d", n)
+	}
+}
+
+func TestSubtractRanges(t *testing.T) {
+	cidr := func(value string) *net.IPNet {
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return network
+	}
+	rs := RangeSet{
+		IPv4:         []*net.IPNet{cidr("1.1.1.0/24"), cidr("10.0.0.0/22"), cidr("192.0.2.0/24")},
+		IPv6:         []*net.IPNet{cidr("2001:db8::/32")},
+		Sources:      []SourceRangeSet{{Name: "official", IPv4: []*net.IPNet{cidr("10.0.0.0/22")}}},
+		SourceCounts: map[string]int{"10.0.0.0/22": 2},
+	}
+	trimmed := SubtractRanges(rs, []*net.IPNet{cidr("1.0.0.0/8"), cidr("10.0.1.0/24"), cidr("2001:db8:8000::/33")})
+	var got []string
+	for _, network := range trimmed.IPv4 {
+		got = append(got, network.String())
+	}
+	// 1.1.1.0/24 is fully excluded; the /22 loses 10.0.1.0/24 and splits.
+	want := []string{"10.0.2.0/23", "10.0.0.0/24", "192.0.2.0/24"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("IPv4 after exclusion = %v, want %v", got, want)
+	}
+	if len(trimmed.IPv6) != 1 || trimmed.IPv6[0].String() != "2001:db8::/33" {
+		t.Fatalf("IPv6 after exclusion = %v, want [2001:db8::/33]", trimmed.IPv6)
+	}
+	if len(trimmed.Sources) != 1 || len(trimmed.Sources[0].IPv4) != 2 {
+		t.Fatalf("expected the per-source set to be split too, got %+v", trimmed.Sources)
+	}
+	if trimmed.SourceCounts["10.0.0.0/24"] != 2 || trimmed.SourceCounts["10.0.2.0/23"] != 2 {
+		t.Fatalf("expected split pieces to keep the source count, got %v", trimmed.SourceCounts)
+	}
+}
+
 func TestDeduplicateRanges(t *testing.T) {
 	_, ipNet1, _ := net.ParseCIDR("1.1.1.0/24")
 	_, ipNet2, _ := net.ParseCIDR("1.1.1.0/24")
@@ -141,3 +355,294 @@ func TestDeduplicateRanges(t *testing.T) {
 		t.Fatalf("expected single entry after dedupe, got %d", len(deduped.IPv4))
 	}
 }
+
+func TestNewWithTransportAppliesOptions(t *testing.T) {
+	opts := TransportOptions{MaxIdleConns: 42, MaxIdleConnsPerHost: 7, MaxConnsPerHost: 3, IdleConnTimeout: 5 * time.Second}
+	f := NewWithTransport(nil, opts)
+	transport, ok := f.client.Transport.(*http.Transport)
+	if !ok {
+		t.Fatalf("expected *http.Transport, got %T", f.client.Transport)
+	}
+	if transport.MaxIdleConns != 42 || transport.MaxIdleConnsPerHost != 7 || transport.MaxConnsPerHost != 3 || transport.IdleConnTimeout != 5*time.Second {
+		t.Fatalf("transport options not applied: %+v", transport)
+	}
+	if f.client.Timeout != 30*time.Second {
+		t.Fatalf("expected default timeout, got %s", f.client.Timeout)
+	}
+
+	defaults := New(nil).client.Transport.(*http.Transport)
+	if defaults.MaxIdleConnsPerHost != DefaultTransportOptions().MaxIdleConnsPerHost {
+		t.Fatalf("expected default pool settings, got %d", defaults.MaxIdleConnsPerHost)
+	}
+
+	caller := &http.Client{Timeout: time.Second}
+	tuned := NewWithTransport(caller, opts)
+	if caller.Transport != nil {
+		t.Fatalf("expected caller client to be left untouched")
+	}
+	if tuned.client.Timeout != time.Second {
+		t.Fatalf("expected caller timeout to be preserved, got %s", tuned.client.Timeout)
+	}
+}
+
+func TestProviderFetchRetriesTransientFailures(t *testing.T) {
+	var mu sync.Mutex
+	hits := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		n := hits[r.URL.Path]
+		mu.Unlock()
+		switch {
+		case r.URL.Path == "/flaky" && n <= 2:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case r.URL.Path == "/flaky":
+			w.Write([]byte("1.1.1.0/24\n"))
+		case r.URL.Path == "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer server.Close()
+
+	f := New(server.Client())
+	f.UseSources([]SourceConfig{
+		{Name: "flaky", Endpoints: []string{server.URL + "/flaky"}, Parser: ParseCIDRList, Credibility: 1, Retries: 2, RetryBackoff: time.Millisecond},
+		{Name: "missing", Endpoints: []string{server.URL + "/missing"}, Parser: ParseCIDRList, Credibility: 1, Retries: 2, RetryBackoff: time.Millisecond},
+		{Name: "limited", Endpoints: []string{server.URL + "/limited"}, Parser: ParseCIDRList, Credibility: 1, Retries: 1, RetryBackoff: time.Millisecond},
+	})
+	set, err := f.FetchAggregated(context.Background())
+	if len(set.Entries) != 1 {
+		t.Fatalf("expected the flaky source to recover after retries, got %d entries (err %v)", len(set.Entries), err)
+	}
+	if hits["/flaky"] != 3 {
+		t.Fatalf("expected 3 attempts for the flaky endpoint, got %d", hits["/flaky"])
+	}
+	if hits["/missing"] != 1 {
+		t.Fatalf("expected 4xx responses not to be retried, got %d attempts", hits["/missing"])
+	}
+	if hits["/limited"] != 2 {
+		t.Fatalf("expected 429 to be retried once, got %d attempts", hits["/limited"])
+	}
+	if err == nil || !strings.Contains(err.Error(), "limited returned 429 (2 attempts)") {
+		t.Fatalf("expected the final error to report the attempts, got %v", err)
+	}
+}
+
+func TestFetcherCircuitBreakerSkipsFailingSource(t *testing.T) {
+	var flakyHits int
+	failing := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/good":
+			w.Write([]byte("1.1.1.0/24\n"))
+		case "/flaky":
+			flakyHits++
+			if failing {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			w.Write([]byte("8.8.8.0/24\n"))
+		}
+	}))
+	defer server.Close()
+
+	f := New(server.Client())
+	f.SetCircuitBreaker(2, time.Minute)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	f.breaker.now = func() time.Time { return now }
+	f.UseSources([]SourceConfig{
+		{Name: "good", Endpoints: []string{server.URL + "/good"}, Parser: ParseCIDRList, Credibility: 1},
+		{Name: "flaky", Endpoints: []string{server.URL + "/flaky"}, Parser: ParseCIDRList, Credibility: 0.5},
+	})
+
+	for i := 0; i < 3; i++ {
+		if _, err := f.FetchAggregated(context.Background()); err == nil {
+			t.Fatalf("cycle %d: expected flaky source to be reported", i)
+		}
+	}
+	if flakyHits != 2 {
+		t.Fatalf("expected flaky source to be skipped after 2 failures, got %d hits", flakyHits)
+	}
+	health := f.SourceHealth()
+	if len(health) != 2 || health[0].Name != "flaky" || health[0].ConsecutiveFailures != 2 || health[0].SkippedUntil.IsZero() {
+		t.Fatalf("unexpected health %+v", health)
+	}
+	if health[1].Name != "good" || health[1].LastSuccess.IsZero() || !health[1].SkippedUntil.IsZero() {
+		t.Fatalf("unexpected good source health %+v", health[1])
+	}
+
+	now = now.Add(2 * time.Minute)
+	failing = false
+	aggregated, err := f.FetchAggregated(context.Background())
+	if err != nil {
+		t.Fatalf("expected recovery after cooldown, got %v", err)
+	}
+	if flakyHits != 3 || len(aggregated.Entries) != 2 {
+		t.Fatalf("expected flaky source retried after cooldown, hits %d entries %d", flakyHits, len(aggregated.Entries))
+	}
+	if h := f.SourceHealth()[0]; h.ConsecutiveFailures != 0 || !h.SkippedUntil.IsZero() {
+		t.Fatalf("expected breaker reset after success, got %+v", h)
+	}
+}
+
+func TestHostRateLimitSharedAcrossSources(t *testing.T) {
+	var mu sync.Mutex
+	var hits []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits = append(hits, time.Now())
+		mu.Unlock()
+		w.Write([]byte("1.1.1.0/24\n"))
+	}))
+	defer server.Close()
+
+	const limit = 100 * time.Millisecond
+	f := New(server.Client())
+	f.UseSources([]SourceConfig{
+		{Name: "mirror-a", Endpoints: []string{server.URL + "/a"}, Parser: ParseCIDRList, RateLimit: limit, Credibility: 1},
+		{Name: "mirror-b", Endpoints: []string{server.URL + "/b"}, Parser: ParseCIDRList, RateLimit: limit, Credibility: 1},
+	})
+	if _, err := f.FetchAggregated(context.Background()); err != nil {
+		t.Fatalf("FetchAggregated() error = %v", err)
+	}
+	if len(hits) != 2 {
+		t.Fatalf("expected one request per source, got %d", len(hits))
+	}
+	gap := hits[1].Sub(hits[0])
+	if gap < 0 {
+		gap = -gap
+	}
+	if gap < limit-10*time.Millisecond {
+		t.Fatalf("expected requests to the shared host spaced by %s, got %s", limit, gap)
+	}
+}
+
+func TestProviderConditionalGetReusesRecordsOn304(t *testing.T) {
+	const etag = `"v1"`
+	const modified = "Mon, 02 Jan 2006 15:04:05 GMT"
+	var requests int
+	var gotETag, gotModified string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		gotETag, gotModified = r.Header.Get("If-None-Match"), r.Header.Get("If-Modified-Since")
+		if gotETag == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", modified)
+		w.Write([]byte("1.1.1.0/24\n2.2.2.0/24\n"))
+	}))
+	defer server.Close()
+
+	// The space is escaped when req.URL is rendered, so the cache must key on
+	// the configured endpoint.
+	for _, endpoint := range []string{server.URL, server.URL + "/ranges v4"} {
+		requests = 0
+		f := New(server.Client())
+		f.UseSources([]SourceConfig{{Name: "cf", Endpoints: []string{endpoint}, Parser: ParseCIDRList, Credibility: 1}})
+		first, err := f.FetchAggregated(context.Background())
+		if err != nil {
+			t.Fatalf("first FetchAggregated() error = %v", err)
+		}
+		if gotETag != "" || gotModified != "" {
+			t.Fatalf("first request should be unconditional, got If-None-Match=%q If-Modified-Since=%q", gotETag, gotModified)
+		}
+		second, err := f.FetchAggregated(context.Background())
+		if err != nil {
+			t.Fatalf("second FetchAggregated() error = %v", err)
+		}
+		if requests != 2 {
+			t.Fatalf("expected 2 requests, got %d", requests)
+		}
+		if gotETag != etag || gotModified != modified {
+			t.Fatalf("expected conditional headers, got If-None-Match=%q If-Modified-Since=%q", gotETag, gotModified)
+		}
+		if len(second.Entries) != 2 || len(second.Entries) != len(first.Entries) {
+			t.Fatalf("expected the cached entries on 304, got %d (first %d)", len(second.Entries), len(first.Entries))
+		}
+		for i := range first.Entries {
+			if first.Entries[i].Network.String() != second.Entries[i].Network.String() {
+				t.Fatalf("entry %d = %s, want %s", i, second.Entries[i].Network, first.Entries[i].Network)
+			}
+		}
+	}
+}
+
+func TestParseJSONArrayItemKey(t *testing.T) {
+	payload := `{"data":{"items":[{"ip":"1.2.3.4","colo":"SJC"},{"ip":"5.6.7.0/24"},{"other":"x"},"9.9.9.9"]}}`
+	networks, err := parseJSONArray(strings.NewReader(payload), []string{"data", "items"}, "ip")
+	if err != nil {
+		t.Fatalf("parseJSONArray error = %v", err)
+	}
+	if len(networks) != 3 || networks[0].String() != "1.2.3.4/32" || networks[1].String() != "5.6.7.0/24" || networks[2].String() != "9.9.9.9/32" {
+		t.Fatalf("unexpected networks %v", networks)
+	}
+
+	bare, err := parseJSONArray(strings.NewReader(`["1.1.1.0/24"]`), nil, "")
+	if err != nil || len(bare) != 1 {
+		t.Fatalf("expected bare string arrays to keep working, got %v %v", bare, err)
+	}
+}
+
+func TestParseJSONArrayPathIndices(t *testing.T) {
+	payload := `{"result":[{"ips":["1.1.1.0/24","2.2.2.2"]},{"ips":["3.3.3.0/24"]}]}`
+	networks, err := parseJSONArray(strings.NewReader(payload), []string{"result", "1", "ips"}, "")
+	if err != nil {
+		t.Fatalf("parseJSONArray error = %v", err)
+	}
+	if len(networks) != 1 || networks[0].String() != "3.3.3.0/24" {
+		t.Fatalf("unexpected networks %v", networks)
+	}
+
+	_, err = parseJSONArray(strings.NewReader(payload), []string{"result", "2", "ips"}, "")
+	if err == nil || !strings.Contains(err.Error(), `"2"`) || !strings.Contains(err.Error(), "越界") {
+		t.Fatalf("expected an out-of-bounds error naming the segment, got %v", err)
+	}
+}
+
+func TestParseJSONArrayMixedObjectArrayPath(t *testing.T) {
+	payload := `{"data":[{"region":"us","nodes":[{"ip":"1.2.3.4"},{"ip":"5.6.7.0/24"}]},{"region":"eu","nodes":[{"ip":"9.9.9.9"}]},{"region":"empty"}]}`
+	networks, err := parseJSONArray(strings.NewReader(payload), []string{"data", "nodes"}, "ip")
+	if err != nil {
+		t.Fatalf("parseJSONArray error = %v", err)
+	}
+	var got []string
+	for _, network := range networks {
+		got = append(got, network.String())
+	}
+	if strings.Join(got, ",") != "1.2.3.4/32,5.6.7.0/24,9.9.9.9/32" {
+		t.Fatalf("unexpected networks %v", got)
+	}
+}
+
+func TestParseJSONEndpointFlattensNestedValues(t *testing.T) {
+	payload := `{"data":{"us":["1.1.1.0/24"],"eu":["2.2.2.0/24"],"updated":"2024-01-01"}}`
+	networks, err := parseJSONEndpoint(strings.NewReader(payload), EndpointSpec{JSONPath: []string{"data"}, MapValues: true})
+	if err != nil {
+		t.Fatalf("parseJSONEndpoint error = %v", err)
+	}
+	got := map[string]bool{}
+	for _, network := range networks {
+		got[network.String()] = true
+	}
+	if len(networks) != 2 || !got["1.1.1.0/24"] || !got["2.2.2.0/24"] {
+		t.Fatalf("expected both regions collected, got %v", networks)
+	}
+
+	if _, err := parseJSONEndpoint(strings.NewReader(payload), EndpointSpec{JSONPath: []string{"data"}}); err == nil {
+		t.Fatal("expected objects to be rejected without MapValues")
+	}
+
+	nested, err := parseJSONArray(strings.NewReader(`[["1.1.1.0/24",["2.2.2.0/24"]],"3.3.3.3"]`), nil, "")
+	if err != nil || len(nested) != 3 {
+		t.Fatalf("expected nested arrays flattened, got %v %v", nested, err)
+	}
+
+	deep := strings.Repeat("[", 20) + `"1.1.1.1"` + strings.Repeat("]", 20)
+	if _, err := parseJSONEndpoint(strings.NewReader(deep), EndpointSpec{MaxDepth: 4}); err == nil {
+		t.Fatal("expected nesting beyond MaxDepth to fail")
+	}
+}
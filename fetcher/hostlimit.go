@@ -0,0 +1,77 @@
+package fetcher
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// hostLimiter spaces requests by target host so that sources sharing a host,
+// such as mirrors of one another, respect a combined rate limit. Each request
+// reserves the next free slot for its host, so waiting happens without
+// holding the lock.
+type hostLimiter struct {
+	mu      sync.Mutex
+	minimum time.Duration
+	now     func() time.Time
+	next    map[string]time.Time
+}
+
+func newHostLimiter() *hostLimiter {
+	return &hostLimiter{now: time.Now, next: make(map[string]time.Time)}
+}
+
+// setMinimum sets the spacing applied to every host regardless of the
+// requesting source's own rate limit.
+func (l *hostLimiter) setMinimum(interval time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.minimum = interval
+}
+
+// wait blocks until endpoint's host may be contacted again. interval is the
+// requesting source's rate limit; the larger of it and the host minimum
+// separates this request from the next one to the same host.
+func (l *hostLimiter) wait(ctx context.Context, endpoint string, interval time.Duration) error {
+	host := endpointHost(endpoint)
+	l.mu.Lock()
+	if l.minimum > interval {
+		interval = l.minimum
+	}
+	if interval <= 0 || host == "" {
+		l.mu.Unlock()
+		return nil
+	}
+	now := l.now()
+	slot := l.next[host]
+	if slot.Before(now) {
+		slot = now
+	}
+	l.next[host] = slot.Add(interval)
+	l.mu.Unlock()
+
+	delay := slot.Sub(now)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// endpointHost returns the lower-cased host:port of endpoint, or "" when it
+// cannot be parsed.
+func endpointHost(endpoint string) string {
+	parsed, err := url.Parse(endpoint)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(parsed.Host)
+}
@@ -9,6 +9,8 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -32,8 +34,21 @@ type EndpointSpec struct {
 	URL      string
 	Format   ResponseFormat
 	JSONPath []string
+	// ItemKey extracts the CIDR from object elements such as {"ip": "1.2.3.4"}.
+	// Bare string elements are always accepted.
+	ItemKey string
+	// MapValues also walks the values of objects, such as responses keyed by
+	// region, collecting every string leaf that parses as a CIDR or IP.
+	MapValues bool
+	// MaxDepth bounds how deeply nested arrays and objects are flattened.
+	// Zero uses DefaultJSONMaxDepth.
+	MaxDepth int
 }
 
+// DefaultJSONMaxDepth is the nesting limit applied when EndpointSpec.MaxDepth
+// is unset.
+const DefaultJSONMaxDepth = 8
+
 type ProviderSpec struct {
 	Name        string
 	DisplayName string
@@ -43,6 +58,9 @@ type ProviderSpec struct {
 	IPv4        EndpointSpec
 	IPv6        EndpointSpec
 	Enabled     bool
+	// Domain overrides the scan domain (SNI and Host) for candidates drawn
+	// from this provider. Empty uses the scan default.
+	Domain string
 }
 
 type SourceRange struct {
@@ -168,36 +186,127 @@ func parsePlainCIDR(r io.Reader) ([]*net.IPNet, error) {
 	return networks, nil
 }
 
-func parseJSONArray(r io.Reader, path []string) ([]*net.IPNet, error) {
+func parseJSONArray(r io.Reader, path []string, itemKey string) ([]*net.IPNet, error) {
+	return parseJSONEndpoint(r, EndpointSpec{JSONPath: path, ItemKey: itemKey})
+}
+
+// parseJSONEndpoint walks endpoint.JSONPath and flattens the value found there.
+// Nested arrays are always flattened; objects are walked only when
+// endpoint.MapValues is set.
+func parseJSONEndpoint(r io.Reader, endpoint EndpointSpec) ([]*net.IPNet, error) {
 	var payload any
 	if err := json.NewDecoder(r).Decode(&payload); err != nil {
 		return nil, err
 	}
-	target := payload
-	for _, key := range path {
-		asMap, ok := target.(map[string]any)
-		if !ok {
-			return nil, fmt.Errorf("JSON 路径 %v 不存在", path)
-		}
-		target = asMap[key]
+	target, err := walkJSONPath(payload, endpoint.JSONPath)
+	if err != nil {
+		return nil, err
 	}
-	rawList, ok := target.([]any)
-	if !ok {
+	switch target.(type) {
+	case []any:
+	case map[string]any:
+		if !endpoint.MapValues {
+			return nil, errors.New("目标字段不是数组")
+		}
+	default:
 		return nil, errors.New("目标字段不是数组")
 	}
-	networks := make([]*net.IPNet, 0, len(rawList))
-	for _, item := range rawList {
-		str, ok := item.(string)
-		if !ok {
-			continue
+	maxDepth := endpoint.MaxDepth
+	if maxDepth <= 0 {
+		maxDepth = DefaultJSONMaxDepth
+	}
+	c := jsonCollector{itemKey: endpoint.ItemKey, mapValues: endpoint.MapValues, maxDepth: maxDepth}
+	if err := c.collect(target, 0, false); err != nil {
+		return nil, err
+	}
+	return c.networks, nil
+}
+
+// walkJSONPath follows path from value. On arrays, numeric segments select an
+// element and other segments are applied to every element, gathering the
+// results into an array; on objects every segment is a key.
+func walkJSONPath(value any, path []string) (any, error) {
+	target := value
+	for i, segment := range path {
+		switch v := target.(type) {
+		case map[string]any:
+			target = v[segment]
+		case []any:
+			if index, err := strconv.Atoi(segment); err == nil {
+				if index < 0 || index >= len(v) {
+					return nil, fmt.Errorf("JSON 路径 %v 第 %d 段 %q 越界: 数组长度为 %d", path, i+1, segment, len(v))
+				}
+				target = v[index]
+				continue
+			}
+			gathered := make([]any, 0, len(v))
+			for _, element := range v {
+				if asMap, ok := element.(map[string]any); ok {
+					if field, ok := asMap[segment]; ok {
+						gathered = append(gathered, field)
+					}
+				}
+			}
+			target = gathered
+		default:
+			return nil, fmt.Errorf("JSON 路径 %v 第 %d 段 %q 不存在", path, i+1, segment)
 		}
-		network, err := parseNetwork(str)
+	}
+	return target, nil
+}
+
+// jsonCollector gathers networks from a decoded JSON value.
+type jsonCollector struct {
+	itemKey   string
+	mapValues bool
+	maxDepth  int
+	networks  []*net.IPNet
+}
+
+// collect adds the networks found in value. Strings inside arrays must parse;
+// strings reached through object values are skipped when they do not, since
+// region maps commonly carry metadata next to the ranges.
+func (c *jsonCollector) collect(value any, depth int, lenient bool) error {
+	if depth > c.maxDepth {
+		return fmt.Errorf("JSON 嵌套超过 %d 层", c.maxDepth)
+	}
+	switch v := value.(type) {
+	case string:
+		network, err := parseNetwork(v)
 		if err != nil {
-			return nil, err
+			if lenient {
+				return nil
+			}
+			return err
+		}
+		c.networks = append(c.networks, network)
+	case []any:
+		for _, item := range v {
+			if err := c.collect(item, depth+1, false); err != nil {
+				return err
+			}
+		}
+	case map[string]any:
+		if c.itemKey != "" {
+			if str, ok := v[c.itemKey].(string); ok {
+				return c.collect(str, depth, false)
+			}
+		}
+		if !c.mapValues {
+			return nil
+		}
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if err := c.collect(v[key], depth+1, true); err != nil {
+				return err
+			}
 		}
-		networks = append(networks, network)
 	}
-	return networks, nil
+	return nil
 }
 
 func parseNetwork(value string) (*net.IPNet, error) {
@@ -263,6 +372,12 @@ type SourceConfig struct {
 	Signer      Signer
 	RateLimit   time.Duration
 	Credibility float64
+	// Retries is how many times Provider.Fetch retries an endpoint after a
+	// network error or a 5xx/429 response. Zero disables retrying.
+	Retries int
+	// RetryBackoff is the delay before the first retry; it doubles with
+	// every further attempt. Zero uses DefaultEndpointBackoff.
+	RetryBackoff time.Duration
 }
 
 // Validate ensures the source configuration is well formed.
@@ -284,6 +399,9 @@ func (c SourceConfig) Validate() error {
 	if c.Credibility <= 0 {
 		return fmt.Errorf("source %s must declare a positive credibility", c.Name)
 	}
+	if c.Retries < 0 || c.RetryBackoff < 0 {
+		return fmt.Errorf("source %s retries and retry backoff must not be negative", c.Name)
+	}
 	return nil
 }
 
@@ -396,76 +514,106 @@ func ParseCIDRList(ctx context.Context, resp *http.Response) ([]*net.IPNet, erro
 
 // ProviderFactory constructs providers with a shared HTTP client.
 type ProviderFactory struct {
-	client *http.Client
+	client      *http.Client
+	hosts       *hostLimiter
+	conditional *conditionalCache
+}
+
+// TransportOptions tunes the connection pool used for range fetches.
+type TransportOptions struct {
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	MaxConnsPerHost     int
+	IdleConnTimeout     time.Duration
+}
+
+// DefaultTransportOptions returns pool settings suited to fetching many custom
+// sources in parallel. MaxConnsPerHost of zero means unlimited.
+func DefaultTransportOptions() TransportOptions {
+	return TransportOptions{
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 16,
+		IdleConnTimeout:     90 * time.Second,
+	}
 }
 
+func (o TransportOptions) apply(t *http.Transport) {
+	t.MaxIdleConns = o.MaxIdleConns
+	t.MaxIdleConnsPerHost = o.MaxIdleConnsPerHost
+	t.MaxConnsPerHost = o.MaxConnsPerHost
+	t.IdleConnTimeout = o.IdleConnTimeout
+}
+
+// NewProviderFactory uses client as-is, or builds one with the default
+// transport options when client is nil.
 func NewProviderFactory(client *http.Client) *ProviderFactory {
 	if client == nil {
-		client = &http.Client{Timeout: 30 * time.Second}
+		return NewProviderFactoryWithTransport(nil, DefaultTransportOptions())
 	}
 	if client.Timeout == 0 {
 		client.Timeout = 30 * time.Second
 	}
-	return &ProviderFactory{client: client}
+	return &ProviderFactory{client: client, hosts: newHostLimiter(), conditional: newConditionalCache()}
+}
+
+// NewProviderFactoryWithTransport applies opts to a copy of the client's
+// transport (or a clone of http.DefaultTransport when it has none).
+func NewProviderFactoryWithTransport(client *http.Client, opts TransportOptions) *ProviderFactory {
+	tuned := &http.Client{Timeout: 30 * time.Second}
+	if client != nil {
+		*tuned = *client
+		if tuned.Timeout == 0 {
+			tuned.Timeout = 30 * time.Second
+		}
+	}
+	base, ok := tuned.Transport.(*http.Transport)
+	if !ok || base == nil {
+		base = http.DefaultTransport.(*http.Transport)
+	}
+	transport := base.Clone()
+	opts.apply(transport)
+	tuned.Transport = transport
+	return &ProviderFactory{client: tuned, hosts: newHostLimiter(), conditional: newConditionalCache()}
 }
 
 func (f *ProviderFactory) Build(cfg SourceConfig) (*Provider, error) {
 	if err := cfg.Validate(); err != nil {
 		return nil, err
 	}
-	return &Provider{config: cfg, client: f.client}, nil
+	return &Provider{config: cfg, client: f.client, hosts: f.hosts, conditional: f.conditional}, nil
 }
 
 type Provider struct {
 	config SourceConfig
 	client *http.Client
-	mu     sync.Mutex
-	last   time.Time
+	hosts  *hostLimiter
+	// conditional, when set, turns repeat fetches into conditional GETs.
+	conditional *conditionalCache
+	mu          sync.Mutex
+	last        time.Time
 }
 
 func (p *Provider) Fetch(ctx context.Context) ([]RangeRecord, error) {
+	policy := retryPolicy{retries: p.config.Retries, backoff: p.config.RetryBackoff}
+	if policy.backoff <= 0 {
+		policy.backoff = DefaultEndpointBackoff
+	}
 	var aggregated []RangeRecord
 	var errs []error
 	for _, endpoint := range p.config.Endpoints {
-		if err := p.waitForRateLimit(ctx); err != nil {
-			return nil, err
-		}
-		req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
-		if err != nil {
-			errs = append(errs, err)
-			continue
-		}
-		if p.config.Signer != nil {
-			p.config.Signer(req)
-		}
-		resp, err := p.client.Do(req)
-		if err != nil {
-			errs = append(errs, err)
-			continue
-		}
-		if resp.StatusCode != http.StatusOK {
-			resp.Body.Close()
-			errs = append(errs, fmt.Errorf("%s returned %d", p.config.Name, resp.StatusCode))
-			continue
-		}
-		networks, err := p.config.Parser(ctx, resp)
+		var records []RangeRecord
+		err := policy.do(ctx, func() error {
+			var err error
+			records, err = p.fetchEndpoint(ctx, endpoint)
+			return err
+		})
 		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
 			errs = append(errs, err)
 			continue
 		}
-		ts := time.Now().UTC()
-		records := make([]RangeRecord, 0, len(networks))
-		for _, network := range networks {
-			records = append(records, RangeRecord{
-				Network: cloneIPNet(network),
-				Metadata: RangeMetadata{
-					Source:      p.config.Name,
-					Endpoint:    endpoint,
-					RetrievedAt: ts,
-					Credibility: p.config.Credibility,
-				},
-			})
-		}
 		aggregated = append(aggregated, records...)
 	}
 	if len(aggregated) > 0 {
@@ -477,6 +625,70 @@ func (p *Provider) Fetch(ctx context.Context) ([]RangeRecord, error) {
 	return nil, errors.Join(errs...)
 }
 
+// fetchEndpoint makes one rate-limited request to endpoint and parses the
+// response into records.
+func (p *Provider) fetchEndpoint(ctx context.Context, endpoint string) ([]RangeRecord, error) {
+	if err := p.waitForRateLimit(ctx); err != nil {
+		return nil, err
+	}
+	if p.hosts != nil {
+		if err := p.hosts.wait(ctx, endpoint, p.config.RateLimit); err != nil {
+			return nil, err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+	if p.conditional != nil {
+		p.conditional.prepare(p.config.Name, endpoint, req)
+	}
+	if p.config.Signer != nil {
+		p.config.Signer(req)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotModified && p.conditional != nil {
+		resp.Body.Close()
+		if cached, ok := p.conditional.notModified(p.config.Name, endpoint, time.Now().UTC()); ok {
+			return cached, nil
+		}
+		return nil, fmt.Errorf("%s returned 304 without a cached response", p.config.Name)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, &statusError{source: p.config.Name, url: endpoint, status: resp.StatusCode}
+	}
+	if err := decodeBody(resp); err != nil {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %w", p.config.Name, err)
+	}
+	networks, err := p.config.Parser(ctx, resp)
+	if err != nil {
+		return nil, err
+	}
+	ts := time.Now().UTC()
+	records := make([]RangeRecord, 0, len(networks))
+	for _, network := range networks {
+		records = append(records, RangeRecord{
+			Network: cloneIPNet(network),
+			Metadata: RangeMetadata{
+				Source:      p.config.Name,
+				Endpoint:    endpoint,
+				RetrievedAt: ts,
+				Credibility: p.config.Credibility,
+			},
+		})
+	}
+	if p.conditional != nil {
+		p.conditional.remember(p.config.Name, endpoint, resp.Header, records)
+	}
+	return records, nil
+}
+
 func (p *Provider) waitForRateLimit(ctx context.Context) error {
 	if p.config.RateLimit <= 0 {
 		return nil
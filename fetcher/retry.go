@@ -0,0 +1,84 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	// DefaultEndpointRetries is how many times a transient endpoint failure
+	// is retried by FetchProvider. SourceConfig endpoints set their own
+	// Retries.
+	DefaultEndpointRetries = 2
+	// DefaultEndpointBackoff is the delay before the first retry; it doubles
+	// with every further attempt.
+	DefaultEndpointBackoff = 200 * time.Millisecond
+)
+
+// statusError reports an unexpected HTTP status from a range endpoint. source
+// is set for SourceConfig endpoints, which are reported by source name.
+type statusError struct {
+	source string
+	url    string
+	status int
+	body   string
+}
+
+func (e *statusError) Error() string {
+	if e.source != "" {
+		return fmt.Sprintf("%s returned %d", e.source, e.status)
+	}
+	return fmt.Sprintf("%s 响应异常: %d %s", e.url, e.status, e.body)
+}
+
+// retryable classifies fetch failures: network errors, 5xx and 429 are
+// transient, while 4xx, parse errors and context cancellation are final.
+func retryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var status *statusError
+	if errors.As(err, &status) {
+		return status.status >= 500 || status.status == http.StatusTooManyRequests
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// retryPolicy retries transient failures with exponential backoff.
+type retryPolicy struct {
+	retries int
+	backoff time.Duration
+}
+
+// do calls fn until it succeeds, fails with a final error or the retries are
+// spent. Errors after more than one attempt report the attempt count.
+func (p retryPolicy) do(ctx context.Context, fn func() error) error {
+	delay := p.backoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		if !retryable(err) || attempt > p.retries {
+			if attempt > 1 {
+				return fmt.Errorf("%w (%d attempts)", err, attempt)
+			}
+			return err
+		}
+		if delay > 0 {
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return fmt.Errorf("%w (%d attempts)", ctx.Err(), attempt)
+			case <-timer.C:
+			}
+			delay *= 2
+		}
+	}
+}
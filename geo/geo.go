@@ -1,6 +1,9 @@
 package geo
 
-import "strings"
+import (
+	"sort"
+	"strings"
+)
 
 // Info describes metadata about a Cloudflare colo code.
 type Info struct {
@@ -24,3 +27,33 @@ func LookupColo(code string) (Info, bool) {
 	info, ok := coloCatalog[strings.ToUpper(code)]
 	return info, ok
 }
+
+// Catalog returns every known colo ordered by code.
+func Catalog() []Info {
+	out := make([]Info, 0, len(coloCatalog))
+	for _, info := range coloCatalog {
+		out = append(out, info)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Code < out[j].Code
+	})
+	return out
+}
+
+// Resolve maps either a colo code or a city name onto its catalog entry so
+// callers can treat "SJC", "sjc" and "San Jose" as the same place.
+func Resolve(value string) (Info, bool) {
+	value = strings.TrimSpace(value)
+	if info, ok := LookupColo(value); ok {
+		return info, true
+	}
+	if value == "" {
+		return Info{}, false
+	}
+	for _, info := range coloCatalog {
+		if strings.EqualFold(info.City, value) {
+			return info, true
+		}
+	}
+	return Info{}, false
+}
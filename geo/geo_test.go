@@ -11,3 +11,27 @@ func TestLookupColo(t *testing.T) {
 		t.Fatalf("unexpected city %s", info.City)
 	}
 }
+
+func TestCatalogSortedByCode(t *testing.T) {
+	catalog := Catalog()
+	if len(catalog) != len(coloCatalog) {
+		t.Fatalf("expected %d entries, got %d", len(coloCatalog), len(catalog))
+	}
+	for i := 1; i < len(catalog); i++ {
+		if catalog[i-1].Code >= catalog[i].Code {
+			t.Fatalf("catalog not sorted: %v", catalog)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	for _, value := range []string{"SJC", "sjc", "San Jose", " san jose "} {
+		info, ok := Resolve(value)
+		if !ok || info.Code != "SJC" {
+			t.Fatalf("Resolve(%q) = %+v, %v", value, info, ok)
+		}
+	}
+	if _, ok := Resolve("Atlantis"); ok {
+		t.Fatalf("expected unknown city to fail")
+	}
+}
@@ -0,0 +1,40 @@
+package prober
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// LoadCABundle reads a PEM file and returns a pool holding its certificates.
+// It fails when the file contains no parsable certificate.
+func LoadCABundle(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read ca bundle: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("ca bundle %s: no PEM certificates found", path)
+	}
+	return pool, nil
+}
+
+// UseCABundle verifies edges against the certificates in the PEM file at path
+// instead of the system roots, for private CAs or TLS-inspecting proxies. An
+// empty path keeps the system roots.
+func (p *Prober) UseCABundle(path string) error {
+	if path == "" {
+		return nil
+	}
+	pool, err := LoadCABundle(path)
+	if err != nil {
+		return err
+	}
+	if p.TLSConfig == nil {
+		p.TLSConfig = &tls.Config{NextProtos: []string{"h2", "http/1.1"}}
+	}
+	p.TLSConfig.RootCAs = pool
+	return nil
+}
@@ -0,0 +1,93 @@
+package prober
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// DefaultColoHeaders are consulted, in order, when Prober.ColoHeaders is empty.
+var DefaultColoHeaders = []string{"CF-Ray"}
+
+// ColoSourceTrace marks a colo read from the /cdn-cgi/trace endpoint.
+const ColoSourceTrace = "trace"
+
+// tracePath is Cloudflare's plain-text diagnostics endpoint.
+const tracePath = "/cdn-cgi/trace"
+
+// extractColo returns the first colo found in the named headers together with
+// the canonical name of the header it came from. Header names are matched
+// case-insensitively, including non-canonical keys set directly on the map.
+func extractColo(header http.Header, names []string) (string, string) {
+	if len(names) == 0 {
+		names = DefaultColoHeaders
+	}
+	for _, name := range names {
+		if colo := parseColoValue(headerValue(header, name)); colo != "" {
+			return colo, http.CanonicalHeaderKey(name)
+		}
+	}
+	return "", ""
+}
+
+func headerValue(header http.Header, name string) string {
+	if value := header.Get(name); value != "" {
+		return value
+	}
+	for key, values := range header {
+		if strings.EqualFold(key, name) && len(values) > 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+// parseColoValue accepts either a ray ID ("8a1b2c3d4e5f-SJC") or a bare colo
+// code and returns the upper-cased colo.
+func parseColoValue(value string) string {
+	value = strings.TrimSpace(value)
+	if idx := strings.LastIndex(value, "-"); idx >= 0 {
+		value = value[idx+1:]
+	}
+	if value == "" {
+		return ""
+	}
+	for _, r := range value {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') {
+			return ""
+		}
+	}
+	return strings.ToUpper(value)
+}
+
+// traceColo requests /cdn-cgi/trace through client and returns its colo field.
+func (p *Prober) traceColo(ctx context.Context, client *http.Client, domain string) string {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://"+domain+tracePath, nil)
+	if err != nil {
+		return ""
+	}
+	req.Host = domain
+	resp, err := client.Do(req)
+	if err != nil {
+		return ""
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ""
+	}
+	return parseTraceColo(io.LimitReader(resp.Body, 4096))
+}
+
+// parseTraceColo extracts the colo from a key=value trace body.
+func parseTraceColo(r io.Reader) string {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if ok && key == "colo" {
+			return parseColoValue(value)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,64 @@
+package prober
+
+import (
+	"net/http"
+	"sort"
+)
+
+const (
+	// DefaultMaxHeaders caps the response headers recorded in
+	// HTTPFingerprint.Headers when Prober.MaxHeaders is zero.
+	DefaultMaxHeaders = 64
+	// DefaultMaxHeaderBytes caps the total size of the recorded header names
+	// and values when Prober.MaxHeaderBytes is zero.
+	DefaultMaxHeaderBytes = 8 << 10
+)
+
+// consultedHeaders are read back from HTTPFingerprint.Headers by the prober
+// itself (see Measurement.FailureCategory), so they are recorded ahead of the
+// caps.
+var consultedHeaders = []string{"Cf-Mitigated"}
+
+// recordHeaders keeps the first value of each response header in name order,
+// skipping any header that would exceed either cap while later, smaller ones
+// still fit. consultedHeaders are always kept. The second result reports
+// whether headers were dropped.
+func (p *Prober) recordHeaders(header http.Header) (map[string]string, bool) {
+	maxCount := p.MaxHeaders
+	if maxCount <= 0 {
+		maxCount = DefaultMaxHeaders
+	}
+	maxBytes := p.MaxHeaderBytes
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxHeaderBytes
+	}
+	keys := make([]string, 0, len(header))
+	for key, values := range header {
+		if len(values) > 0 {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	recorded := make(map[string]string, min(len(keys), maxCount))
+	size := 0
+	for _, key := range consultedHeaders {
+		if values := header[key]; len(values) > 0 {
+			recorded[key] = values[0]
+			size += len(key) + len(values[0])
+		}
+	}
+	truncated := false
+	for _, key := range keys {
+		if _, ok := recorded[key]; ok {
+			continue
+		}
+		value := header[key][0]
+		if len(recorded) >= maxCount || size+len(key)+len(value) > maxBytes {
+			truncated = true
+			continue
+		}
+		recorded[key] = value
+		size += len(key) + len(value)
+	}
+	return recorded, truncated
+}
@@ -0,0 +1,86 @@
+package prober
+
+import (
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// HTTPTiming breaks the HTTP phase down with net/http/httptrace so that
+// connection acquisition is reported separately from the request/response
+// exchange, which matters for multiplexed HTTP/2 connections. The phases are
+// consecutive and add up to Measurement.HTTPDuration.
+type HTTPTiming struct {
+	// Protocol is the response protocol, e.g. "HTTP/2.0".
+	Protocol string
+	// ConnReused reports whether the transport reused a pooled connection.
+	ConnReused bool
+	// ConnectionSetup runs from the request start to GotConn: dial and TLS
+	// for a new connection, the pool lookup for a reused one.
+	ConnectionSetup time.Duration
+	// RequestWrite runs from GotConn to WroteRequest, covering stream
+	// opening and header writes.
+	RequestWrite time.Duration
+	// FirstByte runs from WroteRequest to GotFirstResponseByte.
+	FirstByte time.Duration
+	// BodyRead runs from the first response byte to the end of the body.
+	BodyRead time.Duration
+}
+
+// httpTracer records httptrace events. HTTP/2 delivers some callbacks from
+// the connection's read loop, so access is guarded.
+type httpTracer struct {
+	mu           sync.Mutex
+	gotConn      time.Time
+	wroteRequest time.Time
+	firstByte    time.Time
+	reused       bool
+}
+
+func (t *httpTracer) trace(req *http.Request) *http.Request {
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.gotConn = time.Now()
+			t.reused = info.Reused
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.wroteRequest = time.Now()
+		},
+		GotFirstResponseByte: func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.firstByte = time.Now()
+		},
+	}))
+}
+
+// timing splits [start, end] at the recorded events. Missing events collapse
+// onto the previous boundary so the phases still add up.
+func (t *httpTracer) timing(start, end time.Time, proto string) *HTTPTiming {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	boundaries := []time.Time{start, t.gotConn, t.wroteRequest, t.firstByte, end}
+	for i := 1; i < len(boundaries); i++ {
+		if boundaries[i].Before(boundaries[i-1]) {
+			boundaries[i] = boundaries[i-1]
+		}
+	}
+	for i := len(boundaries) - 2; i > 0; i-- {
+		if boundaries[i].After(end) {
+			boundaries[i] = end
+		}
+	}
+	return &HTTPTiming{
+		Protocol:        proto,
+		ConnReused:      t.reused,
+		ConnectionSetup: boundaries[1].Sub(boundaries[0]),
+		RequestWrite:    boundaries[2].Sub(boundaries[1]),
+		FirstByte:       boundaries[3].Sub(boundaries[2]),
+		BodyRead:        boundaries[4].Sub(boundaries[3]),
+	}
+}
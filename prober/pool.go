@@ -0,0 +1,100 @@
+package prober
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sync"
+)
+
+const (
+	// warmPrefixV4 and warmPrefixV6 group IPs whose TLS sessions may be shared.
+	warmPrefixV4 = 24
+	warmPrefixV6 = 48
+	// defaultWarmSessions bounds each per-network TLS session cache.
+	defaultWarmSessions = 64
+	// defaultWarmTransports bounds the number of per-IP transports kept warm.
+	defaultWarmTransports = 256
+)
+
+// WarmPool lets sequential probes reuse warmed state. TLS session tickets are
+// shared between IPs of the same /24 (IPv4) or /48 (IPv6) so nearby edges can
+// resume, while HTTP connections stay pinned to a single IP and domain and are
+// never handed to a different address.
+type WarmPool struct {
+	// MaxTransports bounds the per-IP transports kept alive; zero uses 256.
+	MaxTransports int
+
+	mu         sync.Mutex
+	sessions   map[string]tls.ClientSessionCache
+	transports map[string]*http.Transport
+}
+
+// NewWarmPool returns an empty pool.
+func NewWarmPool() *WarmPool {
+	return &WarmPool{
+		sessions:   make(map[string]tls.ClientSessionCache),
+		transports: make(map[string]*http.Transport),
+	}
+}
+
+// sessionCache returns the TLS session cache shared by ip's network.
+func (w *WarmPool) sessionCache(ip net.IP) tls.ClientSessionCache {
+	key := warmNetworkKey(ip)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.sessions == nil {
+		w.sessions = make(map[string]tls.ClientSessionCache)
+	}
+	cache, ok := w.sessions[key]
+	if !ok {
+		cache = tls.NewLRUClientSessionCache(defaultWarmSessions)
+		w.sessions[key] = cache
+	}
+	return cache
+}
+
+// transport returns the warm transport for ip and domain, building it on first
+// use. build runs with the pool locked and must not call back into it. When
+// the pool is full an arbitrary transport is evicted.
+func (w *WarmPool) transport(ip net.IP, domain string, build func() *http.Transport) *http.Transport {
+	key := ip.String() + "|" + domain
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.transports == nil {
+		w.transports = make(map[string]*http.Transport)
+	}
+	if t, ok := w.transports[key]; ok {
+		return t
+	}
+	limit := w.MaxTransports
+	if limit <= 0 {
+		limit = defaultWarmTransports
+	}
+	for evictKey, t := range w.transports {
+		if len(w.transports) < limit {
+			break
+		}
+		t.CloseIdleConnections()
+		delete(w.transports, evictKey)
+	}
+	t := build()
+	w.transports[key] = t
+	return t
+}
+
+// CloseIdleConnections closes the idle connections held by every warm transport.
+func (w *WarmPool) CloseIdleConnections() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, t := range w.transports {
+		t.CloseIdleConnections()
+	}
+}
+
+func warmNetworkKey(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(warmPrefixV4, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(warmPrefixV6, 128)).String()
+}
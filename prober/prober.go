@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -24,6 +25,7 @@ type IntegrityReport struct {
 	MatchesSNI      bool     `json:"matchesSni"`
 	HTTPStatus      int      `json:"httpStatus"`
 	ResponseHash    string   `json:"responseHash"`
+	VerifyError     string   `json:"verifyError,omitempty"`
 }
 
 // LocationInfo describes the colo metadata extracted from headers.
@@ -38,6 +40,9 @@ type HTTPFingerprint struct {
 	StatusCode    int               `json:"status_code"`
 	Headers       map[string]string `json:"headers,omitempty"`
 	ContentLength int64             `json:"content_length"`
+	// HeadersTruncated marks that Headers hit the prober's count or size cap
+	// and further headers were dropped.
+	HeadersTruncated bool `json:"headers_truncated,omitempty"`
 }
 
 // ValidationResult captures the outcome of additional safety checks.
@@ -57,17 +62,24 @@ type Measurement struct {
 	IP                  net.IP
 	Domain              string
 	RequestHost         string
+	EffectiveURL        string
 	TCPDuration         time.Duration
 	TLSDuration         time.Duration
 	HTTPDuration        time.Duration
+	TotalDuration       time.Duration
 	Success             bool
 	Error               string
 	ALPN                string
 	TLSVersion          string
+	TLSResumed          bool
+	CipherSuite         string
 	SNI                 string
 	Throughput          float64
+	Reliability         float64
+	ReliabilityAttempts int
 	CFRay               string
 	CFColo              string
+	ColoSource          string
 	Geo                 geo.Info
 	DataSource          string
 	Source              string
@@ -79,10 +91,17 @@ type Measurement struct {
 	CertificateCN       string
 	CertificateDNSNames []string
 	OriginHost          string
+	PTR                 string
+	NonCloudflare       bool
 	HTTPFingerprint     HTTPFingerprint
 	Validation          ValidationResult
 	Integrity           IntegrityReport
 	BytesRead           int64
+	HTTPTiming          *HTTPTiming
+	WSUpgradeOK         bool
+	WSStatus            int
+	WSError             string
+	RedirectLocation    string
 	Location            LocationInfo
 	Timestamp           time.Time
 }
@@ -135,7 +154,49 @@ func (m *Measurement) ApplyValidation(expectedOrigin string, trustedCNs []string
 	} else if strings.EqualFold(expectedOrigin, m.OriginHost) {
 		m.Validation.OriginMatch = true
 	} else {
-		m.Validation.Failures = append(m.Validation.Failures, "origin_host_mismatch")
+		m.Validation.Failures = append(m.Validation.Failures, FailureOriginMismatch)
+	}
+}
+
+// FailureOriginMismatch is recorded when the response came from an origin
+// other than the expected one.
+const FailureOriginMismatch = "origin_host_mismatch"
+
+// FailureNonCloudflare is recorded when the response lacks Cloudflare markers.
+const FailureNonCloudflare = "non_cloudflare_response"
+
+// Failure categories returned by Measurement.FailureCategory.
+const (
+	CategoryTCP       = "tcp"
+	CategoryTLS       = "tls"
+	CategoryHTTP      = "http"
+	CategoryTimeout   = "timeout"
+	CategoryChallenge = "challenge"
+	CategoryOther     = "other"
+)
+
+// FailureCategory normalises the failure cause of an unsuccessful measurement
+// into one of the Category constants. Successful measurements return "".
+func (m Measurement) FailureCategory() string {
+	if m.Success {
+		return ""
+	}
+	lower := strings.ToLower(m.Error)
+	switch {
+	case strings.Contains(lower, "timeout") || strings.Contains(lower, "deadline exceeded"):
+		return CategoryTimeout
+	case strings.EqualFold(m.HTTPFingerprint.Headers["Cf-Mitigated"], "challenge"):
+		return CategoryChallenge
+	case strings.HasPrefix(lower, "tcp"):
+		return CategoryTCP
+	case strings.HasPrefix(lower, "tls"):
+		return CategoryTLS
+	case strings.HasPrefix(lower, "http"), strings.HasPrefix(lower, "read body"):
+		return CategoryHTTP
+	case lower == "" && m.Integrity.HTTPStatus >= 400:
+		return CategoryHTTP
+	default:
+		return CategoryOther
 	}
 }
 
@@ -147,6 +208,61 @@ type Prober struct {
 	HTTPMethod string
 	HTTPPath   string
 	Port       string
+	// DetectNonCloudflare flags responses that carry neither a CF-Ray header
+	// nor a "cloudflare" Server header as a validation failure.
+	DetectNonCloudflare bool
+	// StrictVerify fails the probe when the certificate chain does not verify
+	// against the configured roots, even if InsecureSkipVerify is set.
+	StrictVerify bool
+	// SuccessStatusCodes lists the HTTP status codes that count as a
+	// successful probe. When empty any status in the 200-399 range succeeds.
+	SuccessStatusCodes map[int]bool
+	// WarmPool, when set, reuses TLS sessions within a network and keeps
+	// per-IP HTTP connections alive across probes.
+	WarmPool *WarmPool
+	// ColoHeaders lists the response headers checked for the colo, in order.
+	// Values may be ray IDs ("<id>-SJC") or bare codes. Empty uses
+	// DefaultColoHeaders.
+	ColoHeaders []string
+	// ColoTraceFallback requests /cdn-cgi/trace when no header carried a colo.
+	ColoTraceFallback bool
+	// ConnectTimeout, TLSTimeout and HTTPTimeout bound the TCP connect, the
+	// TLS handshake and the HTTP exchange individually. Zero keeps the
+	// Dialer and HTTPClient timeouts.
+	ConnectTimeout time.Duration
+	TLSTimeout     time.Duration
+	HTTPTimeout    time.Duration
+	// ReliabilityProbes performs this many extra TCP connects before the
+	// measurement and records the fraction that succeeded. Zero disables them.
+	ReliabilityProbes int
+	// ReliabilityTimeout bounds each reliability connect. Zero uses
+	// DefaultReliabilityTimeout.
+	ReliabilityTimeout time.Duration
+	// RequestHeaders are set on every probe request, e.g. to mimic a browser's
+	// Accept and Accept-Language. A Host entry is ignored so the probed
+	// domain always stays the request host.
+	RequestHeaders map[string]string
+	// TraceHTTP records Measurement.HTTPTiming, splitting the HTTP phase into
+	// connection setup, request write, first byte and body read.
+	TraceHTTP bool
+	// WebSocketPath, when set, performs a WebSocket Upgrade handshake against
+	// this path after the HTTP probe and records Measurement.WSUpgradeOK.
+	WebSocketPath string
+	// WebSocketStatus is the handshake status counted as an upgrade. Zero
+	// expects 101 Switching Protocols.
+	WebSocketStatus int
+	// WebSocketTimeout bounds the handshake. Zero uses
+	// DefaultWebSocketTimeout.
+	WebSocketTimeout time.Duration
+	// CaptureRedirects stops at the first response instead of following
+	// redirects, so 3xx statuses reach the measurement together with their
+	// Location in Measurement.RedirectLocation.
+	CaptureRedirects bool
+	// MaxHeaders and MaxHeaderBytes cap the response headers recorded in
+	// HTTPFingerprint.Headers by count and by total name plus value bytes.
+	// Zero uses DefaultMaxHeaders and DefaultMaxHeaderBytes.
+	MaxHeaders     int
+	MaxHeaderBytes int
 }
 
 // New creates a Prober with sensible defaults for TLS and HTTP probing.
@@ -171,6 +287,35 @@ func New(domain string) *Prober {
 		HTTPMethod: http.MethodGet,
 		HTTPPath:   "/",
 		Port:       "443",
+
+		DetectNonCloudflare: true,
+	}
+}
+
+// successStatus reports whether status counts as a successful response.
+func (p *Prober) successStatus(status int) bool {
+	if len(p.SuccessStatusCodes) > 0 {
+		return p.SuccessStatusCodes[status]
+	}
+	return status >= 200 && status < 400
+}
+
+// effectiveURL renders the request URL with the port actually dialled, e.g.
+// "https://example.com:8443/health".
+func (p *Prober) effectiveURL(req *http.Request) string {
+	u := *req.URL
+	u.Host = net.JoinHostPort(req.Host, p.port())
+	return u.String()
+}
+
+// applyRequestHeaders copies RequestHeaders onto req, skipping Host.
+func (p *Prober) applyRequestHeaders(req *http.Request) {
+	for key, value := range p.RequestHeaders {
+		key = strings.TrimSpace(key)
+		if key == "" || strings.EqualFold(key, "Host") {
+			continue
+		}
+		req.Header.Set(key, value)
 	}
 }
 
@@ -181,6 +326,18 @@ func (p *Prober) port() string {
 	return p.Port
 }
 
+func (p *Prober) transportFor(ip net.IP, domain string) *http.Transport {
+	if p.WarmPool == nil {
+		return p.cloneTransportForIP(ip, domain)
+	}
+	sessions := p.WarmPool.sessionCache(ip)
+	return p.WarmPool.transport(ip, domain, func() *http.Transport {
+		t := p.cloneTransportForIP(ip, domain)
+		t.TLSClientConfig.ClientSessionCache = sessions
+		return t
+	})
+}
+
 func (p *Prober) cloneTransportForIP(ip net.IP, domain string) *http.Transport {
 	base, _ := p.HTTPClient.Transport.(*http.Transport)
 	if base == nil {
@@ -199,12 +356,17 @@ func (p *Prober) cloneTransportForIP(ip net.IP, domain string) *http.Transport {
 	return clone
 }
 
-func (p *Prober) tlsConfigFor(domain string) *tls.Config {
+func (p *Prober) tlsConfigFor(ip net.IP, domain string) *tls.Config {
+	var cfg *tls.Config
 	if p.TLSConfig == nil {
-		return &tls.Config{ServerName: domain, NextProtos: []string{"h2", "http/1.1"}}
+		cfg = &tls.Config{ServerName: domain, NextProtos: []string{"h2", "http/1.1"}}
+	} else {
+		cfg = p.TLSConfig.Clone()
+		cfg.ServerName = domain
+	}
+	if p.WarmPool != nil {
+		cfg.ClientSessionCache = p.WarmPool.sessionCache(ip)
 	}
-	cfg := p.TLSConfig.Clone()
-	cfg.ServerName = domain
 	return cfg
 }
 
@@ -217,11 +379,17 @@ func (p *Prober) Probe(ctx context.Context, ip net.IP, domain string) (*Measurem
 		return nil, errors.New("domain is required")
 	}
 	m := &Measurement{IP: ip, Domain: domain, Timestamp: time.Now()}
+	defer func() { m.TotalDuration = time.Since(m.Timestamp) }()
 	m.Integrity.TLSServerName = domain
 	address := net.JoinHostPort(ip.String(), p.port())
 
+	if p.ReliabilityProbes > 0 {
+		m.ReliabilityAttempts, m.Reliability = p.measureReliability(ctx, address)
+	}
 	tcpStart := time.Now()
-	conn, err := p.Dialer.DialContext(ctx, "tcp", address)
+	tcpCtx, cancelTCP := withPhaseTimeout(ctx, p.ConnectTimeout)
+	conn, err := p.Dialer.DialContext(tcpCtx, "tcp", address)
+	cancelTCP()
 	if err != nil {
 		m.Error = fmt.Sprintf("tcp dial: %v", err)
 		return m, nil
@@ -230,14 +398,19 @@ func (p *Prober) Probe(ctx context.Context, ip net.IP, domain string) (*Measurem
 	_ = conn.Close()
 
 	tlsStart := time.Now()
-	tlsConn, err := tls.DialWithDialer(p.Dialer, "tcp", address, p.tlsConfigFor(domain))
+	tlsCtx, cancelTLS := withPhaseTimeout(ctx, p.tlsBudget())
+	rawTLS, err := (&tls.Dialer{NetDialer: p.Dialer, Config: p.tlsConfigFor(ip, domain)}).DialContext(tlsCtx, "tcp", address)
+	cancelTLS()
 	if err != nil {
 		m.Error = fmt.Sprintf("tls dial: %v", err)
 		return m, nil
 	}
+	tlsConn := rawTLS.(*tls.Conn)
 	if state := tlsConn.ConnectionState(); state.HandshakeComplete {
 		m.ALPN = state.NegotiatedProtocol
 		m.TLSVersion = tlsVersionString(state.Version)
+		m.TLSResumed = state.DidResume
+		m.CipherSuite = tls.CipherSuiteName(state.CipherSuite)
 		m.SNI = state.ServerName
 		if len(state.PeerCertificates) > 0 {
 			cert := state.PeerCertificates[0]
@@ -251,18 +424,41 @@ func (p *Prober) Probe(ctx context.Context, ip net.IP, domain string) (*Measurem
 		}
 	}
 	m.TLSDuration = time.Since(tlsStart)
+	verifyErr := p.verifyPeer(tlsConn.ConnectionState(), domain)
 	_ = tlsConn.Close()
+	if verifyErr != nil {
+		m.Integrity.VerifyError = verifyErr.Error()
+		if p.StrictVerify {
+			m.Error = fmt.Sprintf("tls verify: %v", verifyErr)
+			return m, nil
+		}
+	}
 
-	transport := p.cloneTransportForIP(ip, domain)
+	transport := p.transportFor(ip, domain)
 	client := *p.HTTPClient
 	client.Transport = transport
+	if p.HTTPTimeout > 0 {
+		client.Timeout = p.HTTPTimeout
+	}
+	if p.CaptureRedirects {
+		client.CheckRedirect = func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
 
 	req, err := http.NewRequestWithContext(ctx, p.HTTPMethod, "https://"+domain+p.HTTPPath, nil)
 	if err != nil {
 		return nil, err
 	}
 	req.Host = domain
+	p.applyRequestHeaders(req)
 	m.RequestHost = req.Host
+	m.EffectiveURL = p.effectiveURL(req)
+	var tracer *httpTracer
+	if p.TraceHTTP {
+		tracer = &httpTracer{}
+		req = tracer.trace(req)
+	}
 
 	httpStart := time.Now()
 	resp, err := client.Do(req)
@@ -279,16 +475,17 @@ func (p *Prober) Probe(ctx context.Context, ip net.IP, domain string) (*Measurem
 	}
 	m.BytesRead = bytesRead
 	m.HTTPDuration = time.Since(httpStart)
+	if tracer != nil {
+		m.HTTPTiming = tracer.timing(httpStart, httpStart.Add(m.HTTPDuration), resp.Proto)
+	}
 	m.Integrity.HTTPStatus = resp.StatusCode
+	if resp.StatusCode >= 300 && resp.StatusCode < 400 {
+		m.RedirectLocation = resp.Header.Get("Location")
+	}
 	m.Integrity.ResponseHash = hex.EncodeToString(hasher.Sum(nil))
 	m.HTTPFingerprint.StatusCode = resp.StatusCode
 	m.HTTPFingerprint.ContentLength = resp.ContentLength
-	m.HTTPFingerprint.Headers = map[string]string{}
-	for key, values := range resp.Header {
-		if len(values) > 0 {
-			m.HTTPFingerprint.Headers[key] = values[0]
-		}
-	}
+	m.HTTPFingerprint.Headers, m.HTTPFingerprint.HeadersTruncated = p.recordHeaders(resp.Header)
 	durationSeconds := m.HTTPDuration.Seconds()
 	if durationSeconds > 0 {
 		m.Throughput = float64(bytesRead*8) / durationSeconds
@@ -300,9 +497,16 @@ func (p *Prober) Probe(ctx context.Context, ip net.IP, domain string) (*Measurem
 			break
 		}
 	}
-	m.CFRay = resp.Header.Get("CF-Ray")
-	if parts := strings.Split(m.CFRay, "-"); len(parts) == 2 {
-		m.CFColo = strings.ToUpper(parts[1])
+	m.CFRay = headerValue(resp.Header, "CF-Ray")
+	m.CFColo, m.ColoSource = extractColo(resp.Header, p.ColoHeaders)
+	if m.CFColo == "" && p.ColoTraceFallback {
+		if colo := p.traceColo(ctx, &client, domain); colo != "" {
+			m.CFColo, m.ColoSource = colo, ColoSourceTrace
+		}
+	}
+	if p.DetectNonCloudflare && !isCloudflareResponse(resp.Header) {
+		m.NonCloudflare = true
+		m.Validation.Failures = append(m.Validation.Failures, FailureNonCloudflare)
 	}
 	if info, ok := geo.LookupColo(m.CFColo); ok {
 		m.Geo = info
@@ -311,10 +515,60 @@ func (p *Prober) Probe(ctx context.Context, ip net.IP, domain string) (*Measurem
 		m.Location.Colo = m.CFColo
 	}
 
-	m.Success = resp.StatusCode >= 200 && resp.StatusCode < 400 && m.Error == ""
+	if p.WebSocketPath != "" {
+		status, ok, err := p.probeWebSocket(ctx, ip, domain)
+		m.WSStatus, m.WSUpgradeOK = status, ok
+		if err != nil {
+			m.WSError = err.Error()
+		}
+	}
+
+	m.Success = p.successStatus(resp.StatusCode) && m.Error == ""
 	return m, nil
 }
 
+// withPhaseTimeout bounds ctx by d unless d is zero.
+func withPhaseTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, d)
+}
+
+// tlsBudget is the TLS phase deadline. The handshake dials afresh, so a
+// configured TLSTimeout is extended by the connect budget.
+func (p *Prober) tlsBudget() time.Duration {
+	if p.TLSTimeout <= 0 {
+		return 0
+	}
+	return p.TLSTimeout + p.ConnectTimeout
+}
+
+// verifyPeer checks the presented chain against the configured roots (or the
+// system pool) so would-be failures are visible even when verification is
+// skipped during the handshake.
+func (p *Prober) verifyPeer(state tls.ConnectionState, domain string) error {
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("no peer certificates presented")
+	}
+	opts := x509.VerifyOptions{DNSName: domain, Intermediates: x509.NewCertPool()}
+	if p.TLSConfig != nil {
+		opts.Roots = p.TLSConfig.RootCAs
+	}
+	for _, cert := range state.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := state.PeerCertificates[0].Verify(opts)
+	return err
+}
+
+func isCloudflareResponse(header http.Header) bool {
+	if strings.TrimSpace(headerValue(header, "CF-Ray")) != "" {
+		return true
+	}
+	return strings.EqualFold(strings.TrimSpace(header.Get("Server")), "cloudflare")
+}
+
 func tlsVersionString(version uint16) string {
 	switch version {
 	case tls.VersionTLS13:
@@ -3,9 +3,14 @@ package prober
 import (
 	"context"
 	"crypto/tls"
+	"encoding/pem"
+	"fmt"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -51,6 +56,9 @@ func TestProberProbe(t *testing.T) {
 	if m.SNI == "" {
 		t.Fatalf("expected SNI to be recorded")
 	}
+	if m.CipherSuite == "" || m.TLSVersion == "" {
+		t.Fatalf("expected TLS version and cipher suite to be recorded, got %q %q", m.TLSVersion, m.CipherSuite)
+	}
 	if m.OriginHost != "origin.example.com" {
 		t.Fatalf("expected origin host to be recorded, got %s", m.OriginHost)
 	}
@@ -85,3 +93,541 @@ func TestMeasurementApplyValidation(t *testing.T) {
 		t.Fatalf("expected certificate and origin mismatch failures got %v", m2.Validation.Failures)
 	}
 }
+
+func newTestProber(t *testing.T, server *httptest.Server) (*Prober, net.IP) {
+	t.Helper()
+	ipStr, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		t.Fatalf("failed to parse server ip")
+	}
+	dialer := &net.Dialer{Timeout: time.Second}
+	tlsConfig := &tls.Config{ServerName: "example.com", InsecureSkipVerify: true, NextProtos: []string{"http/1.1"}}
+	transport := &http.Transport{DialContext: dialer.DialContext, TLSClientConfig: tlsConfig, ForceAttemptHTTP2: false}
+	client := &http.Client{Transport: transport, Timeout: 2 * time.Second}
+	return &Prober{Dialer: dialer, TLSConfig: tlsConfig, HTTPClient: client, HTTPMethod: http.MethodGet, HTTPPath: "/", Port: port}, ip
+}
+
+func TestProberDetectsNonCloudflare(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "nginx")
+		w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+
+	p, ip := newTestProber(t, server)
+	p.DetectNonCloudflare = true
+	m, err := p.Probe(context.Background(), ip, "example.com")
+	if err != nil {
+		t.Fatalf("Probe error = %v", err)
+	}
+	if !m.NonCloudflare {
+		t.Fatalf("expected measurement to be flagged as non-cloudflare")
+	}
+	found := false
+	for _, failure := range m.Validation.Failures {
+		if failure == FailureNonCloudflare {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected %s failure got %v", FailureNonCloudflare, m.Validation.Failures)
+	}
+
+	p.DetectNonCloudflare = false
+	m, err = p.Probe(context.Background(), ip, "example.com")
+	if err != nil {
+		t.Fatalf("Probe error = %v", err)
+	}
+	if m.NonCloudflare || len(m.Validation.Failures) != 0 {
+		t.Fatalf("expected detection to be disabled, got %+v", m.Validation)
+	}
+}
+
+func TestProberRecordsVerifyError(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("CF-RAY", "12345-SJC")
+		w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+
+	p, ip := newTestProber(t, server)
+	m, err := p.Probe(context.Background(), ip, "example.com")
+	if err != nil {
+		t.Fatalf("Probe error = %v", err)
+	}
+	if !m.Success {
+		t.Fatalf("expected probe to succeed with verification skipped, got %s", m.Error)
+	}
+	if !strings.Contains(m.Integrity.VerifyError, "unknown authority") {
+		t.Fatalf("expected unknown authority verify error, got %q", m.Integrity.VerifyError)
+	}
+
+	p.StrictVerify = true
+	m, err = p.Probe(context.Background(), ip, "example.com")
+	if err != nil {
+		t.Fatalf("Probe error = %v", err)
+	}
+	if m.Success || !strings.HasPrefix(m.Error, "tls verify:") {
+		t.Fatalf("expected strict verification to fail the probe, got success=%v error=%q", m.Success, m.Error)
+	}
+}
+
+func TestMeasurementFailureCategory(t *testing.T) {
+	cases := []struct {
+		m    Measurement
+		want string
+	}{
+		{Measurement{Success: true}, ""},
+		{Measurement{Error: "tls dial: remote error: tls: handshake failure"}, CategoryTLS},
+		{Measurement{Error: "tcp dial: connection refused"}, CategoryTCP},
+		{Measurement{Error: "tcp dial: i/o timeout"}, CategoryTimeout},
+		{Measurement{Error: "http: EOF"}, CategoryHTTP},
+		{Measurement{Integrity: IntegrityReport{HTTPStatus: 403}, HTTPFingerprint: HTTPFingerprint{Headers: map[string]string{"Cf-Mitigated": "challenge"}}}, CategoryChallenge},
+	}
+	for _, tc := range cases {
+		if got := tc.m.FailureCategory(); got != tc.want {
+			t.Fatalf("FailureCategory(%q) = %q, want %q", tc.m.Error, got, tc.want)
+		}
+	}
+}
+
+func TestProberSuccessStatusCodes(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("CF-Ray", "abc-SJC")
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	p, ip := newTestProber(t, server)
+	m, err := p.Probe(context.Background(), ip, "example.com")
+	if err != nil {
+		t.Fatalf("Probe error = %v", err)
+	}
+	if m.Success {
+		t.Fatalf("expected 404 to fail with the default success range")
+	}
+
+	p.SuccessStatusCodes = map[int]bool{http.StatusOK: true, http.StatusNotFound: true}
+	m, err = p.Probe(context.Background(), ip, "example.com")
+	if err != nil {
+		t.Fatalf("Probe error = %v", err)
+	}
+	if !m.Success {
+		t.Fatalf("expected 404 to count as success when configured, got %+v", m)
+	}
+}
+
+func TestProberWarmPoolResumesSessions(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("CF-Ray", "abc-SJC")
+		w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+
+	p, ip := newTestProber(t, server)
+	for i := 0; i < 2; i++ {
+		m, err := p.Probe(context.Background(), ip, "example.com")
+		if err != nil {
+			t.Fatalf("Probe error = %v", err)
+		}
+		if m.TLSResumed {
+			t.Fatalf("expected no resumption without a warm pool")
+		}
+	}
+
+	p.WarmPool = NewWarmPool()
+	defer p.WarmPool.CloseIdleConnections()
+	first, err := p.Probe(context.Background(), ip, "example.com")
+	if err != nil {
+		t.Fatalf("Probe error = %v", err)
+	}
+	if first.TLSResumed || !first.Success {
+		t.Fatalf("expected a full handshake on the first probe, got %+v", first)
+	}
+	second, err := p.Probe(context.Background(), ip, "example.com")
+	if err != nil {
+		t.Fatalf("Probe error = %v", err)
+	}
+	if !second.TLSResumed || !second.Success {
+		t.Fatalf("expected the second probe to resume the TLS session, got %+v", second)
+	}
+}
+
+func TestWarmPoolIsolatesTransportsPerIP(t *testing.T) {
+	pool := NewWarmPool()
+	built := 0
+	build := func() *http.Transport { built++; return &http.Transport{} }
+	a := pool.transport(net.ParseIP("192.0.2.1"), "example.com", build)
+	b := pool.transport(net.ParseIP("192.0.2.2"), "example.com", build)
+	if a == b || built != 2 {
+		t.Fatalf("expected separate transports per IP")
+	}
+	if pool.transport(net.ParseIP("192.0.2.1"), "example.com", build) != a {
+		t.Fatalf("expected the transport to be reused for the same IP")
+	}
+	if pool.sessionCache(net.ParseIP("192.0.2.1")) != pool.sessionCache(net.ParseIP("192.0.2.200")) {
+		t.Fatalf("expected IPs in the same /24 to share a session cache")
+	}
+	if pool.sessionCache(net.ParseIP("192.0.2.1")) == pool.sessionCache(net.ParseIP("192.0.3.1")) {
+		t.Fatalf("expected different networks to use separate session caches")
+	}
+}
+
+func TestProberTotalDuration(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(5 * time.Millisecond)
+		w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+
+	p, ip := newTestProber(t, server)
+	m, err := p.Probe(context.Background(), ip, "example.com")
+	if err != nil {
+		t.Fatalf("Probe error = %v", err)
+	}
+	phases := m.TCPDuration + m.TLSDuration + m.HTTPDuration
+	if m.TotalDuration <= 0 || m.TotalDuration < phases {
+		t.Fatalf("expected total %s >= phases %s", m.TotalDuration, phases)
+	}
+}
+
+func TestProberColoExtraction(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/cdn-cgi/trace" {
+			w.Write([]byte("fl=1f1\nh=example.com\ncolo=NRT\nhttp=http/1.1\n"))
+			return
+		}
+		if r.URL.Query().Get("ray") == "" {
+			w.Header()["cf-ray"] = []string{"8a1b2c3d4e5f6a7b-sjc"}
+		}
+		w.Header().Set("Server", "cloudflare")
+		w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+
+	p, ip := newTestProber(t, server)
+	m, err := p.Probe(context.Background(), ip, "example.com")
+	if err != nil {
+		t.Fatalf("Probe error = %v", err)
+	}
+	if m.CFColo != "SJC" || m.ColoSource != "Cf-Ray" {
+		t.Fatalf("expected colo SJC from the lowercase cf-ray header, got %q from %q", m.CFColo, m.ColoSource)
+	}
+	if m.Location.City != "San Jose" {
+		t.Fatalf("expected the colo to resolve to San Jose, got %+v", m.Location)
+	}
+
+	p.HTTPPath = "/?ray=none"
+	p.ColoTraceFallback = true
+	m, err = p.Probe(context.Background(), ip, "example.com")
+	if err != nil {
+		t.Fatalf("Probe error = %v", err)
+	}
+	if m.CFColo != "NRT" || m.ColoSource != ColoSourceTrace {
+		t.Fatalf("expected colo NRT from the trace fallback, got %q from %q", m.CFColo, m.ColoSource)
+	}
+}
+
+func TestProberUsesCABundle(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("CF-Ray", "abc-SJC")
+		w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	bundle := filepath.Join(dir, "ca.pem")
+	block := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(bundle, block, 0o600); err != nil {
+		t.Fatalf("write bundle: %v", err)
+	}
+
+	p, ip := newTestProber(t, server)
+	p.StrictVerify = true
+	if err := p.UseCABundle(bundle); err != nil {
+		t.Fatalf("UseCABundle error = %v", err)
+	}
+	m, err := p.Probe(context.Background(), ip, "example.com")
+	if err != nil {
+		t.Fatalf("Probe error = %v", err)
+	}
+	if !m.Success || m.Integrity.VerifyError != "" {
+		t.Fatalf("expected the custom CA to verify the chain, got success=%v verify=%q", m.Success, m.Integrity.VerifyError)
+	}
+
+	invalid := filepath.Join(dir, "invalid.pem")
+	if err := os.WriteFile(invalid, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatalf("write invalid bundle: %v", err)
+	}
+	if err := p.UseCABundle(invalid); err == nil {
+		t.Fatalf("expected an unparsable bundle to be rejected")
+	}
+}
+
+func TestProberReliabilityConnects(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("CF-Ray", "abc-SJC")
+	}))
+	defer server.Close()
+
+	p, ip := newTestProber(t, server)
+	p.ReliabilityProbes = 5
+	m, err := p.Probe(context.Background(), ip, "example.com")
+	if err != nil {
+		t.Fatalf("Probe error = %v", err)
+	}
+	if m.ReliabilityAttempts != 5 || m.Reliability != 1 {
+		t.Fatalf("expected 5/5 reliability connects, got %d attempts at %v", m.ReliabilityAttempts, m.Reliability)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	_, closedPort, _ := net.SplitHostPort(listener.Addr().String())
+	listener.Close()
+	p.Port = closedPort
+	m, err = p.Probe(context.Background(), ip, "example.com")
+	if err != nil {
+		t.Fatalf("Probe error = %v", err)
+	}
+	if m.ReliabilityAttempts != 5 || m.Reliability != 0 {
+		t.Fatalf("expected 0/5 reliability connects to a closed port, got %d attempts at %v", m.ReliabilityAttempts, m.Reliability)
+	}
+}
+
+func TestRequestHeadersReachServer(t *testing.T) {
+	received := make(chan *http.Request, 1)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Clone(context.Background())
+		w.Header().Set("CF-RAY", "12345-SJC")
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	ipStr, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	dialer := &net.Dialer{Timeout: time.Second}
+	tlsConfig := &tls.Config{ServerName: "example.com", InsecureSkipVerify: true, NextProtos: []string{"http/1.1"}}
+	transport := &http.Transport{DialContext: dialer.DialContext, TLSClientConfig: tlsConfig}
+	client := &http.Client{Transport: transport, Timeout: 2 * time.Second}
+	p := &Prober{Dialer: dialer, TLSConfig: tlsConfig, HTTPClient: client, HTTPMethod: http.MethodGet, HTTPPath: "/", Port: port,
+		RequestHeaders: map[string]string{"Accept-Language": "zh-CN,zh;q=0.9", "Accept": "text/html", "host": "evil.example"}}
+
+	m, err := p.Probe(context.Background(), net.ParseIP(ipStr), "example.com")
+	if err != nil || !m.Success {
+		t.Fatalf("probe failed: %v %+v", err, m)
+	}
+	r := <-received
+	if got := r.Header.Get("Accept-Language"); got != "zh-CN,zh;q=0.9" {
+		t.Fatalf("expected Accept-Language to reach the server, got %q", got)
+	}
+	if got := r.Header.Get("Accept"); got != "text/html" {
+		t.Fatalf("expected Accept to reach the server, got %q", got)
+	}
+	if r.Host != "example.com" || m.RequestHost != "example.com" {
+		t.Fatalf("expected Host to stay example.com, got %q (recorded %q)", r.Host, m.RequestHost)
+	}
+}
+
+func TestWebSocketUpgradeRecorded(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("CF-RAY", "12345-SJC")
+		if r.URL.Path != "/ws" || !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			w.Write([]byte("ok"))
+			return
+		}
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		fmt.Fprintf(buf, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", websocketAccept(r.Header.Get("Sec-WebSocket-Key")))
+		buf.Flush()
+	}))
+	defer server.Close()
+
+	ipStr, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	dialer := &net.Dialer{Timeout: time.Second}
+	tlsConfig := &tls.Config{ServerName: "example.com", InsecureSkipVerify: true, NextProtos: []string{"http/1.1"}}
+	transport := &http.Transport{DialContext: dialer.DialContext, TLSClientConfig: tlsConfig}
+	client := &http.Client{Transport: transport, Timeout: 2 * time.Second}
+	p := &Prober{Dialer: dialer, TLSConfig: tlsConfig, HTTPClient: client, HTTPMethod: http.MethodGet, HTTPPath: "/", Port: port}
+
+	m, err := p.Probe(context.Background(), net.ParseIP(ipStr), "example.com")
+	if err != nil || !m.Success {
+		t.Fatalf("probe failed: %v %+v", err, m)
+	}
+	if m.WSUpgradeOK || m.WSStatus != 0 {
+		t.Fatalf("expected no WebSocket probe by default, got %v %d", m.WSUpgradeOK, m.WSStatus)
+	}
+
+	p.WebSocketPath = "/ws"
+	m, err = p.Probe(context.Background(), net.ParseIP(ipStr), "example.com")
+	if err != nil || !m.Success {
+		t.Fatalf("probe failed: %v %+v", err, m)
+	}
+	if !m.WSUpgradeOK || m.WSStatus != http.StatusSwitchingProtocols || m.WSError != "" {
+		t.Fatalf("expected a successful upgrade, got ok=%v status=%d err=%q", m.WSUpgradeOK, m.WSStatus, m.WSError)
+	}
+
+	p.WebSocketPath = "/plain"
+	m, _ = p.Probe(context.Background(), net.ParseIP(ipStr), "example.com")
+	if m.WSUpgradeOK || m.WSStatus != http.StatusOK {
+		t.Fatalf("expected a non-upgrading path to fail the check, got ok=%v status=%d", m.WSUpgradeOK, m.WSStatus)
+	}
+}
+
+func TestCaptureRedirectsRecordsLocation(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("CF-RAY", "12345-SJC")
+		if r.URL.Path == "/" {
+			http.Redirect(w, r, "https://elsewhere.example/landing", http.StatusFound)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	ipStr, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	dialer := &net.Dialer{Timeout: time.Second}
+	tlsConfig := &tls.Config{ServerName: "example.com", InsecureSkipVerify: true, NextProtos: []string{"http/1.1"}}
+	transport := &http.Transport{DialContext: dialer.DialContext, TLSClientConfig: tlsConfig}
+	client := &http.Client{Transport: transport, Timeout: 2 * time.Second}
+	p := &Prober{Dialer: dialer, TLSConfig: tlsConfig, HTTPClient: client, HTTPMethod: http.MethodGet, HTTPPath: "/", Port: port, CaptureRedirects: true}
+
+	m, err := p.Probe(context.Background(), net.ParseIP(ipStr), "example.com")
+	if err != nil {
+		t.Fatalf("probe error: %v", err)
+	}
+	if m.Integrity.HTTPStatus != http.StatusFound || m.RedirectLocation != "https://elsewhere.example/landing" {
+		t.Fatalf("expected the 302 and its Location to be captured, got %d %q", m.Integrity.HTTPStatus, m.RedirectLocation)
+	}
+	if !m.Success {
+		t.Fatalf("expected 3xx to keep counting as success by default")
+	}
+}
+
+func TestRecordHeadersTruncatesOversizedSets(t *testing.T) {
+	header := http.Header{}
+	for i := 0; i < 500; i++ {
+		header.Set(fmt.Sprintf("X-Junk-%03d", i), "v")
+	}
+	p := &Prober{}
+	recorded, truncated := p.recordHeaders(header)
+	if !truncated || len(recorded) != DefaultMaxHeaders {
+		t.Fatalf("expected %d headers and the truncation marker, got %d %v", DefaultMaxHeaders, len(recorded), truncated)
+	}
+	if _, ok := recorded["X-Junk-000"]; !ok {
+		t.Fatalf("expected headers kept in name order, got %v", recorded)
+	}
+
+	p.MaxHeaderBytes = 100
+	recorded, truncated = p.recordHeaders(http.Header{"Cf-Ray": {"12345-SJC"}, "X-Big": {strings.Repeat("a", 200)}})
+	if !truncated || len(recorded) != 1 || recorded["Cf-Ray"] != "12345-SJC" {
+		t.Fatalf("expected the byte cap to drop the large header, got %v %v", recorded, truncated)
+	}
+
+	recorded, truncated = p.recordHeaders(http.Header{"Server": {"cloudflare"}})
+	if truncated || recorded["Server"] != "cloudflare" {
+		t.Fatalf("expected small header sets untouched, got %v %v", recorded, truncated)
+	}
+
+	recorded, truncated = p.recordHeaders(http.Header{"A-Big": {strings.Repeat("a", 200)}, "Server": {"cloudflare"}})
+	if !truncated || recorded["Server"] != "cloudflare" {
+		t.Fatalf("expected smaller headers after an oversized one to be kept, got %v %v", recorded, truncated)
+	}
+}
+
+func TestRecordHeadersKeepsCfMitigated(t *testing.T) {
+	header := http.Header{}
+	for i := 0; i < 500; i++ {
+		header.Set(fmt.Sprintf("A-Junk-%03d", i), strings.Repeat("v", 40))
+	}
+	header.Set("Cf-Mitigated", "challenge")
+	p := &Prober{}
+	recorded, truncated := p.recordHeaders(header)
+	if !truncated || len(recorded) > DefaultMaxHeaders {
+		t.Fatalf("expected the caps to apply, got %d headers truncated=%v", len(recorded), truncated)
+	}
+	if recorded["Cf-Mitigated"] != "challenge" {
+		t.Fatalf("expected Cf-Mitigated to survive truncation, got %v", recorded)
+	}
+	m := Measurement{Error: "http status 403", HTTPFingerprint: HTTPFingerprint{Headers: recorded}}
+	if got := m.FailureCategory(); got != CategoryChallenge {
+		t.Fatalf("expected a challenge failure, got %q", got)
+	}
+}
+
+func TestTraceHTTPRecordsPhases(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.Header().Set("CF-RAY", "12345-SJC")
+		w.Write([]byte("hello"))
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	ipStr, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	dialer := &net.Dialer{Timeout: time.Second}
+	tlsConfig := &tls.Config{ServerName: "example.com", InsecureSkipVerify: true, NextProtos: []string{"h2", "http/1.1"}}
+	transport := &http.Transport{DialContext: dialer.DialContext, TLSClientConfig: tlsConfig, ForceAttemptHTTP2: true}
+	client := &http.Client{Transport: transport, Timeout: 2 * time.Second}
+	p := &Prober{Dialer: dialer, TLSConfig: tlsConfig, HTTPClient: client, HTTPMethod: http.MethodGet, HTTPPath: "/", Port: port, TraceHTTP: true}
+
+	m, err := p.Probe(context.Background(), net.ParseIP(ipStr), "example.com")
+	if err != nil || !m.Success {
+		t.Fatalf("probe failed: %v %+v", err, m)
+	}
+	timing := m.HTTPTiming
+	if timing == nil {
+		t.Fatalf("expected HTTP timing to be recorded")
+	}
+	if timing.Protocol != "HTTP/2.0" {
+		t.Fatalf("expected an HTTP/2 exchange, got %q", timing.Protocol)
+	}
+	if timing.ConnectionSetup <= 0 || timing.FirstByte < 20*time.Millisecond {
+		t.Fatalf("expected connection setup and a first byte after the handler delay, got %+v", timing)
+	}
+	for name, phase := range map[string]time.Duration{"setup": timing.ConnectionSetup, "write": timing.RequestWrite, "first byte": timing.FirstByte, "body": timing.BodyRead} {
+		if phase < 0 {
+			t.Fatalf("%s phase is negative: %s", name, phase)
+		}
+	}
+	if sum := timing.ConnectionSetup + timing.RequestWrite + timing.FirstByte + timing.BodyRead; sum != m.HTTPDuration {
+		t.Fatalf("expected phases to add up to %s, got %s", m.HTTPDuration, sum)
+	}
+
+	p.TraceHTTP = false
+	m, err = p.Probe(context.Background(), net.ParseIP(ipStr), "example.com")
+	if err != nil || m.HTTPTiming != nil {
+		t.Fatalf("expected no timing without TraceHTTP, got %+v (%v)", m.HTTPTiming, err)
+	}
+}
+
+func TestEffectiveURLRecordsPortAndPath(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("CF-RAY", "12345-SJC")
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	ipStr, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	dialer := &net.Dialer{Timeout: time.Second}
+	tlsConfig := &tls.Config{ServerName: "example.com", InsecureSkipVerify: true, NextProtos: []string{"http/1.1"}}
+	client := &http.Client{Transport: &http.Transport{DialContext: dialer.DialContext, TLSClientConfig: tlsConfig}, Timeout: 2 * time.Second}
+	p := &Prober{Dialer: dialer, TLSConfig: tlsConfig, HTTPClient: client, HTTPMethod: http.MethodGet, HTTPPath: "/cdn-cgi/health?probe=1", Port: port}
+
+	m, err := p.Probe(context.Background(), net.ParseIP(ipStr), "example.com")
+	if err != nil || !m.Success {
+		t.Fatalf("probe failed: %v %+v", err, m)
+	}
+	want := "https://example.com:" + port + "/cdn-cgi/health?probe=1"
+	if m.EffectiveURL != want {
+		t.Fatalf("expected effective URL %q, got %q", want, m.EffectiveURL)
+	}
+	if m.RequestHost != "example.com" {
+		t.Fatalf("expected the Host header to stay example.com, got %q", m.RequestHost)
+	}
+}
@@ -0,0 +1,34 @@
+package prober
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// DefaultReliabilityTimeout bounds each reliability connect when
+// Prober.ReliabilityTimeout is zero.
+const DefaultReliabilityTimeout = 2 * time.Second
+
+// measureReliability performs ReliabilityProbes plain TCP connects to address
+// and returns the attempt count and the fraction that succeeded.
+func (p *Prober) measureReliability(ctx context.Context, address string) (int, float64) {
+	dialer := net.Dialer{}
+	if p.Dialer != nil {
+		dialer = *p.Dialer
+	}
+	dialer.Timeout = p.ReliabilityTimeout
+	if dialer.Timeout <= 0 {
+		dialer.Timeout = DefaultReliabilityTimeout
+	}
+	successes := 0
+	for i := 0; i < p.ReliabilityProbes; i++ {
+		conn, err := dialer.DialContext(ctx, "tcp", address)
+		if err != nil {
+			continue
+		}
+		successes++
+		_ = conn.Close()
+	}
+	return p.ReliabilityProbes, float64(successes) / float64(p.ReliabilityProbes)
+}
@@ -0,0 +1,95 @@
+package prober
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultWebSocketTimeout bounds the WebSocket handshake when
+// Prober.WebSocketTimeout is zero.
+const DefaultWebSocketTimeout = 5 * time.Second
+
+// websocketGUID is the fixed suffix defined by RFC 6455 for deriving
+// Sec-WebSocket-Accept.
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// probeWebSocket performs an HTTP/1.1 Upgrade handshake against
+// WebSocketPath and reports the response status and whether it matched the
+// expected status. A 101 response must also carry the correct
+// Sec-WebSocket-Accept value.
+func (p *Prober) probeWebSocket(ctx context.Context, ip net.IP, domain string) (int, bool, error) {
+	timeout := p.WebSocketTimeout
+	if timeout <= 0 {
+		timeout = DefaultWebSocketTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cfg := p.tlsConfigFor(ip, domain)
+	// WebSocket upgrades are defined for HTTP/1.1 only.
+	cfg.NextProtos = []string{"http/1.1"}
+	conn, err := (&tls.Dialer{NetDialer: p.Dialer, Config: cfg}).DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), p.port()))
+	if err != nil {
+		return 0, false, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	var nonce [16]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return 0, false, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://"+domain+p.WebSocketPath, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	req.Host = domain
+	p.applyRequestHeaders(req)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	if err := req.Write(conn); err != nil {
+		return 0, false, err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return 0, false, err
+	}
+	_ = resp.Body.Close()
+
+	expected := p.WebSocketStatus
+	if expected == 0 {
+		expected = http.StatusSwitchingProtocols
+	}
+	if resp.StatusCode != expected {
+		return resp.StatusCode, false, nil
+	}
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		if !strings.EqualFold(resp.Header.Get("Upgrade"), "websocket") {
+			return resp.StatusCode, false, fmt.Errorf("upgrade header %q", resp.Header.Get("Upgrade"))
+		}
+		if got := resp.Header.Get("Sec-WebSocket-Accept"); got != websocketAccept(key) {
+			return resp.StatusCode, false, fmt.Errorf("unexpected Sec-WebSocket-Accept %q", got)
+		}
+	}
+	return resp.StatusCode, true, nil
+}
+
+// websocketAccept derives the Sec-WebSocket-Accept value for key.
+func websocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,53 @@
+package sampler
+
+import (
+	"time"
+
+	"github.com/example/cf-edgescout/store"
+)
+
+// DefaultDensityStrength is the de-emphasis applied per recent probe when
+// UseProbeDensity is given a non-positive strength.
+const DefaultDensityStrength = 1.0
+
+// RecentProbeCounts counts the stored records per network that are newer than
+// since.
+func RecentProbeCounts(records []store.Record, since time.Time) map[string]int {
+	counts := make(map[string]int)
+	for _, record := range records {
+		key := record.Measurement.Network
+		if key == "" || record.Timestamp.Before(since) {
+			continue
+		}
+		counts[key]++
+	}
+	return counts
+}
+
+// UseProbeDensity de-emphasises networks that were probed recently so probes
+// rotate across ranges: each network's weight is divided by
+// 1 + strength*counts[network]. Passing nil counts disables the bias.
+// strength <= 0 uses DefaultDensityStrength.
+func (s *Sampler) UseProbeDensity(counts map[string]int, strength float64) {
+	if strength <= 0 {
+		strength = DefaultDensityStrength
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.probeDensity = counts
+	s.densityStrength = strength
+}
+
+// applyDensity scales the pool weights down by recent probe density.
+func (s *Sampler) applyDensity(pool []poolEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.probeDensity == nil {
+		return
+	}
+	for i := range pool {
+		if count := s.probeDensity[pool[i].network.String()]; count > 0 {
+			pool[i].weight /= 1 + s.densityStrength*float64(count)
+		}
+	}
+}
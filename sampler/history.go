@@ -0,0 +1,77 @@
+package sampler
+
+import (
+	"github.com/example/cf-edgescout/store"
+)
+
+const (
+	// DefaultExploration is the weight floor added to every network when
+	// sampling by history, so unproductive or unseen ranges are still probed.
+	DefaultExploration = 0.1
+	// historyPrior is the number of neutral pseudo-probes blended into each
+	// network's history so a handful of results cannot dominate.
+	historyPrior = 2
+)
+
+// NetworkStats summarises past probes of a single network.
+type NetworkStats struct {
+	Probes    int
+	Successes int
+	ScoreSum  float64
+}
+
+// Quality blends the success rate and average score into [0,1], smoothed
+// towards 0.5 for networks with little history.
+func (n NetworkStats) Quality() float64 {
+	value := (float64(n.Successes) + n.ScoreSum) / 2
+	return (value + historyPrior*0.5) / (float64(n.Probes) + historyPrior)
+}
+
+// StatsFromRecords groups stored records by the network they were sampled from.
+func StatsFromRecords(records []store.Record) map[string]NetworkStats {
+	stats := make(map[string]NetworkStats)
+	for _, record := range records {
+		key := record.Measurement.Network
+		if key == "" {
+			continue
+		}
+		entry := stats[key]
+		entry.Probes++
+		if record.Measurement.Success {
+			entry.Successes++
+		}
+		entry.ScoreSum += record.Score
+		stats[key] = entry
+	}
+	return stats
+}
+
+// UseHistory biases network selection by past results: each network's weight
+// is multiplied by exploration plus its Quality, and networks without history
+// use the neutral quality of 0.5. Passing nil stats disables the bias.
+// exploration <= 0 uses DefaultExploration.
+func (s *Sampler) UseHistory(stats map[string]NetworkStats, exploration float64) {
+	if exploration <= 0 {
+		exploration = DefaultExploration
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.networkStats = stats
+	s.exploration = exploration
+}
+
+// applyHistory scales the pool weights by the configured history bias.
+func (s *Sampler) applyHistory(pool []poolEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.networkStats == nil {
+		return
+	}
+	for i := range pool {
+		quality := 0.5
+		if stats, ok := s.networkStats[pool[i].network.String()]; ok {
+			quality = stats.Quality()
+		}
+		pool[i].weight *= s.exploration + quality
+	}
+}
@@ -0,0 +1,124 @@
+package sampler
+
+import (
+	"net"
+	"sort"
+
+	"github.com/example/cf-edgescout/store"
+)
+
+const (
+	// DefaultNeighbourPrefix is the IPv4 prefix around each winner that is
+	// favoured; IPv6 winners use the prefix with the same number of host bits.
+	DefaultNeighbourPrefix = 28
+	// DefaultNeighbourShare is the fraction of candidate slots offered to
+	// winner neighbourhoods; the rest follow the regular pool.
+	DefaultNeighbourShare = 0.5
+)
+
+// UseNeighbours biases sampling towards the addresses around previously good
+// IPs. Each winner's /prefix (clipped to the network it belongs to) competes
+// for share of the candidate slots while unseen addresses remain in it; the
+// remaining slots keep exploring the whole pool. Winners outside every sampled
+// network are ignored. Passing no winners disables the bias. prefix <= 0 uses
+// DefaultNeighbourPrefix and share outside (0,1) uses DefaultNeighbourShare.
+func (s *Sampler) UseNeighbours(winners []net.IP, prefix int, share float64) {
+	if prefix <= 0 {
+		prefix = DefaultNeighbourPrefix
+	}
+	if share <= 0 || share >= 1 {
+		share = DefaultNeighbourShare
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.winners = append([]net.IP(nil), winners...)
+	s.neighbourPrefix = prefix
+	s.neighbourShare = share
+}
+
+// WinnersFromRecords returns up to limit distinct IPs of passing records,
+// best score first. limit <= 0 returns them all.
+func WinnersFromRecords(records []store.Record, limit int) []net.IP {
+	passing := make([]store.Record, 0, len(records))
+	for _, record := range records {
+		if record.Status == "pass" && record.Measurement.IP != nil {
+			passing = append(passing, record)
+		}
+	}
+	sort.SliceStable(passing, func(i, j int) bool {
+		return passing[i].Score > passing[j].Score
+	})
+	seen := map[string]struct{}{}
+	var winners []net.IP
+	for _, record := range passing {
+		key := record.Measurement.IP.String()
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		winners = append(winners, record.Measurement.IP)
+		if limit > 0 && len(winners) == limit {
+			break
+		}
+	}
+	return winners
+}
+
+// applyNeighbours appends one entry per winner neighbourhood, weighted so the
+// neighbourhoods together hold the configured share of the pool.
+func (s *Sampler) applyNeighbours(pool []poolEntry) []poolEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.winners) == 0 {
+		return pool
+	}
+	var base float64
+	for _, entry := range pool {
+		base += entry.weight
+	}
+	seen := map[string]struct{}{}
+	var neighbours []poolEntry
+	for _, winner := range s.winners {
+		for _, entry := range pool {
+			if !entry.network.Contains(winner) {
+				continue
+			}
+			subnet := neighbourhood(winner, entry.network, s.neighbourPrefix)
+			if _, ok := seen[subnet.String()]; !ok {
+				seen[subnet.String()] = struct{}{}
+				entry.subnet = subnet
+				neighbours = append(neighbours, entry)
+			}
+			break
+		}
+	}
+	if len(neighbours) == 0 || base == 0 {
+		return pool
+	}
+	weight := base * s.neighbourShare / (1 - s.neighbourShare) / float64(len(neighbours))
+	for i := range neighbours {
+		neighbours[i].weight = weight
+	}
+	return append(pool, neighbours...)
+}
+
+// neighbourhood masks ip to prefix (scaled for IPv6), never widening beyond
+// the enclosing network.
+func neighbourhood(ip net.IP, network *net.IPNet, prefix int) *net.IPNet {
+	ones, bits := network.Mask.Size()
+	want := prefix
+	if bits == 128 {
+		want = 128 - (32 - prefix)
+	}
+	if want < ones {
+		want = ones
+	}
+	if want > bits {
+		want = bits
+	}
+	mask := net.CIDRMask(want, bits)
+	if bits == 32 {
+		ip = ip.To4()
+	}
+	return &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+}
@@ -1,7 +1,9 @@
 package sampler
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"math"
 	"math/big"
 	mathrand "math/rand"
@@ -28,20 +30,49 @@ type Candidate struct {
 
 // Sampler produces candidate IPs from Cloudflare network ranges.
 type Sampler struct {
+	// MaxPerNetwork caps the candidates drawn from a single network per call;
+	// capped networks leave the pool so the others top up the total. Zero
+	// disables the cap.
+	MaxPerNetwork int
+	// MinSourceCount restricts sampling to networks listed by at least this
+	// many distinct sources. Values below 2 disable the filter.
+	MinSourceCount int
+	// HistoryTTL lets remembered IPs become eligible again once they have been
+	// in the history for this long. Expired entries are evicted lazily as
+	// draws hit them. Zero keeps every IP forever.
+	HistoryTTL time.Duration
+	// Exclude lists networks that must never be sampled. Source networks
+	// overlapping one are trimmed with fetcher.SubtractRanges before the pool
+	// is built.
+	Exclude []*net.IPNet
+
 	mu       sync.Mutex
-	history  map[string]struct{}
+	history  map[string]time.Time
+	now      func() time.Time
 	rng      *mathrand.Rand
 	maxTries int
+
+	networkStats map[string]NetworkStats
+	exploration  float64
+
+	probeDensity    map[string]int
+	densityStrength float64
+
+	winners         []net.IP
+	neighbourPrefix int
+	neighbourShare  float64
 }
 
 // New returns a Sampler initialised with a history of previously probed IPs.
 func New(previous []net.IP) *Sampler {
-	h := make(map[string]struct{}, len(previous))
+	now := time.Now()
+	h := make(map[string]time.Time, len(previous))
 	for _, ip := range previous {
-		h[ip.String()] = struct{}{}
+		h[ip.String()] = now
 	}
 	return &Sampler{
 		history:  h,
+		now:      time.Now,
 		rng:      mathrand.New(mathrand.NewSource(time.Now().UnixNano())),
 		maxTries: 8,
 	}
@@ -51,7 +82,21 @@ func New(previous []net.IP) *Sampler {
 func (s *Sampler) Remember(ip net.IP) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.history[ip.String()] = struct{}{}
+	s.history[ip.String()] = s.now()
+}
+
+// seen reports whether key is in the history, evicting it first when it has
+// outlived HistoryTTL. Callers must hold s.mu.
+func (s *Sampler) seen(key string) bool {
+	added, ok := s.history[key]
+	if !ok {
+		return false
+	}
+	if s.HistoryTTL > 0 && s.now().Sub(added) >= s.HistoryTTL {
+		delete(s.history, key)
+		return false
+	}
+	return true
 }
 
 // Sample selects up to total candidates using the aggregated range set.
@@ -61,100 +106,242 @@ func (s *Sampler) Sample(rs fetcher.RangeSet, total int) ([]Candidate, error) {
 }
 
 // SampleSources selects candidates across multiple provider range sets.
+//
+// Every network of every source is placed in a single pool weighted by the
+// source weight and the network size, and each candidate slot is filled with a
+// weighted reservoir pass over that pool. Networks that run out of unseen
+// addresses drop out of the pool, so the requested total is met exactly as long
+// as enough addresses remain.
 func (s *Sampler) SampleSources(sources []fetcher.SourceRange, total int) ([]Candidate, error) {
+	pool, err := preparePool(s.withoutExcluded(sources), total)
+	if err != nil {
+		return nil, err
+	}
+	pool, err = s.applyMinSources(pool)
+	if err != nil {
+		return nil, err
+	}
+	s.applyHistory(pool)
+	s.applyDensity(pool)
+	pool = s.applyNeighbours(pool)
+	results := make([]Candidate, 0, total)
+	for len(results) < total {
+		candidate, ok := s.next(pool)
+		if !ok {
+			break
+		}
+		results = append(results, candidate)
+	}
+	if len(results) == 0 {
+		return nil, errors.New("no candidates produced")
+	}
+	return results, nil
+}
+
+// Stream produces up to total candidates lazily on the returned channel using
+// the same selection as SampleSources. Only the network pool is held in memory;
+// addresses are drawn as the consumer receives them. The channel is closed once
+// the total is reached, the networks are exhausted or ctx is cancelled.
+func (s *Sampler) Stream(ctx context.Context, sources []fetcher.SourceRange, total int) (<-chan Candidate, error) {
+	pool, err := preparePool(s.withoutExcluded(sources), total)
+	if err != nil {
+		return nil, err
+	}
+	pool, err = s.applyMinSources(pool)
+	if err != nil {
+		return nil, err
+	}
+	s.applyHistory(pool)
+	s.applyDensity(pool)
+	pool = s.applyNeighbours(pool)
+	out := make(chan Candidate)
+	go func() {
+		defer close(out)
+		for produced := 0; produced < total; produced++ {
+			candidate, ok := s.next(pool)
+			if !ok {
+				return
+			}
+			select {
+			case out <- candidate:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// withoutExcluded returns sources with the Exclude networks subtracted.
+func (s *Sampler) withoutExcluded(sources []fetcher.SourceRange) []fetcher.SourceRange {
+	if len(s.Exclude) == 0 {
+		return sources
+	}
+	trimmed := make([]fetcher.SourceRange, 0, len(sources))
+	for _, source := range sources {
+		source.RangeSet = fetcher.SubtractRanges(source.RangeSet, s.Exclude)
+		trimmed = append(trimmed, source)
+	}
+	return trimmed
+}
+
+func preparePool(sources []fetcher.SourceRange, total int) ([]poolEntry, error) {
 	if total <= 0 {
 		return nil, errors.New("total must be > 0")
 	}
 	if len(sources) == 0 {
 		return nil, errors.New("no sources available")
 	}
-	weights := make([]float64, len(sources))
-	var weightSum float64
-	for i, source := range sources {
-		weight := source.Provider.Weight
-		if weight <= 0 {
-			weight = 1
+	pool := buildPool(sources)
+	if len(pool) == 0 {
+		return nil, errors.New("数据源缺少可用网段")
+	}
+	return pool, nil
+}
+
+// applyMinSources drops networks listed by fewer than MinSourceCount sources.
+// A network's count is the larger of the aggregation metadata carried by its
+// RangeSet and the number of sources in this call listing the same CIDR.
+func (s *Sampler) applyMinSources(pool []poolEntry) ([]poolEntry, error) {
+	if s.MinSourceCount < 2 {
+		return pool, nil
+	}
+	listed := map[string]map[string]struct{}{}
+	for _, entry := range pool {
+		key := entry.network.String()
+		if listed[key] == nil {
+			listed[key] = map[string]struct{}{}
 		}
-		weights[i] = weight
-		weightSum += weight
+		listed[key][entry.source.Provider.Name] = struct{}{}
 	}
-	if weightSum == 0 {
-		weightSum = 1
+	kept := make([]poolEntry, 0, len(pool))
+	for _, entry := range pool {
+		count := max(entry.source.RangeSet.SourceCount(entry.network), len(listed[entry.network.String()]))
+		if count >= s.MinSourceCount {
+			kept = append(kept, entry)
+		}
 	}
-	results := make([]Candidate, 0, total)
-	remaining := total
-	for i, source := range sources {
-		if remaining <= 0 {
-			break
+	if len(kept) == 0 {
+		return nil, fmt.Errorf("没有网段被至少 %d 个数据源收录", s.MinSourceCount)
+	}
+	return kept, nil
+}
+
+// next draws one candidate from the pool, retiring networks that have run out
+// of unseen addresses. It reports false once every network is exhausted.
+func (s *Sampler) next(pool []poolEntry) (Candidate, bool) {
+	for {
+		idx := s.reservoirPick(pool)
+		if idx < 0 {
+			return Candidate{}, false
 		}
-		portion := int(math.Round(float64(total) * weights[i] / weightSum))
-		if portion <= 0 {
-			portion = 1
+		entry := &pool[idx]
+		if s.MaxPerNetwork > 0 && *entry.picked >= s.MaxPerNetwork {
+			entry.weight = 0
+			continue
 		}
-		if portion > remaining {
-			portion = remaining
+		ip, ok := s.pickUniqueIP(entry.draw())
+		if !ok {
+			entry.weight = 0
+			continue
 		}
-		sampled, err := s.sampleRange(source, portion)
-		if err != nil {
-			return nil, err
+		*entry.picked++
+		if s.MaxPerNetwork > 0 && *entry.picked >= s.MaxPerNetwork {
+			entry.weight = 0
 		}
-		results = append(results, sampled...)
-		remaining = total - len(results)
+		return entry.candidate(ip), true
 	}
-	if len(results) == 0 {
-		return nil, errors.New("no candidates produced")
-	}
-	return results, nil
 }
 
-func (s *Sampler) sampleRange(source fetcher.SourceRange, total int) ([]Candidate, error) {
-	networks := append([]*net.IPNet{}, source.RangeSet.IPv4...)
-	networks = append(networks, source.RangeSet.IPv6...)
-	if len(networks) == 0 {
-		return nil, errors.New("数据源缺少可用网段")
-	}
-	weights := make([]float64, len(networks))
-	var weightSum float64
-	for i, n := range networks {
-		weights[i] = weightForNetwork(n)
-		weightSum += weights[i]
+// poolEntry is a single network competing for candidate slots. Neighbourhood
+// entries draw from subnet but report the enclosing network and share its
+// picked counter, so MaxPerNetwork caps both together.
+type poolEntry struct {
+	source  fetcher.SourceRange
+	network *net.IPNet
+	subnet  *net.IPNet
+	weight  float64
+	picked  *int
+}
+
+func (e poolEntry) draw() *net.IPNet {
+	if e.subnet != nil {
+		return e.subnet
 	}
-	if weightSum == 0 {
-		weightSum = 1
+	return e.network
+}
+
+func (e poolEntry) candidate(ip net.IP) Candidate {
+	return Candidate{
+		IP:           ip,
+		Network:      e.network,
+		Family:       familyOf(e.network),
+		Source:       e.source.Provider.Name,
+		Provider:     e.source.Provider.DisplayName,
+		ProviderKind: e.source.Provider.Kind,
+		Weight:       e.source.Provider.Weight,
+		Domain:       e.source.Provider.Domain,
 	}
-	candidates := make([]Candidate, 0, total)
-	for i, network := range networks {
-		if len(candidates) >= total {
-			break
+}
+
+// buildPool flattens the sources into weighted networks. Each source receives a
+// share proportional to its provider weight, split across its networks by size.
+// Networks rejected by samplableNetwork are left out.
+func buildPool(sources []fetcher.SourceRange) []poolEntry {
+	var pool []poolEntry
+	for _, source := range sources {
+		var networks []*net.IPNet
+		for _, network := range append(append([]*net.IPNet{}, source.RangeSet.IPv4...), source.RangeSet.IPv6...) {
+			if samplableNetwork(network) {
+				networks = append(networks, network)
+			}
 		}
-		portion := int(math.Round(float64(total) * weights[i] / weightSum))
-		if portion <= 0 {
-			portion = 1
+		var sizeSum float64
+		for _, network := range networks {
+			sizeSum += weightForNetwork(network)
 		}
-		for portion > 0 && len(candidates) < total {
-			ip, ok := s.pickUniqueIP(network)
-			if !ok {
-				break
-			}
-			candidate := Candidate{
-				IP:           ip,
-				Network:      network,
-				Family:       familyOf(network),
-				Source:       source.Provider.Name,
-				Provider:     source.Provider.DisplayName,
-				ProviderKind: source.Provider.Kind,
-				Weight:       source.Provider.Weight,
-			}
-			candidates = append(candidates, candidate)
-			portion--
+		if sizeSum == 0 {
+			continue
+		}
+		share := source.Provider.Weight
+		if share <= 0 {
+			share = 1
+		}
+		for _, network := range networks {
+			pool = append(pool, poolEntry{
+				source:  source,
+				network: network,
+				weight:  share * weightForNetwork(network) / sizeSum,
+				picked:  new(int),
+			})
 		}
 	}
-	if len(candidates) == 0 {
-		return nil, errors.New("no networks yielded candidates")
+	return pool
+}
+
+// reservoirPick performs a single-slot weighted reservoir pass over the pool
+// and returns the selected index, or -1 when every entry has been exhausted.
+func (s *Sampler) reservoirPick(pool []poolEntry) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	selected := -1
+	var running float64
+	for i, entry := range pool {
+		if entry.weight <= 0 {
+			continue
+		}
+		running += entry.weight
+		if s.rng.Float64()*running < entry.weight {
+			selected = i
+		}
 	}
-	return candidates, nil
+	return selected
 }
 
+// exhaustiveScanLimit bounds the host count below which pickUniqueIP falls back
+// to a linear scan once random draws keep colliding with the history.
+const exhaustiveScanLimit = 1 << 12
+
 func (s *Sampler) pickUniqueIP(network *net.IPNet) (net.IP, bool) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -164,15 +351,68 @@ func (s *Sampler) pickUniqueIP(network *net.IPNet) (net.IP, bool) {
 			return nil, false
 		}
 		key := ip.String()
-		if _, ok := s.history[key]; ok {
+		if s.seen(key) {
 			continue
 		}
-		s.history[key] = struct{}{}
+		s.history[key] = s.now()
+		return ip, true
+	}
+	return s.scanUnseenIP(network)
+}
+
+// scanUnseenIP walks small networks from a random offset looking for an address
+// missing from the history. Callers must hold s.mu.
+func (s *Sampler) scanUnseenIP(network *net.IPNet) (net.IP, bool) {
+	ones, bits := network.Mask.Size()
+	span := bits - ones
+	if span < 0 || span > 12 {
+		return nil, false
+	}
+	size := 1 << uint(span)
+	if size > exhaustiveScanLimit {
+		return nil, false
+	}
+	start := s.rng.Intn(size)
+	for i := 0; i < size; i++ {
+		ip := nthIP(network, (start+i)%size)
+		if ip == nil {
+			return nil, false
+		}
+		key := ip.String()
+		if s.seen(key) {
+			continue
+		}
+		s.history[key] = s.now()
 		return ip, true
 	}
 	return nil, false
 }
 
+// Shortest prefixes accepted from a source. Nothing Cloudflare announces comes
+// close; anything wider is a malformed range (such as 0.0.0.0/0 or ::/0) and
+// would turn sampling into a uniform draw over the whole address space.
+const (
+	minIPv4Prefix = 8
+	minIPv6Prefix = 16
+)
+
+// samplableNetwork reports whether network has a canonical mask no wider than
+// the family's minimum prefix, which also bounds the span randomIP draws from.
+func samplableNetwork(network *net.IPNet) bool {
+	if network == nil || network.IP == nil {
+		return false
+	}
+	ones, bits := network.Mask.Size()
+	switch {
+	case bits == 32 && network.IP.To4() != nil:
+		return ones >= minIPv4Prefix
+	case bits == 128 && len(network.IP) == net.IPv6len:
+		return ones >= minIPv6Prefix
+	default:
+		return false
+	}
+}
+
 func weightForNetwork(network *net.IPNet) float64 {
 	ones, bits := network.Mask.Size()
 	if ones < 0 || bits <= 0 {
@@ -196,25 +436,37 @@ func familyOf(network *net.IPNet) string {
 }
 
 func randomIP(network *net.IPNet, rng *mathrand.Rand) net.IP {
-	if network == nil {
+	if !samplableNetwork(network) {
 		return nil
 	}
 	ones, bits := network.Mask.Size()
-	if ones == 0 && bits == 0 {
-		return nil
-	}
 	span := bits - ones
 	if span <= 0 {
 		return copyIP(network.IP)
 	}
 	max := new(big.Int).Lsh(big.NewInt(1), uint(span))
-	offset := new(big.Int).Rand(rng, max)
-	base := network.IP.To16()
+	return offsetIP(network, new(big.Int).Rand(rng, max))
+}
+
+// nthIP returns the address at the given offset within the network.
+func nthIP(network *net.IPNet, n int) net.IP {
+	if network == nil {
+		return nil
+	}
+	return offsetIP(network, big.NewInt(int64(n)))
+}
+
+func offsetIP(network *net.IPNet, offset *big.Int) net.IP {
+	_, bits := network.Mask.Size()
+	base := network.IP.Mask(network.Mask).To16()
 	if base == nil {
 		return nil
 	}
 	baseInt := new(big.Int).SetBytes(base)
 	candidate := new(big.Int).Add(baseInt, offset).Bytes()
+	if len(candidate) > len(base) {
+		return nil
+	}
 	if len(candidate) < len(base) {
 		padded := make([]byte, len(base))
 		copy(padded[len(padded)-len(candidate):], candidate)
@@ -1,10 +1,14 @@
 package sampler
 
 import (
+	"context"
 	"net"
 	"testing"
+	"time"
 
 	"github.com/example/cf-edgescout/fetcher"
+	"github.com/example/cf-edgescout/prober"
+	"github.com/example/cf-edgescout/store"
 )
 
 func mustCIDR(t *testing.T, cidr string) *net.IPNet {
@@ -37,6 +41,57 @@ func TestSampleSources(t *testing.T) {
 	}
 }
 
+func TestSampleSourcesRejectsPathologicalPrefixes(t *testing.T) {
+	sampler := New(nil)
+	all := []fetcher.SourceRange{{
+		Provider: fetcher.ProviderSpec{Name: "broken", Weight: 1},
+		RangeSet: fetcher.RangeSet{IPv4: []*net.IPNet{mustCIDR(t, "0.0.0.0/0")}, IPv6: []*net.IPNet{mustCIDR(t, "::/0"), mustCIDR(t, "ff00::/8")}},
+	}}
+	if _, err := sampler.SampleSources(all, 4); err == nil {
+		t.Fatal("expected a source with only pathological networks to be rejected")
+	}
+
+	all[0].RangeSet.IPv6 = append(all[0].RangeSet.IPv6, mustCIDR(t, "2606:4700::/32"))
+	candidates, err := sampler.SampleSources(all, 8)
+	if err != nil {
+		t.Fatalf("SampleSources error = %v", err)
+	}
+	valid := mustCIDR(t, "2606:4700::/32")
+	for _, candidate := range candidates {
+		if !valid.Contains(candidate.IP) {
+			t.Fatalf("candidate %s drawn from a pathological network %s", candidate.IP, candidate.Network)
+		}
+	}
+	if len(candidates) != 8 {
+		t.Fatalf("expected 8 candidates, got %d", len(candidates))
+	}
+	if ip := randomIP(&net.IPNet{IP: net.ParseIP("ffff::1"), Mask: net.CIDRMask(0, 128)}, sampler.rng); ip != nil {
+		t.Fatalf("randomIP(::/0) = %s, want nil", ip)
+	}
+}
+
+func TestSampleSourcesNeverDrawsExcludedAddresses(t *testing.T) {
+	sampler := New(nil)
+	blocked := mustCIDR(t, "10.0.0.0/29")
+	sampler.Exclude = []*net.IPNet{blocked, mustCIDR(t, "172.16.0.0/28")}
+	sources := []fetcher.SourceRange{{
+		Provider: fetcher.ProviderSpec{Name: "official", Weight: 1},
+		RangeSet: fetcher.RangeSet{IPv4: []*net.IPNet{mustCIDR(t, "10.0.0.0/28"), mustCIDR(t, "172.16.0.0/28")}},
+	}}
+	candidates, err := sampler.SampleSources(sources, 16)
+	if err != nil {
+		t.Fatalf("SampleSources error = %v", err)
+	}
+	if len(candidates) != 8 {
+		t.Fatalf("expected only the 8 unexcluded addresses, got %d", len(candidates))
+	}
+	for _, candidate := range candidates {
+		if blocked.Contains(candidate.IP) || candidate.IP[0] == 172 {
+			t.Fatalf("sampled excluded address %s", candidate.IP)
+		}
+	}
+}
+
 func TestSample(t *testing.T) {
 	sampler := New(nil)
 	rs := fetcher.RangeSet{IPv4: []*net.IPNet{mustCIDR(t, "1.1.1.0/30")}}
@@ -48,3 +103,308 @@ func TestSample(t *testing.T) {
 		t.Fatalf("expected 2 candidates, got %d", len(candidates))
 	}
 }
+
+func TestSampleSourcesExactProportionalSplit(t *testing.T) {
+	large := mustCIDR(t, "10.0.0.0/20")
+	small := mustCIDR(t, "10.1.0.0/24")
+	sources := []fetcher.SourceRange{{
+		Provider: fetcher.ProviderSpec{Name: "official", Weight: 1},
+		RangeSet: fetcher.RangeSet{IPv4: []*net.IPNet{small, large}},
+	}}
+	const runs, total = 300, 17
+	var fromLarge, all int
+	for i := 0; i < runs; i++ {
+		candidates, err := New(nil).SampleSources(sources, total)
+		if err != nil {
+			t.Fatalf("SampleSources error = %v", err)
+		}
+		if len(candidates) != total {
+			t.Fatalf("expected exactly %d candidates, got %d", total, len(candidates))
+		}
+		for _, c := range candidates {
+			if large.Contains(c.IP) {
+				fromLarge++
+			}
+			all++
+		}
+	}
+	share := float64(fromLarge) / float64(all)
+	// The /20 holds 16x the hosts of the /24, so it should win ~94% of slots.
+	if share < 0.9 || share > 0.98 {
+		t.Fatalf("expected ~16/17 of candidates from the larger network, got %.3f", share)
+	}
+}
+
+func TestSampleSourcesExhaustsSmallNetwork(t *testing.T) {
+	sampler := New(nil)
+	rs := fetcher.RangeSet{IPv4: []*net.IPNet{mustCIDR(t, "1.1.1.0/29")}}
+	candidates, err := sampler.Sample(rs, 8)
+	if err != nil {
+		t.Fatalf("Sample error = %v", err)
+	}
+	if len(candidates) != 8 {
+		t.Fatalf("expected all 8 addresses, got %d", len(candidates))
+	}
+	seen := map[string]bool{}
+	for _, c := range candidates {
+		if seen[c.IP.String()] {
+			t.Fatalf("duplicate candidate %s", c.IP)
+		}
+		seen[c.IP.String()] = true
+	}
+	if _, err := sampler.Sample(rs, 1); err == nil {
+		t.Fatalf("expected exhausted network to yield no candidates")
+	}
+}
+
+func TestStream(t *testing.T) {
+	sampler := New(nil)
+	sources := []fetcher.SourceRange{
+		{Provider: fetcher.ProviderSpec{Name: "official", Weight: 1}, RangeSet: fetcher.RangeSet{IPv4: []*net.IPNet{mustCIDR(t, "1.1.1.0/24")}}},
+		{Provider: fetcher.ProviderSpec{Name: "mirror", Weight: 0.5}, RangeSet: fetcher.RangeSet{IPv4: []*net.IPNet{mustCIDR(t, "2.2.2.0/24")}}},
+	}
+	stream, err := sampler.Stream(context.Background(), sources, 100)
+	if err != nil {
+		t.Fatalf("Stream error = %v", err)
+	}
+	seen := map[string]bool{}
+	for candidate := range stream {
+		key := candidate.IP.String()
+		if seen[key] {
+			t.Fatalf("duplicate candidate %s", key)
+		}
+		seen[key] = true
+	}
+	if len(seen) != 100 {
+		t.Fatalf("expected 100 candidates, got %d", len(seen))
+	}
+	if _, err := sampler.Stream(context.Background(), nil, 1); err == nil {
+		t.Fatalf("expected error without sources")
+	}
+}
+
+func TestUseHistoryFavoursProductiveNetworks(t *testing.T) {
+	good := mustCIDR(t, "198.51.100.0/24")
+	bad := mustCIDR(t, "203.0.113.0/24")
+	source := fetcher.SourceRange{
+		Provider: fetcher.ProviderSpec{Name: "official", Weight: 1},
+		RangeSet: fetcher.RangeSet{IPv4: []*net.IPNet{good, bad}},
+	}
+	var records []store.Record
+	for i := 0; i < 20; i++ {
+		records = append(records,
+			store.Record{Score: 0.9, Measurement: prober.Measurement{Network: good.String(), Success: true}},
+			store.Record{Score: 0.05, Measurement: prober.Measurement{Network: bad.String()}},
+		)
+	}
+	stats := StatsFromRecords(records)
+	if stats[good.String()].Successes != 20 || stats[bad.String()].Probes != 20 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	s := New(nil)
+	s.UseHistory(stats, 0)
+	candidates, err := s.SampleSources([]fetcher.SourceRange{source}, 120)
+	if err != nil {
+		t.Fatalf("SampleSources error = %v", err)
+	}
+	counts := map[string]int{}
+	for _, candidate := range candidates {
+		counts[candidate.Network.String()]++
+	}
+	if counts[good.String()] <= 2*counts[bad.String()] {
+		t.Fatalf("expected productive network to dominate, got %v", counts)
+	}
+	if counts[bad.String()] == 0 {
+		t.Fatalf("expected exploration to keep sampling the poor network, got %v", counts)
+	}
+}
+
+func TestUseProbeDensityRotatesAwayFromBusyNetworks(t *testing.T) {
+	busy := mustCIDR(t, "198.51.100.0/24")
+	quiet := mustCIDR(t, "203.0.113.0/24")
+	source := fetcher.SourceRange{
+		Provider: fetcher.ProviderSpec{Name: "official", Weight: 1},
+		RangeSet: fetcher.RangeSet{IPv4: []*net.IPNet{busy, quiet}},
+	}
+	now := time.Now()
+	var records []store.Record
+	for i := 0; i < 10; i++ {
+		records = append(records, store.Record{Timestamp: now.Add(-time.Minute), Measurement: prober.Measurement{Network: busy.String()}})
+	}
+	records = append(records, store.Record{Timestamp: now.Add(-48 * time.Hour), Measurement: prober.Measurement{Network: quiet.String()}})
+	counts := RecentProbeCounts(records, now.Add(-time.Hour))
+	if counts[busy.String()] != 10 || counts[quiet.String()] != 0 {
+		t.Fatalf("unexpected recent counts %v", counts)
+	}
+
+	s := New(nil)
+	s.UseProbeDensity(counts, 0)
+	candidates, err := s.SampleSources([]fetcher.SourceRange{source}, 120)
+	if err != nil {
+		t.Fatalf("SampleSources error = %v", err)
+	}
+	drawn := map[string]int{}
+	for _, candidate := range candidates {
+		drawn[candidate.Network.String()]++
+	}
+	if drawn[busy.String()]*3 >= drawn[quiet.String()] {
+		t.Fatalf("expected the recently busy network to be de-emphasised, got %v", drawn)
+	}
+	if drawn[busy.String()] == 0 {
+		t.Fatalf("expected the busy network to stay eligible, got %v", drawn)
+	}
+}
+
+func TestSampleSourcesMaxPerNetwork(t *testing.T) {
+	source := fetcher.SourceRange{
+		Provider: fetcher.ProviderSpec{Name: "official", Weight: 1},
+		RangeSet: fetcher.RangeSet{IPv4: []*net.IPNet{
+			mustCIDR(t, "10.0.0.0/16"),
+			mustCIDR(t, "192.0.2.0/28"),
+			mustCIDR(t, "198.51.100.0/28"),
+			mustCIDR(t, "203.0.113.0/28"),
+		}},
+	}
+	s := New(nil)
+	s.MaxPerNetwork = 5
+	candidates, err := s.SampleSources([]fetcher.SourceRange{source}, 20)
+	if err != nil {
+		t.Fatalf("SampleSources error = %v", err)
+	}
+	if len(candidates) != 20 {
+		t.Fatalf("expected the total to be topped up to 20, got %d", len(candidates))
+	}
+	counts := map[string]int{}
+	for _, candidate := range candidates {
+		counts[candidate.Network.String()]++
+	}
+	for network, count := range counts {
+		if count > 5 {
+			t.Fatalf("network %s exceeded the cap with %d candidates", network, count)
+		}
+	}
+}
+
+func TestSampleSourcesMinSourceCount(t *testing.T) {
+	corroborated := mustCIDR(t, "192.0.2.0/24")
+	single := mustCIDR(t, "198.51.100.0/24")
+	source := fetcher.SourceRange{
+		Provider: fetcher.ProviderSpec{Name: "official", Weight: 1},
+		RangeSet: fetcher.RangeSet{
+			IPv4:         []*net.IPNet{corroborated, single},
+			SourceCounts: map[string]int{corroborated.String(): 2, single.String(): 1},
+		},
+	}
+	s := New(nil)
+	s.MinSourceCount = 2
+	candidates, err := s.SampleSources([]fetcher.SourceRange{source}, 16)
+	if err != nil {
+		t.Fatalf("SampleSources error = %v", err)
+	}
+	for _, candidate := range candidates {
+		if candidate.Network.String() != corroborated.String() {
+			t.Fatalf("single-source network %s should have been excluded", candidate.Network)
+		}
+	}
+
+	s.MinSourceCount = 3
+	if _, err := s.SampleSources([]fetcher.SourceRange{source}, 16); err == nil {
+		t.Fatalf("expected an error when no network meets the source count")
+	}
+}
+
+func TestHistoryTTLExpiresRememberedIPs(t *testing.T) {
+	source := fetcher.SourceRange{
+		Provider: fetcher.ProviderSpec{Name: "official", Weight: 1},
+		RangeSet: fetcher.RangeSet{IPv4: []*net.IPNet{mustCIDR(t, "192.0.2.7/32")}},
+	}
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := New(nil)
+	s.HistoryTTL = time.Hour
+	s.now = func() time.Time { return clock }
+
+	if _, err := s.SampleSources([]fetcher.SourceRange{source}, 1); err != nil {
+		t.Fatalf("first SampleSources error = %v", err)
+	}
+	clock = clock.Add(59 * time.Minute)
+	if _, err := s.SampleSources([]fetcher.SourceRange{source}, 1); err == nil {
+		t.Fatalf("expected the IP to stay remembered within its TTL")
+	}
+	clock = clock.Add(time.Minute)
+	candidates, err := s.SampleSources([]fetcher.SourceRange{source}, 1)
+	if err != nil {
+		t.Fatalf("expected the IP to be eligible after its TTL, got %v", err)
+	}
+	if !candidates[0].IP.Equal(net.ParseIP("192.0.2.7")) {
+		t.Fatalf("unexpected candidate %s", candidates[0].IP)
+	}
+}
+
+func TestUseNeighboursFavoursWinnerSubnets(t *testing.T) {
+	source := fetcher.SourceRange{
+		Provider: fetcher.ProviderSpec{Name: "official", Weight: 1},
+		RangeSet: fetcher.RangeSet{IPv4: []*net.IPNet{mustCIDR(t, "10.0.0.0/16")}},
+	}
+	neighbourhood := mustCIDR(t, "10.0.5.0/28")
+	count := func(s *Sampler) int {
+		candidates, err := s.SampleSources([]fetcher.SourceRange{source}, 32)
+		if err != nil {
+			t.Fatalf("SampleSources error = %v", err)
+		}
+		hits := 0
+		for _, candidate := range candidates {
+			if neighbourhood.Contains(candidate.IP) {
+				hits++
+			}
+			if candidate.Network.String() != "10.0.0.0/16" {
+				t.Fatalf("expected neighbours to report the enclosing network, got %s", candidate.Network)
+			}
+		}
+		return hits
+	}
+
+	if hits := count(New(nil)); hits > 2 {
+		t.Fatalf("expected few neighbourhood hits without winners, got %d", hits)
+	}
+
+	records := []store.Record{
+		{Score: 0.95, Status: "pass", Measurement: prober.Measurement{IP: net.ParseIP("10.0.5.7")}},
+		{Score: 0.2, Status: "fail", Measurement: prober.Measurement{IP: net.ParseIP("10.0.9.9")}},
+	}
+	winners := WinnersFromRecords(records, 0)
+	if len(winners) != 1 || !winners[0].Equal(net.ParseIP("10.0.5.7")) {
+		t.Fatalf("expected only the passing IP as a winner, got %v", winners)
+	}
+	s := New(nil)
+	s.UseNeighbours(winners, 0, 0)
+	if hits := count(s); hits < 8 {
+		t.Fatalf("expected neighbours of the winner to be preferred, got %d of 32", hits)
+	}
+}
+
+func TestUseNeighboursRespectsMaxPerNetwork(t *testing.T) {
+	source := fetcher.SourceRange{
+		Provider: fetcher.ProviderSpec{Name: "official", Weight: 1},
+		RangeSet: fetcher.RangeSet{IPv4: []*net.IPNet{
+			mustCIDR(t, "10.0.0.0/16"),
+			mustCIDR(t, "192.0.2.0/28"),
+			mustCIDR(t, "198.51.100.0/28"),
+			mustCIDR(t, "203.0.113.0/28"),
+		}},
+	}
+	s := New(nil)
+	s.MaxPerNetwork = 5
+	s.UseNeighbours([]net.IP{net.ParseIP("10.0.5.7")}, 0, 0.9)
+	candidates, err := s.SampleSources([]fetcher.SourceRange{source}, 20)
+	if err != nil {
+		t.Fatalf("SampleSources error = %v", err)
+	}
+	counts := map[string]int{}
+	for _, candidate := range candidates {
+		counts[candidate.Network.String()]++
+	}
+	if counts["10.0.0.0/16"] > 5 {
+		t.Fatalf("expected neighbour picks to count against the enclosing network, got %d", counts["10.0.0.0/16"])
+	}
+}
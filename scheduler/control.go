@@ -0,0 +1,101 @@
+package scheduler
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DaemonStatus is a snapshot of the daemon loop state.
+type DaemonStatus struct {
+	Paused    bool      `json:"paused"`
+	LastCycle time.Time `json:"lastCycle"`
+	Skipped   int       `json:"skippedCycles"`
+}
+
+// DaemonControl lets callers pause and resume RunDaemon without stopping it.
+// The zero value is ready to use and safe for concurrent use.
+type DaemonControl struct {
+	mu        sync.Mutex
+	paused    bool
+	lastCycle time.Time
+	skipped   int
+}
+
+// Pause makes RunDaemon skip subsequent cycles until Resume is called.
+func (c *DaemonControl) Pause() {
+	c.mu.Lock()
+	c.paused = true
+	c.mu.Unlock()
+}
+
+// Resume re-enables scanning on the next cycle.
+func (c *DaemonControl) Resume() {
+	c.mu.Lock()
+	c.paused = false
+	c.mu.Unlock()
+}
+
+// Status returns the current pause flag and the time of the last completed scan.
+func (c *DaemonControl) Status() DaemonStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return DaemonStatus{Paused: c.paused, LastCycle: c.lastCycle, Skipped: c.skipped}
+}
+
+// begin reports whether the cycle should run, counting skipped cycles.
+func (c *DaemonControl) begin() bool {
+	if c == nil {
+		return true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.paused {
+		c.skipped++
+		return false
+	}
+	return true
+}
+
+func (c *DaemonControl) finish(at time.Time) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.lastCycle = at
+	c.mu.Unlock()
+}
+
+// timingWarnRatio is the fraction of the interval a scan may use before
+// CheckDaemonTiming warns.
+const timingWarnRatio = 0.8
+
+// DaemonTiming describes how the configured rate limit fits the daemon interval.
+type DaemonTiming struct {
+	// MinScan is the lower bound of one scan: the rate-limit gaps between
+	// count sequential probes, excluding the probes themselves.
+	MinScan time.Duration
+	// EffectiveInterval is the spacing between scans once a scan outlasts the
+	// interval and ticks are dropped.
+	EffectiveInterval time.Duration
+	// Warning is non-empty when MinScan reaches 80% of the interval.
+	Warning string
+}
+
+// CheckDaemonTiming validates the count*rate budget against interval.
+func CheckDaemonTiming(count int, rate, interval time.Duration) DaemonTiming {
+	timing := DaemonTiming{EffectiveInterval: interval}
+	if count > 1 && rate > 0 {
+		timing.MinScan = time.Duration(count-1) * rate
+	}
+	if interval <= 0 || timing.MinScan < time.Duration(float64(interval)*timingWarnRatio) {
+		return timing
+	}
+	if timing.MinScan > interval {
+		timing.EffectiveInterval = timing.MinScan
+		timing.Warning = fmt.Sprintf("each scan takes at least %s (count %d x rate %s), longer than the %s interval; scans will run back to back every ~%s", timing.MinScan, count, rate, interval, timing.EffectiveInterval)
+	} else {
+		timing.Warning = fmt.Sprintf("each scan takes at least %s (count %d x rate %s), close to the %s interval; slow probes will delay the next cycle", timing.MinScan, count, rate, interval)
+	}
+	return timing
+}
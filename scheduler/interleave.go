@@ -0,0 +1,45 @@
+package scheduler
+
+import (
+	"context"
+	"sort"
+
+	"github.com/example/cf-edgescout/sampler"
+)
+
+// interleaveSources drains in and re-emits its candidates round-robin across
+// sources, in source name order, so that every source is probed early in the
+// scan instead of waiting behind another source's candidates. The order
+// within a source is preserved.
+func interleaveSources(ctx context.Context, in <-chan sampler.Candidate) <-chan sampler.Candidate {
+	out := make(chan sampler.Candidate)
+	go func() {
+		defer close(out)
+		groups := map[string][]sampler.Candidate{}
+		for candidate := range in {
+			groups[candidate.Source] = append(groups[candidate.Source], candidate)
+		}
+		names := make([]string, 0, len(groups))
+		for name := range groups {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for pending := len(groups) > 0; pending; {
+			pending = false
+			for _, name := range names {
+				queue := groups[name]
+				if len(queue) == 0 {
+					continue
+				}
+				pending = true
+				select {
+				case out <- queue[0]:
+				case <-ctx.Done():
+					return
+				}
+				groups[name] = queue[1:]
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,59 @@
+package scheduler
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// TokenBucket is a probes-per-second budget that can be shared by several
+// Schedulers so that they collectively stay under one global rate. It is safe
+// for concurrent use.
+type TokenBucket struct {
+	mu       sync.Mutex
+	interval time.Duration
+	burst    float64
+	tokens   float64
+	last     time.Time
+}
+
+// NewTokenBucket allows rate probes per second with bursts of up to burst
+// probes. A burst below 1 is treated as 1.
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	var interval time.Duration
+	if rate > 0 {
+		interval = time.Duration(float64(time.Second) / rate)
+	}
+	return &TokenBucket{interval: interval, burst: float64(burst), tokens: float64(burst)}
+}
+
+// Wait blocks until a token is available or ctx is done. A nil bucket or a
+// non-positive rate never blocks.
+func (b *TokenBucket) Wait(ctx context.Context) error {
+	if b == nil || b.interval <= 0 {
+		return nil
+	}
+	return sleepWithContext(ctx, b.reserve(time.Now()))
+}
+
+// reserve takes a token, possibly from the future, and returns how long the
+// caller must wait before using it.
+func (b *TokenBucket) reserve(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.last.IsZero() {
+		b.tokens += float64(now.Sub(b.last)) / float64(b.interval)
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens * float64(b.interval))
+}
@@ -0,0 +1,106 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	mathrand "math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/example/cf-edgescout/sampler"
+	"github.com/example/cf-edgescout/store"
+)
+
+// ProbeOrder controls the order in which Scan probes the sampled candidates.
+type ProbeOrder string
+
+const (
+	// ProbeOrderSequential probes candidates as the sampler emits them. It is
+	// the default and the only order that does not wait for the whole sample.
+	ProbeOrderSequential ProbeOrder = "sequential"
+	// ProbeOrderRandom shuffles the sample so consecutive probes do not
+	// follow the sampler's network patterns.
+	ProbeOrderRandom ProbeOrder = "random"
+	// ProbeOrderBestFirst probes the candidates with the best history first,
+	// so a scan that is cut short still covers the likely winners.
+	ProbeOrderBestFirst ProbeOrder = "best-first"
+)
+
+// ParseProbeOrder validates a probe order name. An empty name is sequential.
+func ParseProbeOrder(value string) (ProbeOrder, error) {
+	switch order := ProbeOrder(strings.ToLower(strings.TrimSpace(value))); order {
+	case "", ProbeOrderSequential:
+		return ProbeOrderSequential, nil
+	case ProbeOrderRandom, ProbeOrderBestFirst:
+		return order, nil
+	default:
+		return "", fmt.Errorf("unknown probe order %q (want sequential, random or best-first)", value)
+	}
+}
+
+// orderCandidates drains in and re-emits the candidates in the given order.
+// history is only consulted for ProbeOrderBestFirst.
+func orderCandidates(ctx context.Context, in <-chan sampler.Candidate, order ProbeOrder, history []store.Record) <-chan sampler.Candidate {
+	out := make(chan sampler.Candidate)
+	go func() {
+		defer close(out)
+		var candidates []sampler.Candidate
+		for candidate := range in {
+			candidates = append(candidates, candidate)
+		}
+		switch order {
+		case ProbeOrderRandom:
+			rng := mathrand.New(mathrand.NewSource(time.Now().UnixNano()))
+			rng.Shuffle(len(candidates), func(i, j int) {
+				candidates[i], candidates[j] = candidates[j], candidates[i]
+			})
+		case ProbeOrderBestFirst:
+			sortBestFirst(candidates, history)
+		}
+		for _, candidate := range candidates {
+			select {
+			case out <- candidate:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// sortBestFirst orders candidates by their expected score: the latest score
+// recorded for the IP, else the mean score of its network. Candidates without
+// any history keep their sampler order after the ranked ones.
+func sortBestFirst(candidates []sampler.Candidate, history []store.Record) {
+	latest := map[string]store.Record{}
+	for _, record := range history {
+		if record.Measurement.IP == nil {
+			continue
+		}
+		key := record.Measurement.IP.String()
+		if prev, ok := latest[key]; !ok || record.Timestamp.After(prev.Timestamp) {
+			latest[key] = record
+		}
+	}
+	networks := sampler.StatsFromRecords(history)
+	expected := func(candidate sampler.Candidate) (float64, bool) {
+		if record, ok := latest[candidate.IP.String()]; ok {
+			return record.Score, true
+		}
+		if candidate.Network != nil {
+			if stats, ok := networks[candidate.Network.String()]; ok && stats.Probes > 0 {
+				return stats.ScoreSum / float64(stats.Probes), true
+			}
+		}
+		return 0, false
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, aok := expected(candidates[i])
+		b, bok := expected(candidates[j])
+		if aok != bok {
+			return aok
+		}
+		return a > b
+	})
+}
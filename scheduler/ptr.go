@@ -0,0 +1,31 @@
+package scheduler
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// DefaultPTRTimeout bounds each reverse lookup when Scheduler.PTRTimeout is zero.
+const DefaultPTRTimeout = time.Second
+
+// PTRResolver performs reverse DNS lookups. *net.Resolver satisfies it.
+type PTRResolver interface {
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+}
+
+// lookupPTR returns the first PTR name for addr without the trailing dot, or
+// an empty string when the lookup fails or times out.
+func (s *Scheduler) lookupPTR(ctx context.Context, addr string) string {
+	timeout := s.PTRTimeout
+	if timeout <= 0 {
+		timeout = DefaultPTRTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	names, err := s.PTRResolver.LookupAddr(ctx, addr)
+	if err != nil || len(names) == 0 {
+		return ""
+	}
+	return strings.TrimSuffix(names[0], ".")
+}
@@ -0,0 +1,75 @@
+package scheduler
+
+import (
+	"strings"
+	"time"
+
+	"github.com/example/cf-edgescout/prober"
+)
+
+// RetryPolicy decides which unsuccessful probes are retried and how long to
+// wait between attempts.
+type RetryPolicy struct {
+	// Backoff is the delay before the first retry. Zero uses 100ms.
+	Backoff time.Duration
+	// Multiplier grows the delay after each retry; values below 1 keep it fixed.
+	Multiplier float64
+	// MaxBackoff caps the delay. Zero leaves it uncapped.
+	MaxBackoff time.Duration
+	// RetryAll retries every unsuccessful measurement regardless of cause.
+	RetryAll bool
+}
+
+// DefaultRetryPolicy retries transient failures with a doubling backoff
+// starting at 100ms and capped at 2s.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{Backoff: 100 * time.Millisecond, Multiplier: 2, MaxBackoff: 2 * time.Second}
+}
+
+// transientMarkers identify network errors worth retrying within the TLS and
+// HTTP stages, which otherwise fail deterministically (certificates, 4xx).
+var transientMarkers = []string{"connection reset", "broken pipe", "eof", "connection refused", "network is unreachable", "no route to host"}
+
+// ShouldRetry reports whether the measurement failed for a transient reason.
+func (p RetryPolicy) ShouldRetry(m *prober.Measurement) bool {
+	if m == nil || m.Success {
+		return false
+	}
+	if p.RetryAll {
+		return true
+	}
+	switch m.FailureCategory() {
+	case prober.CategoryTimeout, prober.CategoryTCP:
+		return true
+	case prober.CategoryChallenge:
+		return false
+	}
+	lower := strings.ToLower(m.Error)
+	if strings.Contains(lower, "x509") || strings.Contains(lower, "certificate") || strings.HasPrefix(lower, "tls verify") {
+		return false
+	}
+	for _, marker := range transientMarkers {
+		if strings.Contains(lower, marker) {
+			return true
+		}
+	}
+	return m.Error == "" && m.Integrity.HTTPStatus >= 500
+}
+
+// Delay returns the wait before retry number attempt (starting at 0).
+func (p RetryPolicy) Delay(attempt int) time.Duration {
+	delay := p.Backoff
+	if delay <= 0 {
+		delay = 100 * time.Millisecond
+	}
+	for i := 0; i < attempt && p.Multiplier > 1; i++ {
+		delay = time.Duration(float64(delay) * p.Multiplier)
+		if p.MaxBackoff > 0 && delay >= p.MaxBackoff {
+			break
+		}
+	}
+	if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	return delay
+}
@@ -2,8 +2,13 @@ package scheduler
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"log"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/example/cf-edgescout/fetcher"
@@ -18,23 +23,98 @@ type ProbeRunner interface {
 	Probe(ctx context.Context, ip net.IP, domain string) (*prober.Measurement, error)
 }
 
+// RangeProvider supplies the source ranges sampled by a scan. The fetcher's
+// ProviderSource implements it; tests can substitute an in-memory fake.
+type RangeProvider interface {
+	Fetch(ctx context.Context) ([]fetcher.SourceRange, error)
+}
+
+// RangeProviderFunc adapts a function to the RangeProvider interface.
+type RangeProviderFunc func(ctx context.Context) ([]fetcher.SourceRange, error)
+
+// Fetch calls f.
+func (f RangeProviderFunc) Fetch(ctx context.Context) ([]fetcher.SourceRange, error) {
+	return f(ctx)
+}
+
+// neighbourWinners bounds how many past winners seed NeighbourBias.
+const neighbourWinners = 16
+
 // Scheduler coordinates sampling, probing, scoring and persistence.
 type Scheduler struct {
-	Sampler     *sampler.Sampler
-	Prober      ProbeRunner
-	Scorer      *scorer.Scorer
-	Store       store.Store
-	RateLimit   time.Duration
-	Retries     int
+	Sampler   *sampler.Sampler
+	Prober    ProbeRunner
+	Scorer    *scorer.Scorer
+	Store     store.Store
+	RateLimit time.Duration
+	Retries   int
+	// Parallelism is how many candidates Scan probes at once. Values below
+	// 2 probe one candidate at a time. Results are returned in sampler order
+	// either way.
 	Parallelism int
+	// BaselineIP is probed before each scan and used as the scorer's
+	// reference measurement. A failed baseline probe clears the reference.
+	BaselineIP net.IP
+	// HistoryBias weights network selection by the success rate and score of
+	// the records already in Store, refreshed before every scan.
+	HistoryBias bool
+	// NeighbourBias steers part of each scan towards the /28 around the
+	// best passing IPs already in Store, refreshed before every scan.
+	NeighbourBias bool
+	// DensityWindow de-emphasises networks in proportion to how many records
+	// Store holds for them from the last DensityWindow, refreshed before
+	// every scan, so probes rotate across ranges. Zero disables it.
+	DensityWindow time.Duration
+	// RetryPolicy decides which failed probes are retried. Nil uses
+	// DefaultRetryPolicy.
+	RetryPolicy *RetryPolicy
+	// Control optionally pauses RunDaemon between cycles.
+	Control *DaemonControl
+	// PTRResolver enables reverse DNS enrichment of every probed IP. Nil
+	// skips the lookup.
+	PTRResolver PTRResolver
+	// PTRTimeout bounds each reverse lookup. Zero uses DefaultPTRTimeout.
+	PTRTimeout time.Duration
+	// AbortOnError stops Scan at the first probe or save error. By default
+	// failing candidates are skipped and their errors returned alongside the
+	// successful results.
+	AbortOnError bool
+	// Limiter is an optional probe budget shared with other schedulers. It
+	// applies to every probe attempt in addition to RateLimit.
+	Limiter *TokenBucket
+	// InterleaveSources probes the sampled candidates round-robin across
+	// sources so an early failure or rate limit on one source does not delay
+	// the others. The whole sample is drawn before the first probe.
+	InterleaveSources bool
+	// ProbeOrder reorders the sampled candidates before probing. Orders other
+	// than ProbeOrderSequential, the default, draw the whole sample first;
+	// ProbeOrderBestFirst ranks candidates by the records in Store.
+	ProbeOrder ProbeOrder
+	// MaxTotalProbes caps the candidates probed across all RunDaemon cycles,
+	// e.g. to honour a daily quota. Once spent, RunDaemon returns
+	// ErrProbeQuotaReached. Zero means unlimited.
+	MaxTotalProbes int
+	// Logf reports errors RunDaemon recovers from. Nil uses log.Printf.
+	Logf func(format string, args ...any)
+
+	// probesUsed counts the candidates probed by Scan since the scheduler
+	// was created.
+	probesUsed int
 }
 
+// ErrProbeQuotaReached is returned by RunDaemon once MaxTotalProbes
+// candidates have been probed.
+var ErrProbeQuotaReached = errors.New("probe quota reached")
+
 // Result captures the stored record for convenience when returning from scans.
 type Result struct {
 	Record store.Record
 }
 
-// Scan performs a one-off scan returning the stored records.
+// Scan performs a one-off scan returning the stored records, all tagged with a
+// RunID unique to this invocation. Unless
+// AbortOnError is set, per-candidate failures do not stop the scan: the
+// records stored so far are returned together with the joined errors.
 func (s *Scheduler) Scan(ctx context.Context, sources []fetcher.SourceRange, domain string, total int) ([]Result, error) {
 	if s == nil {
 		return nil, errors.New("scheduler is nil")
@@ -45,68 +125,197 @@ func (s *Scheduler) Scan(ctx context.Context, sources []fetcher.SourceRange, dom
 	if total <= 0 {
 		return nil, errors.New("total must be > 0")
 	}
-	candidates, err := s.Sampler.SampleSources(sources, total)
+	order, err := ParseProbeOrder(string(s.ProbeOrder))
 	if err != nil {
 		return nil, err
 	}
-	if len(candidates) == 0 {
-		return nil, nil
-	}
-	results := make([]Result, 0, len(candidates))
-	lastProbe := time.Time{}
-	for _, candidate := range candidates {
-		if s.RateLimit > 0 && !lastProbe.IsZero() {
-			if err := sleepWithContext(ctx, s.RateLimit-time.Since(lastProbe)); err != nil {
-				return nil, err
-			}
+	if s.BaselineIP != nil {
+		if err := s.probeBaseline(ctx, domain); err != nil {
+			return nil, err
 		}
-		measurement, err := s.tryProbe(ctx, candidate, domain)
+	}
+	var history []store.Record
+	if s.HistoryBias || s.NeighbourBias || s.DensityWindow > 0 || order == ProbeOrderBestFirst {
+		history, err = s.Store.List(ctx)
 		if err != nil {
 			return nil, err
 		}
-		s.enrichMeasurement(measurement, candidate)
-		score := s.Scorer.Score(*measurement)
-		record := store.Record{
-			Timestamp:      score.Measurement.Timestamp,
-			Source:         score.Measurement.Source,
-			Score:          score.Score,
-			Grade:          score.Grade,
-			Status:         score.Status,
-			FailureReasons: append([]string(nil), score.Failures...),
-			Components:     score.Components,
-			Measurement:    score.Measurement,
-		}
-		if err := s.Store.Save(ctx, record); err != nil {
+		if s.DensityWindow > 0 {
+			s.Sampler.UseProbeDensity(sampler.RecentProbeCounts(history, time.Now().Add(-s.DensityWindow)), 0)
+		}
+		if s.HistoryBias {
+			s.Sampler.UseHistory(sampler.StatsFromRecords(history), 0)
+		}
+		if s.NeighbourBias {
+			s.Sampler.UseNeighbours(sampler.WinnersFromRecords(history, neighbourWinners), 0, 0)
+		}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	candidates, err := s.Sampler.Stream(ctx, sources, total)
+	if err != nil {
+		return nil, err
+	}
+	if s.InterleaveSources {
+		candidates = interleaveSources(ctx, candidates)
+	}
+	if order != ProbeOrderSequential {
+		candidates = orderCandidates(ctx, candidates, order, history)
+	}
+	runID := newRunID(time.Now())
+	baselineLatency := s.baselineLatency()
+	type job struct {
+		seq       int
+		candidate sampler.Candidate
+	}
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		records = make(map[int]store.Record, total)
+		errs    []error
+		aborted bool
+	)
+	jobs := make(chan job)
+	for i := 0; i < max(1, s.Parallelism); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				record, err := s.probeAndSave(ctx, j.candidate, domain, runID, baselineLatency)
+				mu.Lock()
+				if err != nil {
+					errs = append(errs, err)
+					if s.AbortOnError {
+						aborted = true
+						cancel()
+					}
+				} else {
+					records[j.seq] = record
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	// RateLimit spaces probe starts; with a single worker a probe also
+	// cannot start before the previous one has finished.
+	var lastStart time.Time
+	var stopErr error
+	seq := 0
+dispatch:
+	for candidate := range candidates {
+		if s.RateLimit > 0 && !lastStart.IsZero() {
+			if err := sleepWithContext(ctx, s.RateLimit-time.Since(lastStart)); err != nil {
+				stopErr = err
+				break
+			}
+		}
+		select {
+		case jobs <- job{seq: seq, candidate: candidate}:
+			s.probesUsed++
+			seq++
+			lastStart = time.Now()
+		case <-ctx.Done():
+			stopErr = ctx.Err()
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	// Workers finish out of order; return results in the order candidates
+	// were dispatched, as a sequential scan would.
+	results := make([]Result, 0, len(records))
+	for i := 0; i < seq; i++ {
+		if record, ok := records[i]; ok {
+			results = append(results, Result{Record: record})
+		}
+	}
+	if stopErr != nil && !aborted {
+		errs = append(errs, stopErr)
+	}
+	if len(results) == 0 && len(errs) == 0 {
+		if err := ctx.Err(); err != nil {
 			return nil, err
 		}
-		results = append(results, Result{Record: record})
-		lastProbe = time.Now()
+		return nil, errors.New("no candidates produced")
 	}
-	return results, nil
+	return s.partial(results, errs)
+}
+
+// probeAndSave probes, scores and stores one candidate. It is safe to call
+// from several workers at once.
+func (s *Scheduler) probeAndSave(ctx context.Context, candidate sampler.Candidate, domain, runID string, baselineLatency time.Duration) (store.Record, error) {
+	measurement, err := s.tryProbe(ctx, candidate, domain)
+	if err != nil {
+		return store.Record{}, fmt.Errorf("probe %s: %w", candidate.IP, err)
+	}
+	s.enrichMeasurement(ctx, measurement, candidate)
+	score := s.Scorer.Score(*measurement)
+	record := store.Record{
+		Timestamp:       score.Measurement.Timestamp,
+		RunID:           runID,
+		BaselineLatency: baselineLatency,
+		Source:          score.Measurement.Source,
+		Score:           score.Score,
+		Grade:           score.Grade,
+		Status:          score.Status,
+		FailureReasons:  append([]string(nil), score.Failures...),
+		Components:      score.Components,
+		Measurement:     score.Measurement,
+	}
+	if err := s.Store.Save(ctx, record); err != nil {
+		return store.Record{}, fmt.Errorf("save %s: %w", candidate.IP, err)
+	}
+	return record, nil
+}
+
+// newRunID identifies one Scan invocation: a UTC timestamp plus random bits so
+// concurrent schedulers never collide.
+func newRunID(now time.Time) string {
+	var suffix [4]byte
+	_, _ = rand.Read(suffix[:])
+	return now.UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix[:])
+}
+
+// partial returns the results gathered so far with the joined errors. When
+// AbortOnError is set the results are discarded, matching the historical
+// all-or-nothing behaviour.
+func (s *Scheduler) partial(results []Result, errs []error) ([]Result, error) {
+	err := errors.Join(errs...)
+	if err != nil && (s.AbortOnError || len(results) == 0) {
+		return nil, err
+	}
+	return results, err
 }
 
 func (s *Scheduler) tryProbe(ctx context.Context, candidate sampler.Candidate, domain string) (*prober.Measurement, error) {
 	attempts := s.Retries + 1
+	policy := DefaultRetryPolicy()
+	if s.RetryPolicy != nil {
+		policy = *s.RetryPolicy
+	}
 	targetDomain := domain
 	if candidate.Domain != "" {
 		targetDomain = candidate.Domain
 	}
 	for attempt := 0; attempt < attempts; attempt++ {
+		if err := s.Limiter.Wait(ctx); err != nil {
+			return nil, err
+		}
 		measurement, err := s.Prober.Probe(ctx, candidate.IP, targetDomain)
 		if err != nil {
 			return nil, err
 		}
-		if measurement.Success || attempt == attempts-1 {
+		if attempt == attempts-1 || !policy.ShouldRetry(measurement) {
 			return measurement, nil
 		}
-		if err := sleepWithContext(ctx, 100*time.Millisecond); err != nil {
+		if err := sleepWithContext(ctx, policy.Delay(attempt)); err != nil {
 			return nil, err
 		}
 	}
 	return nil, errors.New("probe attempts exhausted")
 }
 
-func (s *Scheduler) enrichMeasurement(m *prober.Measurement, candidate sampler.Candidate) {
+func (s *Scheduler) enrichMeasurement(ctx context.Context, m *prober.Measurement, candidate sampler.Candidate) {
 	if m == nil {
 		return
 	}
@@ -123,6 +332,9 @@ func (s *Scheduler) enrichMeasurement(m *prober.Measurement, candidate sampler.C
 	m.Family = candidate.Family
 	m.DataSource = candidate.Source
 	m.ApplyValidation(candidate.ExpectedOrigin, candidate.TrustedCNs)
+	if s.PTRResolver != nil && m.IP != nil {
+		m.PTR = s.lookupPTR(ctx, m.IP.String())
+	}
 }
 
 func sleepWithContext(ctx context.Context, d time.Duration) error {
@@ -139,7 +351,49 @@ func sleepWithContext(ctx context.Context, d time.Duration) error {
 	}
 }
 
+// probeBaseline measures BaselineIP and installs it as the scorer baseline.
+func (s *Scheduler) probeBaseline(ctx context.Context, domain string) error {
+	baseline, err := s.tryProbe(ctx, sampler.Candidate{IP: s.BaselineIP}, domain)
+	if err != nil {
+		return err
+	}
+	s.Scorer.Baseline = nil
+	if baseline.Success {
+		s.Scorer.Baseline = baseline
+	}
+	return nil
+}
+
+// baselineLatency returns the latency of the scorer's current baseline, or
+// zero when there is none.
+func (s *Scheduler) baselineLatency() time.Duration {
+	b := s.Scorer.Baseline
+	if b == nil {
+		return 0
+	}
+	return b.TCPDuration + b.TLSDuration + b.HTTPDuration
+}
+
+// ScanFrom fetches ranges from the provider and scans them. When the provider
+// returns some ranges alongside an error, those ranges are still scanned and
+// the fetch error is joined into the returned error; it fails outright only
+// when no ranges came back.
+func (s *Scheduler) ScanFrom(ctx context.Context, ranges RangeProvider, domain string, total int) ([]Result, error) {
+	if ranges == nil {
+		return nil, errors.New("range provider is nil")
+	}
+	sources, fetchErr := ranges.Fetch(ctx)
+	if fetchErr != nil && len(sources) == 0 {
+		return nil, fetchErr
+	}
+	results, err := s.Scan(ctx, sources, domain, total)
+	return results, errors.Join(fetchErr, err)
+}
+
 // RunDaemon continuously fetches ranges and scans at the provided interval.
+// A cycle that stores some records despite per-candidate errors is logged and
+// the daemon carries on; it stops when a cycle produces no records or, with
+// AbortOnError, on the first error.
 func (s *Scheduler) RunDaemon(ctx context.Context, fetch func(context.Context) ([]fetcher.SourceRange, error), domain string, total int, interval time.Duration) error {
 	if fetch == nil {
 		return errors.New("fetch function is nil")
@@ -147,12 +401,27 @@ func (s *Scheduler) RunDaemon(ctx context.Context, fetch func(context.Context) (
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 	for {
-		ranges, err := fetch(ctx)
-		if err == nil {
-			_, err = s.Scan(ctx, ranges, domain, total)
+		count := total
+		if s.MaxTotalProbes > 0 {
+			remaining := s.MaxTotalProbes - s.probesUsed
+			if remaining <= 0 {
+				return fmt.Errorf("%w after %d probes", ErrProbeQuotaReached, s.probesUsed)
+			}
+			count = min(count, remaining)
 		}
-		if err != nil {
-			return err
+		if s.Control.begin() {
+			ranges, err := fetch(ctx)
+			if err != nil {
+				return err
+			}
+			results, err := s.Scan(ctx, ranges, domain, count)
+			if err != nil {
+				if s.AbortOnError || len(results) == 0 {
+					return err
+				}
+				s.logf("scan cycle finished with errors: %v", err)
+			}
+			s.Control.finish(time.Now())
 		}
 		select {
 		case <-ctx.Done():
@@ -161,3 +430,11 @@ func (s *Scheduler) RunDaemon(ctx context.Context, fetch func(context.Context) (
 		}
 	}
 }
+
+func (s *Scheduler) logf(format string, args ...any) {
+	if s.Logf != nil {
+		s.Logf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
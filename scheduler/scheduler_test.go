@@ -2,7 +2,11 @@ package scheduler
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -82,3 +86,630 @@ func TestRunDaemonStopsOnContext(t *testing.T) {
 		t.Fatalf("expected context cancellation error")
 	}
 }
+
+func TestRunDaemonStopsAtProbeQuota(t *testing.T) {
+	_, ipv4, _ := net.ParseCIDR("10.0.0.0/24")
+	fetch := func(ctx context.Context) ([]fetcher.SourceRange, error) {
+		return []fetcher.SourceRange{{Provider: fetcher.ProviderSpec{Name: "official", Weight: 1}, RangeSet: fetcher.RangeSet{IPv4: []*net.IPNet{ipv4}}}}, nil
+	}
+	probe := &stubProber{measurement: prober.Measurement{Success: true}}
+	s := &Scheduler{
+		Sampler:        sampler.New(nil),
+		Prober:         probe,
+		Scorer:         scorer.New(),
+		Store:          store.NewMemory(),
+		MaxTotalProbes: 5,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	err := s.RunDaemon(ctx, fetch, "example.com", 2, time.Millisecond)
+	if !errors.Is(err, ErrProbeQuotaReached) {
+		t.Fatalf("expected the daemon to stop at the quota, got %v", err)
+	}
+	if probe.calls != 5 {
+		t.Fatalf("expected exactly 5 probes across cycles, got %d", probe.calls)
+	}
+	if records, _ := s.Store.List(context.Background()); len(records) != 5 {
+		t.Fatalf("expected 5 stored records, got %d", len(records))
+	}
+}
+
+func TestRunDaemonContinuesAfterPartialFailure(t *testing.T) {
+	_, ipv4, _ := net.ParseCIDR("10.0.0.0/24")
+	fetch := func(ctx context.Context) ([]fetcher.SourceRange, error) {
+		return []fetcher.SourceRange{{Provider: fetcher.ProviderSpec{Name: "official", Weight: 1}, RangeSet: fetcher.RangeSet{IPv4: []*net.IPNet{ipv4}}}}, nil
+	}
+	var logged []string
+	s := &Scheduler{
+		Sampler:        sampler.New(nil),
+		Prober:         &flakyProber{stubProber: stubProber{measurement: prober.Measurement{Success: true}}},
+		Scorer:         scorer.New(),
+		Store:          store.NewMemory(),
+		MaxTotalProbes: 9,
+		Logf: func(format string, args ...any) {
+			logged = append(logged, fmt.Sprintf(format, args...))
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	err := s.RunDaemon(ctx, fetch, "example.com", 4, time.Millisecond)
+	if !errors.Is(err, ErrProbeQuotaReached) {
+		t.Fatalf("expected the daemon to keep running until the quota, got %v", err)
+	}
+	if len(logged) != 1 || !strings.Contains(logged[0], "dial exploded") {
+		t.Fatalf("expected the partial failure to be logged once, got %q", logged)
+	}
+	if records, _ := s.Store.List(context.Background()); len(records) != 8 {
+		t.Fatalf("expected 8 stored records across cycles, got %d", len(records))
+	}
+
+	s.AbortOnError = true
+	s.Prober = &flakyProber{stubProber: stubProber{measurement: prober.Measurement{Success: true}}}
+	s.MaxTotalProbes = 0
+	err = s.RunDaemon(ctx, fetch, "example.com", 4, time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "dial exploded") {
+		t.Fatalf("expected AbortOnError to stop the daemon, got %v", err)
+	}
+}
+
+type recordingProber struct {
+	domains []string
+}
+
+func (p *recordingProber) Probe(ctx context.Context, ip net.IP, domain string) (*prober.Measurement, error) {
+	p.domains = append(p.domains, domain)
+	return &prober.Measurement{IP: ip, Domain: domain, Success: true, Timestamp: time.Now()}, nil
+}
+
+func TestSchedulerScanHonoursSourceDomain(t *testing.T) {
+	_, ipv4, _ := net.ParseCIDR("1.1.1.1/32")
+	source := fetcher.SourceRange{
+		Provider: fetcher.ProviderSpec{Name: "mirror", Weight: 1, Domain: "mirror.example.net"},
+		RangeSet: fetcher.RangeSet{IPv4: []*net.IPNet{ipv4}},
+	}
+	probe := &recordingProber{}
+	s := &Scheduler{Sampler: sampler.New(nil), Prober: probe, Scorer: scorer.New(), Store: store.NewMemory()}
+	results, err := s.Scan(context.Background(), []fetcher.SourceRange{source}, "example.com", 1)
+	if err != nil {
+		t.Fatalf("Scan error = %v", err)
+	}
+	if len(probe.domains) != 1 || probe.domains[0] != "mirror.example.net" {
+		t.Fatalf("expected probe against source domain, got %v", probe.domains)
+	}
+	if got := results[0].Record.Measurement.Domain; got != "mirror.example.net" {
+		t.Fatalf("expected record domain mirror.example.net, got %s", got)
+	}
+}
+
+func TestRunDaemonSkipsCyclesWhilePaused(t *testing.T) {
+	_, ipv4, _ := net.ParseCIDR("1.1.1.1/32")
+	var fetches atomic.Int32
+	fetch := func(ctx context.Context) ([]fetcher.SourceRange, error) {
+		fetches.Add(1)
+		return []fetcher.SourceRange{{Provider: fetcher.ProviderSpec{Name: "official"}, RangeSet: fetcher.RangeSet{IPv4: []*net.IPNet{ipv4}}}}, nil
+	}
+	control := &DaemonControl{}
+	control.Pause()
+	s := &Scheduler{
+		Sampler: sampler.New(nil),
+		Prober:  &stubProber{measurement: prober.Measurement{Success: true}},
+		Scorer:  scorer.New(),
+		Store:   store.NewMemory(),
+		Control: control,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- s.RunDaemon(ctx, fetch, "example.com", 1, 5*time.Millisecond) }()
+
+	waitFor := func(cond func() bool, what string) {
+		deadline := time.Now().Add(2 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	waitFor(func() bool { return control.Status().Skipped >= 2 }, "skipped cycles")
+	if n := fetches.Load(); n != 0 {
+		t.Fatalf("expected no scans while paused, got %d", n)
+	}
+	if !control.Status().LastCycle.IsZero() {
+		t.Fatalf("expected no completed cycle while paused")
+	}
+
+	control.Resume()
+	waitFor(func() bool { return !control.Status().LastCycle.IsZero() }, "resumed cycle")
+	if fetches.Load() == 0 {
+		t.Fatalf("expected scan after resume")
+	}
+	cancel()
+	<-done
+}
+
+type fakeRangeProvider struct {
+	sources []fetcher.SourceRange
+	err     error
+	calls   int
+}
+
+func (f *fakeRangeProvider) Fetch(ctx context.Context) ([]fetcher.SourceRange, error) {
+	f.calls++
+	return f.sources, f.err
+}
+
+func TestScanFromRangeProvider(t *testing.T) {
+	_, network, _ := net.ParseCIDR("198.51.100.0/30")
+	ranges := &fakeRangeProvider{sources: []fetcher.SourceRange{{
+		Provider: fetcher.ProviderSpec{Name: "fake", Kind: fetcher.SourceKindThirdParty, Weight: 1},
+		RangeSet: fetcher.RangeSet{IPv4: []*net.IPNet{network}},
+	}}}
+	s := &Scheduler{
+		Sampler: sampler.New(nil),
+		Prober:  &stubProber{measurement: prober.Measurement{Success: true}},
+		Scorer:  scorer.New(),
+		Store:   store.NewMemory(),
+	}
+	results, err := s.ScanFrom(context.Background(), ranges, "example.com", 3)
+	if err != nil {
+		t.Fatalf("ScanFrom error = %v", err)
+	}
+	if ranges.calls != 1 || len(results) != 3 {
+		t.Fatalf("expected 1 fetch and 3 results, got %d and %d", ranges.calls, len(results))
+	}
+	for _, result := range results {
+		if !network.Contains(result.Record.Measurement.IP) || result.Record.Measurement.Source != "fake" {
+			t.Fatalf("unexpected record %+v", result.Record.Measurement)
+		}
+	}
+
+	ranges.sources, ranges.err = nil, errors.New("offline")
+	if _, err := s.ScanFrom(context.Background(), ranges, "example.com", 1); err == nil {
+		t.Fatalf("expected fetch error to propagate")
+	}
+
+	_, partial, _ := net.ParseCIDR("203.0.113.0/30")
+	ranges.sources = []fetcher.SourceRange{{
+		Provider: fetcher.ProviderSpec{Name: "fake", Kind: fetcher.SourceKindThirdParty, Weight: 1},
+		RangeSet: fetcher.RangeSet{IPv4: []*net.IPNet{partial}},
+	}}
+	results, err = s.ScanFrom(context.Background(), ranges, "example.com", 2)
+	if err == nil || !strings.Contains(err.Error(), "offline") {
+		t.Fatalf("expected the fetch error to be joined into the result, got %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected the fetched ranges to be scanned despite the error, got %d results", len(results))
+	}
+}
+
+type sequenceProber struct {
+	measurement prober.Measurement
+	calls       int
+}
+
+func (p *sequenceProber) Probe(ctx context.Context, ip net.IP, domain string) (*prober.Measurement, error) {
+	p.calls++
+	m := p.measurement
+	m.IP = ip
+	return &m, nil
+}
+
+func TestRetryPolicySkipsDeterministicFailures(t *testing.T) {
+	policy := &RetryPolicy{Backoff: time.Millisecond}
+	candidate := sampler.Candidate{IP: net.ParseIP("1.1.1.1")}
+	cases := []struct {
+		name  string
+		m     prober.Measurement
+		calls int
+	}{
+		{"cert mismatch", prober.Measurement{Error: "tls dial: tls: failed to verify certificate: x509: certificate is valid for a.example, not example.com"}, 1},
+		{"client error", prober.Measurement{Integrity: prober.IntegrityReport{HTTPStatus: 404}}, 1},
+		{"timeout", prober.Measurement{Error: "tcp dial: dial tcp 1.1.1.1:443: i/o timeout"}, 3},
+		{"reset", prober.Measurement{Error: "http: read: connection reset by peer"}, 3},
+		{"server error", prober.Measurement{Integrity: prober.IntegrityReport{HTTPStatus: 503}}, 3},
+	}
+	for _, tc := range cases {
+		probe := &sequenceProber{measurement: tc.m}
+		s := &Scheduler{Prober: probe, Retries: 2, RetryPolicy: policy}
+		if _, err := s.tryProbe(context.Background(), candidate, "example.com"); err != nil {
+			t.Fatalf("%s: tryProbe error = %v", tc.name, err)
+		}
+		if probe.calls != tc.calls {
+			t.Fatalf("%s: expected %d attempts, got %d", tc.name, tc.calls, probe.calls)
+		}
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{Backoff: 100 * time.Millisecond, Multiplier: 2, MaxBackoff: 300 * time.Millisecond}
+	want := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond, 300 * time.Millisecond}
+	for attempt, expected := range want {
+		if got := policy.Delay(attempt); got != expected {
+			t.Fatalf("attempt %d: expected %s got %s", attempt, expected, got)
+		}
+	}
+}
+
+func TestCheckDaemonTiming(t *testing.T) {
+	tooShort := CheckDaemonTiming(1000, 200*time.Millisecond, time.Minute)
+	if tooShort.Warning == "" {
+		t.Fatalf("expected a warning when count*rate exceeds the interval")
+	}
+	if tooShort.MinScan != 999*200*time.Millisecond || tooShort.EffectiveInterval != tooShort.MinScan {
+		t.Fatalf("unexpected timing %+v", tooShort)
+	}
+	if close := CheckDaemonTiming(280, 200*time.Millisecond, time.Minute); close.Warning == "" || close.EffectiveInterval != time.Minute {
+		t.Fatalf("expected a warning without changing the interval, got %+v", close)
+	}
+	if ok := CheckDaemonTiming(32, 200*time.Millisecond, 5*time.Minute); ok.Warning != "" {
+		t.Fatalf("expected no warning, got %q", ok.Warning)
+	}
+}
+
+type baselineProber struct {
+	baseline net.IP
+}
+
+func (p *baselineProber) Probe(ctx context.Context, ip net.IP, domain string) (*prober.Measurement, error) {
+	latency := 40 * time.Millisecond
+	if ip.Equal(p.baseline) {
+		latency = 80 * time.Millisecond
+	}
+	return &prober.Measurement{IP: ip, Domain: domain, Success: true, TCPDuration: latency, Timestamp: time.Now()}, nil
+}
+
+func TestSchedulerScanUsesBaseline(t *testing.T) {
+	_, ipv4, _ := net.ParseCIDR("198.51.100.7/32")
+	baseline := net.ParseIP("1.1.1.1")
+	s := &Scheduler{
+		Sampler:    sampler.New(nil),
+		Prober:     &baselineProber{baseline: baseline},
+		Scorer:     scorer.New(),
+		Store:      store.NewMemory(),
+		BaselineIP: baseline,
+	}
+	source := fetcher.SourceRange{Provider: fetcher.ProviderSpec{Name: "official", Weight: 1}, RangeSet: fetcher.RangeSet{IPv4: []*net.IPNet{ipv4}}}
+	results, err := s.Scan(context.Background(), []fetcher.SourceRange{source}, "example.com", 1)
+	if err != nil {
+		t.Fatalf("Scan error = %v", err)
+	}
+	if got := results[0].Record.Components["relativeLatency"]; got != 2 {
+		t.Fatalf("expected edge twice as fast as the baseline, got %v", got)
+	}
+	if records, _ := s.Store.List(context.Background()); len(records) != 1 {
+		t.Fatalf("expected the baseline probe not to be stored, got %d records", len(records))
+	}
+}
+
+func TestScanRecordsShareRunBaselineLatency(t *testing.T) {
+	_, ipv4, _ := net.ParseCIDR("198.51.100.0/29")
+	baseline := net.ParseIP("1.1.1.1")
+	s := &Scheduler{
+		Sampler:    sampler.New(nil),
+		Prober:     &baselineProber{baseline: baseline},
+		Scorer:     scorer.New(),
+		Store:      store.NewMemory(),
+		BaselineIP: baseline,
+	}
+	source := fetcher.SourceRange{Provider: fetcher.ProviderSpec{Name: "official", Weight: 1}, RangeSet: fetcher.RangeSet{IPv4: []*net.IPNet{ipv4}}}
+	results, err := s.Scan(context.Background(), []fetcher.SourceRange{source}, "example.com", 3)
+	if err != nil {
+		t.Fatalf("Scan error = %v", err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(results))
+	}
+	for _, result := range results {
+		if result.Record.BaselineLatency != 80*time.Millisecond {
+			t.Fatalf("expected every record to carry the run baseline, got %s", result.Record.BaselineLatency)
+		}
+	}
+
+	s.BaselineIP = nil
+	s.Scorer.Baseline = nil
+	results, err = s.Scan(context.Background(), []fetcher.SourceRange{source}, "example.com", 1)
+	if err != nil {
+		t.Fatalf("Scan error = %v", err)
+	}
+	if results[0].Record.BaselineLatency != 0 {
+		t.Fatalf("expected no baseline without a reference probe, got %s", results[0].Record.BaselineLatency)
+	}
+}
+
+func TestSharedTokenBucketBoundsCombinedRate(t *testing.T) {
+	_, network, _ := net.ParseCIDR("10.0.0.0/24")
+	sources := []fetcher.SourceRange{{Provider: fetcher.ProviderSpec{Name: "official", Weight: 1}, RangeSet: fetcher.RangeSet{IPv4: []*net.IPNet{network}}}}
+	const rate, burst, perScheduler = 100.0, 1, 10
+	limiter := NewTokenBucket(rate, burst)
+	newScheduler := func() *Scheduler {
+		return &Scheduler{
+			Sampler: sampler.New(nil),
+			Prober:  &stubProber{measurement: prober.Measurement{Success: true}},
+			Scorer:  scorer.New(),
+			Store:   store.NewMemory(),
+			Limiter: limiter,
+		}
+	}
+	schedulers := []*Scheduler{newScheduler(), newScheduler()}
+	start := time.Now()
+	errs := make(chan error, len(schedulers))
+	for _, s := range schedulers {
+		go func(s *Scheduler) {
+			_, err := s.Scan(context.Background(), sources, "example.com", perScheduler)
+			errs <- err
+		}(s)
+	}
+	for range schedulers {
+		if err := <-errs; err != nil {
+			t.Fatalf("Scan error = %v", err)
+		}
+	}
+	elapsed := time.Since(start)
+	probes := 0
+	for _, s := range schedulers {
+		probes += s.Prober.(*stubProber).calls
+	}
+	minimum := time.Duration(float64(probes-burst) / rate * float64(time.Second))
+	if elapsed < minimum {
+		t.Fatalf("%d probes finished in %s, faster than the %v/s budget allows (%s)", probes, elapsed, rate, minimum)
+	}
+}
+
+// flakyProber fails its second probe and succeeds otherwise.
+type flakyProber struct {
+	stubProber
+}
+
+func (p *flakyProber) Probe(ctx context.Context, ip net.IP, domain string) (*prober.Measurement, error) {
+	if p.calls == 1 {
+		p.calls++
+		return nil, errors.New("dial exploded")
+	}
+	return p.stubProber.Probe(ctx, ip, domain)
+}
+
+func TestSchedulerScanReturnsPartialResults(t *testing.T) {
+	_, network, _ := net.ParseCIDR("10.0.0.0/29")
+	sources := []fetcher.SourceRange{{Provider: fetcher.ProviderSpec{Name: "official", Weight: 1}, RangeSet: fetcher.RangeSet{IPv4: []*net.IPNet{network}}}}
+	newScheduler := func(abort bool) *Scheduler {
+		p := &flakyProber{stubProber: stubProber{measurement: prober.Measurement{Success: true}}}
+		return &Scheduler{Sampler: sampler.New(nil), Prober: p, Scorer: scorer.New(), Store: store.NewMemory(), AbortOnError: abort}
+	}
+
+	s := newScheduler(false)
+	results, err := s.Scan(context.Background(), sources, "example.com", 4)
+	if err == nil || !strings.Contains(err.Error(), "dial exploded") {
+		t.Fatalf("expected the probe failure to be reported, got %v", err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("expected 3 surviving results, got %d", len(results))
+	}
+	records, _ := s.Store.List(context.Background())
+	if len(records) != 3 {
+		t.Fatalf("expected 3 stored records, got %d", len(records))
+	}
+
+	s = newScheduler(true)
+	results, err = s.Scan(context.Background(), sources, "example.com", 4)
+	if err == nil || results != nil {
+		t.Fatalf("expected AbortOnError to stop the scan, got %d results and %v", len(results), err)
+	}
+	if calls := s.Prober.(*flakyProber).calls; calls != 2 {
+		t.Fatalf("expected the scan to stop after the failing probe, got %d calls", calls)
+	}
+}
+
+type fakeResolver struct {
+	names map[string][]string
+}
+
+func (r fakeResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	if names, ok := r.names[addr]; ok {
+		return names, nil
+	}
+	return nil, errors.New("no such host")
+}
+
+func TestSchedulerRecordsPTR(t *testing.T) {
+	_, ipv4, _ := net.ParseCIDR("104.16.1.1/32")
+	sources := []fetcher.SourceRange{{Provider: fetcher.ProviderSpec{Name: "official", Weight: 1}, RangeSet: fetcher.RangeSet{IPv4: []*net.IPNet{ipv4}}}}
+	s := &Scheduler{
+		Sampler:     sampler.New(nil),
+		Prober:      &stubProber{measurement: prober.Measurement{Success: true}},
+		Scorer:      scorer.New(),
+		Store:       store.NewMemory(),
+		PTRResolver: fakeResolver{names: map[string][]string{"104.16.1.1": {"edge-104-16-1-1.cloudflare.example."}}},
+	}
+	results, err := s.Scan(context.Background(), sources, "example.com", 1)
+	if err != nil {
+		t.Fatalf("Scan error = %v", err)
+	}
+	if ptr := results[0].Record.Measurement.PTR; ptr != "edge-104-16-1-1.cloudflare.example" {
+		t.Fatalf("expected the PTR to be recorded without the trailing dot, got %q", ptr)
+	}
+
+	s.Sampler = sampler.New(nil)
+	s.PTRResolver = fakeResolver{}
+	results, err = s.Scan(context.Background(), sources, "example.com", 1)
+	if err != nil {
+		t.Fatalf("Scan error = %v", err)
+	}
+	if ptr := results[0].Record.Measurement.PTR; ptr != "" {
+		t.Fatalf("expected a failed lookup to leave PTR empty, got %q", ptr)
+	}
+}
+
+func TestSchedulerTagsRecordsWithRunID(t *testing.T) {
+	_, network, _ := net.ParseCIDR("10.0.0.0/24")
+	sources := []fetcher.SourceRange{{Provider: fetcher.ProviderSpec{Name: "official", Weight: 1}, RangeSet: fetcher.RangeSet{IPv4: []*net.IPNet{network}}}}
+	s := &Scheduler{
+		Sampler: sampler.New(nil),
+		Prober:  &stubProber{measurement: prober.Measurement{Success: true}},
+		Scorer:  scorer.New(),
+		Store:   store.NewMemory(),
+	}
+	runs := make([]string, 0, 2)
+	for i := 0; i < 2; i++ {
+		results, err := s.Scan(context.Background(), sources, "example.com", 3)
+		if err != nil {
+			t.Fatalf("Scan error = %v", err)
+		}
+		runID := results[0].Record.RunID
+		if runID == "" {
+			t.Fatalf("expected records to carry a run ID")
+		}
+		for _, result := range results {
+			if result.Record.RunID != runID {
+				t.Fatalf("expected one run ID per scan, got %q and %q", runID, result.Record.RunID)
+			}
+		}
+		runs = append(runs, runID)
+	}
+	if runs[0] == runs[1] {
+		t.Fatalf("expected distinct run IDs per scan, got %q twice", runs[0])
+	}
+}
+
+func TestSchedulerInterleavesSources(t *testing.T) {
+	_, bulk, _ := net.ParseCIDR("10.0.0.0/24")
+	_, b, _ := net.ParseCIDR("192.0.2.1/32")
+	_, c, _ := net.ParseCIDR("198.51.100.1/32")
+	sources := []fetcher.SourceRange{
+		{Provider: fetcher.ProviderSpec{Name: "a", Weight: 1}, RangeSet: fetcher.RangeSet{IPv4: []*net.IPNet{bulk}}},
+		{Provider: fetcher.ProviderSpec{Name: "b", Weight: 1000}, RangeSet: fetcher.RangeSet{IPv4: []*net.IPNet{b}}},
+		{Provider: fetcher.ProviderSpec{Name: "c", Weight: 1000}, RangeSet: fetcher.RangeSet{IPv4: []*net.IPNet{c}}},
+	}
+	s := &Scheduler{
+		Sampler:           sampler.New(nil),
+		Prober:            &stubProber{measurement: prober.Measurement{Success: true}},
+		Scorer:            scorer.New(),
+		Store:             store.NewMemory(),
+		InterleaveSources: true,
+	}
+	results, err := s.Scan(context.Background(), sources, "example.com", 6)
+	if err != nil {
+		t.Fatalf("Scan error = %v", err)
+	}
+	order := make([]string, 0, len(results))
+	for _, result := range results {
+		order = append(order, result.Record.Source)
+	}
+	want := []string{"a", "b", "c", "a", "a", "a"}
+	if strings.Join(order, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected probe order %v, got %v", want, order)
+	}
+}
+
+func TestSchedulerProbesBestFirst(t *testing.T) {
+	_, bulk, _ := net.ParseCIDR("10.0.0.0/29")
+	_, poor, _ := net.ParseCIDR("198.51.100.1/32")
+	_, good, _ := net.ParseCIDR("192.0.2.1/32")
+	sources := []fetcher.SourceRange{
+		{Provider: fetcher.ProviderSpec{Name: "bulk", Weight: 1000}, RangeSet: fetcher.RangeSet{IPv4: []*net.IPNet{bulk}}},
+		{Provider: fetcher.ProviderSpec{Name: "poor", Weight: 1000}, RangeSet: fetcher.RangeSet{IPv4: []*net.IPNet{poor}}},
+		{Provider: fetcher.ProviderSpec{Name: "good", Weight: 1}, RangeSet: fetcher.RangeSet{IPv4: []*net.IPNet{good}}},
+	}
+	st := store.NewMemory()
+	history := []store.Record{
+		{Timestamp: time.Now().Add(-time.Hour), Score: 0.95, Measurement: prober.Measurement{IP: net.ParseIP("192.0.2.1"), Network: good.String()}},
+		{Timestamp: time.Now().Add(-time.Hour), Score: 0.2, Measurement: prober.Measurement{IP: net.ParseIP("198.51.100.1"), Network: poor.String()}},
+	}
+	for _, record := range history {
+		if err := st.Save(context.Background(), record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := &Scheduler{
+		Sampler:    sampler.New(nil),
+		Prober:     &stubProber{measurement: prober.Measurement{Success: true}},
+		Scorer:     scorer.New(),
+		Store:      st,
+		ProbeOrder: ProbeOrderBestFirst,
+	}
+	results, err := s.Scan(context.Background(), sources, "example.com", 10)
+	if err != nil {
+		t.Fatalf("Scan error = %v", err)
+	}
+	if len(results) != 10 {
+		t.Fatalf("expected 10 results, got %d", len(results))
+	}
+	if first := results[0].Record.Measurement.IP.String(); first != "192.0.2.1" {
+		t.Fatalf("expected the historically best IP first, got %s", first)
+	}
+	if second := results[1].Record.Measurement.IP.String(); second != "198.51.100.1" {
+		t.Fatalf("expected ranked candidates before unranked ones, got %s second", second)
+	}
+
+	s.ProbeOrder = "fastest"
+	if _, err := s.Scan(context.Background(), sources, "example.com", 1); err == nil {
+		t.Fatal("expected an unknown probe order to be rejected")
+	}
+}
+
+type concurrencyProber struct {
+	inFlight atomic.Int32
+	peak     atomic.Int32
+}
+
+func (p *concurrencyProber) Probe(ctx context.Context, ip net.IP, domain string) (*prober.Measurement, error) {
+	n := p.inFlight.Add(1)
+	defer p.inFlight.Add(-1)
+	for {
+		peak := p.peak.Load()
+		if n <= peak || p.peak.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	return &prober.Measurement{IP: ip, Domain: domain, Success: true, Timestamp: time.Now()}, nil
+}
+
+func TestSchedulerScanProbesInParallel(t *testing.T) {
+	_, network, _ := net.ParseCIDR("10.0.0.0/24")
+	sources := []fetcher.SourceRange{{Provider: fetcher.ProviderSpec{Name: "official", Weight: 1}, RangeSet: fetcher.RangeSet{IPv4: []*net.IPNet{network}}}}
+	run := func(parallelism int) (int32, int) {
+		p := &concurrencyProber{}
+		s := &Scheduler{Sampler: sampler.New(nil), Prober: p, Scorer: scorer.New(), Store: store.NewMemory(), Parallelism: parallelism}
+		results, err := s.Scan(context.Background(), sources, "example.com", 8)
+		if err != nil {
+			t.Fatalf("Scan error = %v", err)
+		}
+		return p.peak.Load(), len(results)
+	}
+	if peak, n := run(1); peak != 1 || n != 8 {
+		t.Fatalf("expected sequential probing with Parallelism 1, got peak %d and %d results", peak, n)
+	}
+	if peak, n := run(4); peak < 2 || peak > 4 || n != 8 {
+		t.Fatalf("expected up to 4 concurrent probes, got peak %d and %d results", peak, n)
+	}
+}
+
+// slowFirstProber delays probes of lower final octets longer, so parallel
+// probes finish in the reverse of their dispatch order.
+type slowFirstProber struct{}
+
+func (slowFirstProber) Probe(ctx context.Context, ip net.IP, domain string) (*prober.Measurement, error) {
+	time.Sleep(time.Duration(5-int(ip.To4()[3])) * 15 * time.Millisecond)
+	return &prober.Measurement{IP: ip, Domain: domain, Success: true, Timestamp: time.Now()}, nil
+}
+
+func TestSchedulerParallelScanKeepsSamplerOrder(t *testing.T) {
+	var sources []fetcher.SourceRange
+	for i, name := range []string{"a", "b", "c", "d"} {
+		_, network, _ := net.ParseCIDR(fmt.Sprintf("10.0.0.%d/32", i+1))
+		sources = append(sources, fetcher.SourceRange{Provider: fetcher.ProviderSpec{Name: name, Weight: 1}, RangeSet: fetcher.RangeSet{IPv4: []*net.IPNet{network}}})
+	}
+	s := &Scheduler{Sampler: sampler.New(nil), Prober: slowFirstProber{}, Scorer: scorer.New(), Store: store.NewMemory(), InterleaveSources: true, Parallelism: 4}
+	results, err := s.Scan(context.Background(), sources, "example.com", 4)
+	if err != nil {
+		t.Fatalf("Scan error = %v", err)
+	}
+	var got []string
+	for _, result := range results {
+		got = append(got, result.Record.Measurement.IP.String())
+	}
+	if strings.Join(got, ",") != "10.0.0.1,10.0.0.2,10.0.0.3,10.0.0.4" {
+		t.Fatalf("expected results in dispatch order, got %v", got)
+	}
+}
@@ -0,0 +1,73 @@
+package scorer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// MarshalConfig renders c as indented JSON suitable for ParseConfig.
+// Durations are encoded in nanoseconds.
+func MarshalConfig(c Config) ([]byte, error) {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// ParseConfig decodes a JSON scorer configuration on top of the defaults
+// returned by New and validates the result. Fields missing from data keep their
+// default values, maps present in data replace the defaults wholesale and
+// unknown fields are rejected. Empty input yields the defaults.
+func ParseConfig(data []byte) (Config, error) {
+	defaults := New().Config
+	if len(bytes.TrimSpace(data)) == 0 {
+		return defaults, nil
+	}
+	// Decoding into a non-nil map merges keys, so start the maps empty and
+	// fall back to the defaults only for maps data leaves out.
+	cfg := defaults
+	cfg.SourcePreference, cfg.FamilyPreference, cfg.GradeBoundaries = nil, nil, nil
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return Config{}, fmt.Errorf("decode scorer config: %w", err)
+	}
+	if cfg.SourcePreference == nil {
+		cfg.SourcePreference = defaults.SourcePreference
+	}
+	if cfg.FamilyPreference == nil {
+		cfg.FamilyPreference = defaults.FamilyPreference
+	}
+	if cfg.GradeBoundaries == nil {
+		cfg.GradeBoundaries = defaults.GradeBoundaries
+	}
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// LoadConfig reads and parses the scorer configuration stored at path.
+func LoadConfig(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	cfg, err := ParseConfig(data)
+	if err != nil {
+		return Config{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// SaveConfig writes c to path as JSON.
+func SaveConfig(path string, c Config) error {
+	data, err := MarshalConfig(c)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
@@ -1,24 +1,126 @@
 package scorer
 
 import (
+	"crypto/tls"
+	"fmt"
 	"math"
 	"sort"
 	"strings"
 	"time"
 
+	"github.com/example/cf-edgescout/geo"
 	"github.com/example/cf-edgescout/prober"
 )
 
 // Config defines weights applied to individual metrics when computing the composite score.
 type Config struct {
-	LatencyWeight    float64
-	SuccessWeight    float64
-	ThroughputWeight float64
-	IntegrityWeight  float64
-	SourcePreference map[string]float64
-	GradeBoundaries  map[string]float64
+	LatencyWeight    float64            `json:"latencyWeight"`
+	SuccessWeight    float64            `json:"successWeight"`
+	ThroughputWeight float64            `json:"throughputWeight"`
+	IntegrityWeight  float64            `json:"integrityWeight"`
+	SourcePreference map[string]float64 `json:"sourcePreference"`
+	// FamilyPreference multiplies the score by address family ("ipv4" or
+	// "ipv6"). Missing entries are neutral.
+	FamilyPreference map[string]float64 `json:"familyPreference"`
+	GradeBoundaries  map[string]float64 `json:"gradeBoundaries"`
+	// PassThreshold is the minimum score for a "pass" status, independent of
+	// the grade boundaries. Must lie within [0, 1]; New uses 0.6.
+	PassThreshold float64 `json:"passThreshold"`
+	// MinTLSVersion (e.g. tls.VersionTLS12) rejects edges negotiating an older
+	// protocol. Zero disables the check.
+	MinTLSVersion uint16 `json:"minTlsVersion"`
+	// MinThroughput (bits/sec) fails successful edges whose measured
+	// throughput is below the floor. Zero disables the gate.
+	MinThroughput float64 `json:"minThroughput"`
+	// RequireWebSocket fails successful edges whose WebSocket Upgrade
+	// handshake did not succeed. It needs the prober's WebSocketPath set.
+	RequireWebSocket bool `json:"requireWebSocket"`
+	// RedirectPenalty treats 3xx responses as a soft failure: the score of a
+	// redirecting edge is multiplied by 1-RedirectPenalty and a "redirect"
+	// component is reported. Only meaningful when the prober does not follow
+	// redirects (Prober.CaptureRedirects). Must lie within [0, 1]; zero
+	// scores 3xx like 2xx.
+	RedirectPenalty float64 `json:"redirectPenalty"`
+	// ColoWeight adds a "colo" component rewarding edges whose CF-Ray colo is
+	// present and known to the geo catalog. Zero keeps it disabled.
+	ColoWeight float64 `json:"coloWeight"`
+	// ReliabilityWeight adds a "reliability" component equal to the fraction
+	// of the prober's extra TCP connects that succeeded. It only applies to
+	// measurements that ran reliability connects (Prober.ReliabilityProbes,
+	// set by the CLI's -reliability-probes). Zero keeps it disabled.
+	ReliabilityWeight float64 `json:"reliabilityWeight"`
+	// OriginWeight adds an "origin" component that is 0 when the edge served
+	// a different origin than expected and 1 otherwise, penalising misrouted
+	// edges on top of the integrity component. Zero keeps it disabled.
+	OriginWeight float64 `json:"originWeight"`
+	// RelativeWeight folds the "relative" component into the score when a
+	// Baseline is set. Zero reports the component without weighting it.
+	RelativeWeight float64 `json:"relativeWeight"`
+	// LatencyCurve selects how total latency maps onto the latency component.
+	// The zero value is the linear curve reaching 0 at 500ms.
+	LatencyCurve LatencyCurve `json:"latencyCurve"`
 }
 
+// Latency curve shapes accepted by LatencyCurve.Shape.
+const (
+	CurveLinear      = "linear"
+	CurveExponential = "exponential"
+	CurveStep        = "step"
+)
+
+// defaultLatencyCeiling is where the linear curve reaches zero.
+const defaultLatencyCeiling = 500 * time.Millisecond
+
+// defaultLatencyHalfLife is the exponential curve's decay half-life.
+const defaultLatencyHalfLife = 100 * time.Millisecond
+
+// LatencyCurve parameterises latency normalisation.
+//
+//   - linear: 1 - d/Max, clamped to [0, 1].
+//   - exponential: 1 up to Knee, then halves every HalfLife beyond it.
+//   - step: the Score of the first step whose Below exceeds d, else 0.
+type LatencyCurve struct {
+	Shape    string        `json:"shape"`
+	Max      time.Duration `json:"max"`
+	Knee     time.Duration `json:"knee"`
+	HalfLife time.Duration `json:"halfLife"`
+	Steps    []LatencyStep `json:"steps"`
+}
+
+// LatencyStep scores latencies below the Below threshold. Steps are evaluated
+// in ascending Below order.
+type LatencyStep struct {
+	Below time.Duration `json:"below"`
+	Score float64       `json:"score"`
+}
+
+// DefaultPassThreshold is the PassThreshold used by New.
+const DefaultPassThreshold = 0.6
+
+// Validate reports configuration values outside their allowed range.
+func (c Config) Validate() error {
+	if c.PassThreshold < 0 || c.PassThreshold > 1 || math.IsNaN(c.PassThreshold) {
+		return fmt.Errorf("pass threshold %v outside [0, 1]", c.PassThreshold)
+	}
+	if c.RedirectPenalty < 0 || c.RedirectPenalty > 1 || math.IsNaN(c.RedirectPenalty) {
+		return fmt.Errorf("redirect penalty %v outside [0, 1]", c.RedirectPenalty)
+	}
+	return nil
+}
+
+// FailureTLSVersion is reported when the negotiated TLS version is below MinTLSVersion.
+const FailureTLSVersion = "tls_version_below_minimum"
+
+// FailureThroughputFloor is reported when throughput is below MinThroughput.
+const FailureThroughputFloor = "throughput_below_floor"
+
+// FailureWebSocket is reported when RequireWebSocket is set and the edge did
+// not complete the WebSocket Upgrade handshake.
+const FailureWebSocket = "websocket_upgrade_failed"
+
+// tlsVersionScoreCap bounds the score of measurements failing the TLS version check.
+const tlsVersionScoreCap = 0.5
+
 // Result contains the final score and the intermediate metric contributions.
 type Result struct {
 	Score       float64
@@ -32,6 +134,10 @@ type Result struct {
 // Scorer normalises measurements and computes a composite score.
 type Scorer struct {
 	Config Config
+	// Baseline is a reference measurement (e.g. a known-good IP probed in the
+	// same run). When set, each result gains relativeLatency, relativeThroughput
+	// and relative components where values above 1 beat the baseline.
+	Baseline *prober.Measurement
 }
 
 // New returns a Scorer with sensible default weights.
@@ -43,13 +149,14 @@ func New() *Scorer {
 		IntegrityWeight:  0.2,
 		SourcePreference: map[string]float64{"official": 1.05},
 		GradeBoundaries:  map[string]float64{"A": 0.85, "B": 0.7, "C": 0.5, "D": 0},
+		PassThreshold:    DefaultPassThreshold,
 	}}
 }
 
 // Score computes the final score for the measurement.
 func (s *Scorer) Score(m prober.Measurement) Result {
 	components := map[string]float64{}
-	latencyNorm := normaliseLatency(m.TCPDuration + m.TLSDuration + m.HTTPDuration)
+	latencyNorm := s.Config.LatencyCurve.normalise(m.TCPDuration + m.TLSDuration + m.HTTPDuration)
 	components["latency"] = latencyNorm
 
 	successNorm := 0.0
@@ -67,14 +174,44 @@ func (s *Scorer) Score(m prober.Measurement) Result {
 	components["integrity"] = integrityNorm
 
 	totalWeight := s.Config.LatencyWeight + s.Config.SuccessWeight + s.Config.ThroughputWeight + s.Config.IntegrityWeight
+	weighted := latencyNorm*s.Config.LatencyWeight + successNorm*s.Config.SuccessWeight + throughputNorm*s.Config.ThroughputWeight + integrityNorm*s.Config.IntegrityWeight
+	if s.Config.ColoWeight > 0 {
+		coloNorm := normaliseColo(m.CFColo)
+		components["colo"] = coloNorm
+		totalWeight += s.Config.ColoWeight
+		weighted += coloNorm * s.Config.ColoWeight
+	}
+	if s.Config.ReliabilityWeight > 0 && m.ReliabilityAttempts > 0 {
+		components["reliability"] = m.Reliability
+		totalWeight += s.Config.ReliabilityWeight
+		weighted += m.Reliability * s.Config.ReliabilityWeight
+	}
+	if s.Config.OriginWeight > 0 {
+		originNorm := normaliseOrigin(m.Validation)
+		components["origin"] = originNorm
+		totalWeight += s.Config.OriginWeight
+		weighted += originNorm * s.Config.OriginWeight
+	}
+	if relative, ok := s.relativeComponents(m, components); ok && s.Config.RelativeWeight > 0 {
+		totalWeight += s.Config.RelativeWeight
+		weighted += math.Min(relative/2, 1) * s.Config.RelativeWeight
+	}
 	if totalWeight == 0 {
 		totalWeight = 1
 	}
-	score := (latencyNorm*s.Config.LatencyWeight + successNorm*s.Config.SuccessWeight + throughputNorm*s.Config.ThroughputWeight + integrityNorm*s.Config.IntegrityWeight) / totalWeight
+	score := weighted / totalWeight
 
 	boost := s.sourceBoost(m)
 	components["sourcePreference"] = boost
 	score *= boost
+	if family, ok := s.familyBoost(m); ok {
+		components["familyPreference"] = family
+		score *= family
+	}
+	if penalty := s.Config.RedirectPenalty; penalty > 0 && isRedirect(m.Integrity.HTTPStatus) {
+		components["redirect"] = 1 - penalty
+		score *= 1 - penalty
+	}
 	if m.SourceWeight > 0 {
 		components["sourceWeight"] = m.SourceWeight
 		score *= m.SourceWeight
@@ -86,10 +223,25 @@ func (s *Scorer) Score(m prober.Measurement) Result {
 		score = 0
 	}
 
+	failures := append([]string(nil), m.Validation.Failures...)
+	if s.belowMinTLS(m) {
+		failures = append(failures, FailureTLSVersion)
+		if score > tlsVersionScoreCap {
+			score = tlsVersionScoreCap
+		}
+	}
+
+	if s.Config.MinThroughput > 0 && m.Success && m.Throughput < s.Config.MinThroughput {
+		failures = append(failures, FailureThroughputFloor)
+	}
+
+	if s.Config.RequireWebSocket && m.Success && !m.WSUpgradeOK {
+		failures = append(failures, FailureWebSocket)
+	}
+
 	grade := determineGrade(score, s.Config.GradeBoundaries)
 	status := "fail"
-	failures := append([]string(nil), m.Validation.Failures...)
-	if score >= 0.6 && len(failures) == 0 {
+	if score >= s.Config.PassThreshold && len(failures) == 0 {
 		status = "pass"
 	} else if len(failures) == 0 && integrityNorm < 0.75 {
 		failures = append(failures, "integrity_degraded")
@@ -98,6 +250,50 @@ func (s *Scorer) Score(m prober.Measurement) Result {
 	return Result{Score: score, Grade: grade, Status: status, Failures: failures, Components: components, Measurement: m}
 }
 
+// Rescore scores previously stored measurements with cfg, for what-if
+// analysis of alternative weights. Nothing is persisted and the results keep
+// the input order.
+func Rescore(cfg Config, measurements []prober.Measurement) []Result {
+	s := &Scorer{Config: cfg}
+	results := make([]Result, 0, len(measurements))
+	for _, m := range measurements {
+		results = append(results, s.Score(m))
+	}
+	return results
+}
+
+// relativeComponents compares m with the baseline. Ratios are oriented so that
+// values above 1 are better than the baseline; "relative" averages the
+// available ratios and is capped at 2.
+func (s *Scorer) relativeComponents(m prober.Measurement, components map[string]float64) (float64, bool) {
+	if s.Baseline == nil || !m.Success {
+		return 0, false
+	}
+	var ratios []float64
+	edgeLatency := m.TCPDuration + m.TLSDuration + m.HTTPDuration
+	baseLatency := s.Baseline.TCPDuration + s.Baseline.TLSDuration + s.Baseline.HTTPDuration
+	if edgeLatency > 0 && baseLatency > 0 {
+		ratio := float64(baseLatency) / float64(edgeLatency)
+		components["relativeLatency"] = ratio
+		ratios = append(ratios, ratio)
+	}
+	if m.Throughput > 0 && s.Baseline.Throughput > 0 {
+		ratio := m.Throughput / s.Baseline.Throughput
+		components["relativeThroughput"] = ratio
+		ratios = append(ratios, ratio)
+	}
+	if len(ratios) == 0 {
+		return 0, false
+	}
+	var sum float64
+	for _, ratio := range ratios {
+		sum += ratio
+	}
+	relative := math.Min(sum/float64(len(ratios)), 2)
+	components["relative"] = relative
+	return relative, true
+}
+
 func (s *Scorer) sourceBoost(m prober.Measurement) float64 {
 	boost := 1.0
 	candidates := []string{m.Source, m.Provider}
@@ -113,21 +309,116 @@ func (s *Scorer) sourceBoost(m prober.Measurement) float64 {
 	return boost
 }
 
-func normaliseLatency(d time.Duration) float64 {
+func (s *Scorer) familyBoost(m prober.Measurement) (float64, bool) {
+	family := strings.ToLower(m.Family)
+	if family == "" {
+		if m.IP == nil {
+			return 1, false
+		}
+		family = "ipv6"
+		if m.IP.To4() != nil {
+			family = "ipv4"
+		}
+	}
+	weight, ok := s.Config.FamilyPreference[family]
+	return weight, ok
+}
+
+func (s *Scorer) belowMinTLS(m prober.Measurement) bool {
+	if s.Config.MinTLSVersion == 0 || m.TLSVersion == "" {
+		return false
+	}
+	return parseTLSVersion(m.TLSVersion) < s.Config.MinTLSVersion
+}
+
+// parseTLSVersion converts the prober's version label back to the tls constant.
+// Unknown labels map to zero so they never satisfy a minimum.
+func parseTLSVersion(label string) uint16 {
+	switch strings.ToUpper(strings.ReplaceAll(label, " ", "")) {
+	case "TLS1.3":
+		return tls.VersionTLS13
+	case "TLS1.2":
+		return tls.VersionTLS12
+	case "TLS1.1":
+		return tls.VersionTLS11
+	case "TLS1.0":
+		return tls.VersionTLS10
+	default:
+		return 0
+	}
+}
+
+// normalise maps a latency onto [0, 1] using the configured curve. Unknown
+// shapes fall back to linear.
+func (c LatencyCurve) normalise(d time.Duration) float64 {
 	if d <= 0 {
 		return 1
 	}
-	max := 500 * time.Millisecond
-	value := 1 - float64(d)/float64(max)
+	switch strings.ToLower(c.Shape) {
+	case CurveExponential:
+		if d <= c.Knee {
+			return 1
+		}
+		halfLife := c.HalfLife
+		if halfLife <= 0 {
+			halfLife = defaultLatencyHalfLife
+		}
+		return math.Exp2(-float64(d-c.Knee) / float64(halfLife))
+	case CurveStep:
+		steps := append([]LatencyStep(nil), c.Steps...)
+		sort.Slice(steps, func(i, j int) bool { return steps[i].Below < steps[j].Below })
+		for _, step := range steps {
+			if d < step.Below {
+				return clampUnit(step.Score)
+			}
+		}
+		return 0
+	default:
+		max := c.Max
+		if max <= 0 {
+			max = defaultLatencyCeiling
+		}
+		return clampUnit(1 - float64(d)/float64(max))
+	}
+}
+
+func clampUnit(value float64) float64 {
 	if value < 0 {
-		value = 0
+		return 0
 	}
 	if value > 1 {
-		value = 1
+		return 1
 	}
 	return value
 }
 
+// normaliseColo scores the CF-Ray colo: 1 for a catalogued colo, 0.5 for an
+// unrecognised code and 0 when the response carried none.
+func normaliseColo(colo string) float64 {
+	colo = strings.TrimSpace(colo)
+	if colo == "" {
+		return 0
+	}
+	if _, ok := geo.LookupColo(colo); ok {
+		return 1
+	}
+	return 0.5
+}
+
+// normaliseOrigin is 0 when validation reported an origin mismatch and 1
+// otherwise, including when no origin was expected.
+func normaliseOrigin(v prober.ValidationResult) float64 {
+	if v.ExpectedOrigin != "" && !v.OriginMatch {
+		return 0
+	}
+	for _, failure := range v.Failures {
+		if failure == prober.FailureOriginMismatch {
+			return 0
+		}
+	}
+	return 1
+}
+
 func normaliseThroughput(bitsPerSecond float64) float64 {
 	if bitsPerSecond <= 0 {
 		return 0
@@ -143,6 +434,11 @@ func normaliseThroughput(bitsPerSecond float64) float64 {
 	return math.Sqrt(ratio)
 }
 
+// isRedirect reports whether status is a 3xx response.
+func isRedirect(status int) bool {
+	return status >= 300 && status < 400
+}
+
 func normaliseIntegrity(v prober.ValidationResult, status int) float64 {
 	if len(v.Failures) == 0 && status >= 200 && status < 400 {
 		if v.CertificateMatch && v.OriginMatch {
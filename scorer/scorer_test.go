@@ -1,6 +1,10 @@
 package scorer
 
 import (
+	"crypto/tls"
+	"math"
+	"path/filepath"
+	"reflect"
 	"testing"
 	"time"
 
@@ -24,3 +28,391 @@ func TestScorerScore(t *testing.T) {
 		}
 	}
 }
+
+func TestScorerPenalisesNonCloudflare(t *testing.T) {
+	s := New()
+	base := prober.Measurement{Success: true, TCPDuration: 10 * time.Millisecond, Throughput: 100 * 1024 * 1024, Integrity: prober.IntegrityReport{HTTPStatus: 200}}
+	base.Validation = prober.ValidationResult{CertificateMatch: true, OriginMatch: true}
+	flagged := base
+	flagged.NonCloudflare = true
+	flagged.Validation.Failures = []string{prober.FailureNonCloudflare}
+	clean := s.Score(base)
+	penalised := s.Score(flagged)
+	if penalised.Score >= clean.Score {
+		t.Fatalf("expected non-cloudflare score %.3f to be below %.3f", penalised.Score, clean.Score)
+	}
+	if penalised.Status != "fail" {
+		t.Fatalf("expected fail status, got %s", penalised.Status)
+	}
+}
+
+func TestScorerMinTLSVersion(t *testing.T) {
+	s := New()
+	m := prober.Measurement{Success: true, TLSVersion: "TLS1.1", TCPDuration: 10 * time.Millisecond, Throughput: 100 * 1024 * 1024, Integrity: prober.IntegrityReport{HTTPStatus: 200}}
+	m.Validation = prober.ValidationResult{CertificateMatch: true, OriginMatch: true}
+
+	neutral := s.Score(m)
+	if neutral.Status != "pass" {
+		t.Fatalf("expected pass without a minimum, got %s", neutral.Status)
+	}
+
+	s.Config.MinTLSVersion = tls.VersionTLS12
+	result := s.Score(m)
+	if result.Status != "fail" {
+		t.Fatalf("expected fail for TLS1.1, got %s", result.Status)
+	}
+	if result.Score > tlsVersionScoreCap {
+		t.Fatalf("expected score capped at %.2f, got %.3f", tlsVersionScoreCap, result.Score)
+	}
+	found := false
+	for _, failure := range result.Failures {
+		found = found || failure == FailureTLSVersion
+	}
+	if !found {
+		t.Fatalf("expected %s failure, got %v", FailureTLSVersion, result.Failures)
+	}
+
+	m.TLSVersion = "TLS1.3"
+	if result := s.Score(m); result.Status != "pass" {
+		t.Fatalf("expected TLS1.3 to pass, got %s %v", result.Status, result.Failures)
+	}
+}
+
+func TestScorerColoPenalty(t *testing.T) {
+	s := New()
+	m := prober.Measurement{Success: true, TCPDuration: 20 * time.Millisecond, Throughput: 100 * 1024 * 1024, Integrity: prober.IntegrityReport{HTTPStatus: 200}}
+	m.Validation = prober.ValidationResult{CertificateMatch: true, OriginMatch: true}
+	known := m
+	known.CFColo = "SJC"
+
+	if s.Score(m).Score != s.Score(known).Score {
+		t.Fatalf("expected colo to be ignored by default")
+	}
+	if _, ok := s.Score(m).Components["colo"]; ok {
+		t.Fatalf("expected no colo component by default")
+	}
+
+	s.Config.ColoWeight = 0.2
+	empty := s.Score(m)
+	unknown := m
+	unknown.CFColo = "ZZZ"
+	if empty.Score >= s.Score(unknown).Score || s.Score(unknown).Score >= s.Score(known).Score {
+		t.Fatalf("expected empty < unknown < known colo, got %.3f %.3f %.3f", empty.Score, s.Score(unknown).Score, s.Score(known).Score)
+	}
+	if empty.Components["colo"] != 0 {
+		t.Fatalf("expected empty colo component to be 0, got %v", empty.Components["colo"])
+	}
+}
+
+func TestScorerFamilyPreference(t *testing.T) {
+	s := New()
+	s.Config.SourcePreference = nil
+	base := prober.Measurement{Success: true, TCPDuration: 150 * time.Millisecond, Integrity: prober.IntegrityReport{HTTPStatus: 200}}
+	v4, v6 := base, base
+	v4.Family = "ipv4"
+	v6.Family = "ipv6"
+	if s.Score(v4).Score != s.Score(v6).Score {
+		t.Fatalf("expected families to score equally by default")
+	}
+
+	s.Config.FamilyPreference = map[string]float64{"ipv6": 1.1}
+	boosted := s.Score(v6)
+	if boosted.Score <= s.Score(v4).Score {
+		t.Fatalf("expected ipv6 boost, got %.3f vs %.3f", boosted.Score, s.Score(v4).Score)
+	}
+	if boosted.Components["familyPreference"] != 1.1 {
+		t.Fatalf("expected familyPreference component, got %v", boosted.Components)
+	}
+	if _, ok := s.Score(v4).Components["familyPreference"]; ok {
+		t.Fatalf("expected no family component for unconfigured family")
+	}
+}
+
+func TestScorerMinThroughput(t *testing.T) {
+	s := New()
+	m := prober.Measurement{Success: true, TCPDuration: 10 * time.Millisecond, Throughput: 80 * 1024 * 1024, Integrity: prober.IntegrityReport{HTTPStatus: 200}}
+	m.Validation = prober.ValidationResult{CertificateMatch: true, OriginMatch: true}
+	if result := s.Score(m); result.Status != "pass" {
+		t.Fatalf("expected pass without a floor, got %s %v", result.Status, result.Failures)
+	}
+
+	s.Config.MinThroughput = 100 * 1024 * 1024
+	result := s.Score(m)
+	if result.Status != "fail" {
+		t.Fatalf("expected fail below the throughput floor, got %s", result.Status)
+	}
+	found := false
+	for _, failure := range result.Failures {
+		found = found || failure == FailureThroughputFloor
+	}
+	if !found {
+		t.Fatalf("expected %s failure, got %v", FailureThroughputFloor, result.Failures)
+	}
+
+	m.Throughput = 200 * 1024 * 1024
+	if result := s.Score(m); result.Status != "pass" {
+		t.Fatalf("expected pass above the floor, got %s %v", result.Status, result.Failures)
+	}
+}
+
+func TestScorerRequireWebSocket(t *testing.T) {
+	s := New()
+	m := prober.Measurement{Success: true, TCPDuration: 10 * time.Millisecond, Throughput: 80 * 1024 * 1024, Integrity: prober.IntegrityReport{HTTPStatus: 200}}
+	m.Validation = prober.ValidationResult{CertificateMatch: true, OriginMatch: true}
+	s.Config.RequireWebSocket = true
+	result := s.Score(m)
+	if result.Status != "fail" || len(result.Failures) != 1 || result.Failures[0] != FailureWebSocket {
+		t.Fatalf("expected %s failure without an upgrade, got %s %v", FailureWebSocket, result.Status, result.Failures)
+	}
+	m.WSUpgradeOK = true
+	if result := s.Score(m); result.Status != "pass" {
+		t.Fatalf("expected pass once the upgrade succeeded, got %s %v", result.Status, result.Failures)
+	}
+}
+
+func TestScorerRedirectPenalty(t *testing.T) {
+	s := New()
+	ok := prober.Measurement{Success: true, TCPDuration: 10 * time.Millisecond, Throughput: 80 * 1024 * 1024, Integrity: prober.IntegrityReport{HTTPStatus: 200}}
+	ok.Validation = prober.ValidationResult{CertificateMatch: true, OriginMatch: true}
+	redirect := ok
+	redirect.Integrity.HTTPStatus = 302
+	if a, b := s.Score(ok), s.Score(redirect); a.Score != b.Score {
+		t.Fatalf("expected 302 scored like 200 by default, got %v vs %v", a.Score, b.Score)
+	}
+
+	s.Config.RedirectPenalty = 0.3
+	plain, redirected := s.Score(ok), s.Score(redirect)
+	if redirected.Score >= plain.Score {
+		t.Fatalf("expected 302 to score lower than 200, got %v vs %v", redirected.Score, plain.Score)
+	}
+	if got := redirected.Components["redirect"]; math.Abs(got-0.7) > 1e-9 {
+		t.Fatalf("expected redirect component 0.7, got %v", got)
+	}
+	if _, found := plain.Components["redirect"]; found {
+		t.Fatalf("expected no redirect component for a 200")
+	}
+
+	s.Config.RedirectPenalty = 1.5
+	if err := s.Config.Validate(); err == nil {
+		t.Fatalf("expected RedirectPenalty above 1 to be rejected")
+	}
+}
+
+func TestScorerRelativeToBaseline(t *testing.T) {
+	s := New()
+	s.Baseline = &prober.Measurement{Success: true, TCPDuration: 100 * time.Millisecond, Throughput: 10 * 1024 * 1024}
+	fast := prober.Measurement{Success: true, TCPDuration: 50 * time.Millisecond, Throughput: 20 * 1024 * 1024, Integrity: prober.IntegrityReport{HTTPStatus: 200}}
+	slow := prober.Measurement{Success: true, TCPDuration: 200 * time.Millisecond, Throughput: 5 * 1024 * 1024, Integrity: prober.IntegrityReport{HTTPStatus: 200}}
+
+	fastResult := s.Score(fast)
+	if fastResult.Components["relativeLatency"] != 2 || fastResult.Components["relativeThroughput"] != 2 || fastResult.Components["relative"] != 2 {
+		t.Fatalf("unexpected fast components %v", fastResult.Components)
+	}
+	slowResult := s.Score(slow)
+	if slowResult.Components["relative"] != 0.5 {
+		t.Fatalf("expected slow edge at half the baseline, got %v", slowResult.Components)
+	}
+
+	neutral := fastResult.Score
+	s.Config.RelativeWeight = 0.3
+	if s.Score(fast).Score <= s.Score(slow).Score {
+		t.Fatalf("expected weighted relative component to favour the faster edge")
+	}
+	s.Baseline = nil
+	if _, ok := s.Score(fast).Components["relative"]; ok || s.Score(fast).Score != neutral {
+		t.Fatalf("expected no relative component without a baseline")
+	}
+}
+
+func TestLatencyCurves(t *testing.T) {
+	ms := time.Millisecond
+	cases := []struct {
+		name  string
+		curve LatencyCurve
+		at    time.Duration
+		want  float64
+	}{
+		{"linear default", LatencyCurve{}, 250 * ms, 0.5},
+		{"linear beyond ceiling", LatencyCurve{}, 600 * ms, 0},
+		{"linear custom max", LatencyCurve{Shape: CurveLinear, Max: time.Second}, 250 * ms, 0.75},
+		{"exponential under knee", LatencyCurve{Shape: CurveExponential, Knee: 50 * ms, HalfLife: 20 * ms}, 40 * ms, 1},
+		{"exponential one half-life", LatencyCurve{Shape: CurveExponential, Knee: 50 * ms, HalfLife: 20 * ms}, 70 * ms, 0.5},
+		{"exponential two half-lives", LatencyCurve{Shape: CurveExponential, Knee: 50 * ms, HalfLife: 20 * ms}, 90 * ms, 0.25},
+		{"step first band", LatencyCurve{Shape: CurveStep, Steps: []LatencyStep{{Below: 200 * ms, Score: 0.5}, {Below: 50 * ms, Score: 1}}}, 30 * ms, 1},
+		{"step second band", LatencyCurve{Shape: CurveStep, Steps: []LatencyStep{{Below: 50 * ms, Score: 1}, {Below: 200 * ms, Score: 0.5}}}, 120 * ms, 0.5},
+		{"step beyond bands", LatencyCurve{Shape: CurveStep, Steps: []LatencyStep{{Below: 50 * ms, Score: 1}}}, 120 * ms, 0},
+	}
+	for _, tc := range cases {
+		if got := tc.curve.normalise(tc.at); math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("%s: normalise(%s) = %v, want %v", tc.name, tc.at, got, tc.want)
+		}
+	}
+
+	s := New()
+	s.Config.LatencyCurve = LatencyCurve{Shape: CurveStep, Steps: []LatencyStep{{Below: 50 * ms, Score: 1}}}
+	result := s.Score(prober.Measurement{Success: true, TCPDuration: 10 * ms, TLSDuration: 10 * ms, HTTPDuration: 10 * ms})
+	if result.Components["latency"] != 1 {
+		t.Fatalf("expected the configured curve to drive the latency component, got %v", result.Components["latency"])
+	}
+}
+
+func TestScorerOriginConsistency(t *testing.T) {
+	s := New()
+	s.Config.OriginWeight = 0.3
+	base := prober.Measurement{Success: true, TCPDuration: 10 * time.Millisecond, TLSDuration: 10 * time.Millisecond, HTTPDuration: 10 * time.Millisecond, Throughput: 100 * 1024 * 1024, Integrity: prober.IntegrityReport{HTTPStatus: 200}, CertificateCN: "edge.example.com", OriginHost: "wrong.example.net"}
+
+	wrongOrigin := base
+	wrongOrigin.ApplyValidation("origin.example.com", nil)
+	genericDip := base
+	genericDip.OriginHost = "origin.example.com"
+	genericDip.ApplyValidation("origin.example.com", []string{"other.example.com"})
+	if len(wrongOrigin.Validation.Failures) != 1 || len(genericDip.Validation.Failures) != 1 {
+		t.Fatalf("expected one validation failure each, got %v and %v", wrongOrigin.Validation.Failures, genericDip.Validation.Failures)
+	}
+
+	mismatch := s.Score(wrongOrigin)
+	dip := s.Score(genericDip)
+	if mismatch.Components["origin"] != 0 || dip.Components["origin"] != 1 {
+		t.Fatalf("unexpected origin components %v and %v", mismatch.Components["origin"], dip.Components["origin"])
+	}
+	if mismatch.Components["integrity"] != dip.Components["integrity"] {
+		t.Fatalf("expected equal integrity components, got %v and %v", mismatch.Components["integrity"], dip.Components["integrity"])
+	}
+	if mismatch.Score >= dip.Score {
+		t.Fatalf("expected an origin mismatch (%v) to score below a generic integrity dip (%v)", mismatch.Score, dip.Score)
+	}
+
+	s.Config.OriginWeight = 0
+	if _, ok := s.Score(wrongOrigin).Components["origin"]; ok {
+		t.Fatalf("expected no origin component when the weight is zero")
+	}
+}
+
+func TestScorerPassThreshold(t *testing.T) {
+	s := New()
+	measurement := prober.Measurement{Success: true, TCPDuration: 100 * time.Millisecond, TLSDuration: 100 * time.Millisecond, HTTPDuration: 100 * time.Millisecond, Throughput: 10 * 1024 * 1024, Integrity: prober.IntegrityReport{HTTPStatus: 200}, Validation: prober.ValidationResult{CertificateMatch: true, OriginMatch: true}}
+	result := s.Score(measurement)
+	if result.Score < DefaultPassThreshold || result.Status != "pass" {
+		t.Fatalf("expected a pass at the default threshold, got %v (%s)", result.Score, result.Status)
+	}
+
+	s.Config.PassThreshold = result.Score + 0.05
+	if err := s.Config.Validate(); err != nil {
+		t.Fatalf("Validate error = %v", err)
+	}
+	if got := s.Score(measurement); got.Status != "fail" {
+		t.Fatalf("expected a stricter threshold to fail score %v, got %s", got.Score, got.Status)
+	}
+
+	s.Config.PassThreshold = 0.3
+	weak := measurement
+	weak.TCPDuration, weak.TLSDuration, weak.HTTPDuration = 300*time.Millisecond, 200*time.Millisecond, 100*time.Millisecond
+	weak.Throughput = 0
+	if got := s.Score(weak); got.Score >= DefaultPassThreshold || got.Status != "pass" {
+		t.Fatalf("expected a lenient threshold to pass score %v, got %s", got.Score, got.Status)
+	}
+
+	for _, invalid := range []float64{-0.1, 1.5} {
+		s.Config.PassThreshold = invalid
+		if err := s.Config.Validate(); err == nil {
+			t.Fatalf("expected PassThreshold %v to be rejected", invalid)
+		}
+	}
+}
+
+func TestScorerReliability(t *testing.T) {
+	s := New()
+	s.Config.ReliabilityWeight = 0.2
+	steady := prober.Measurement{Success: true, TCPDuration: 10 * time.Millisecond, Throughput: 10 * 1024 * 1024, Integrity: prober.IntegrityReport{HTTPStatus: 200}, Reliability: 1, ReliabilityAttempts: 5}
+	flaky := steady
+	flaky.Reliability = 3.0 / 5
+
+	result := s.Score(flaky)
+	if got := result.Components["reliability"]; math.Abs(got-0.6) > 1e-9 {
+		t.Fatalf("expected reliability component 0.6 for 3/5 connects, got %v", got)
+	}
+	if result.Components["success"] != 1 {
+		t.Fatalf("expected the HTTP success flag to stay independent, got %v", result.Components["success"])
+	}
+	if result.Score >= s.Score(steady).Score {
+		t.Fatalf("expected flaky connects to lower the score")
+	}
+
+	flaky.ReliabilityAttempts = 0
+	if _, ok := s.Score(flaky).Components["reliability"]; ok {
+		t.Fatalf("expected no reliability component without connect attempts")
+	}
+}
+
+func TestRescoreWithAlternativeConfig(t *testing.T) {
+	measurements := []prober.Measurement{
+		{Success: true, TCPDuration: 5 * time.Millisecond, TLSDuration: 5 * time.Millisecond, HTTPDuration: 5 * time.Millisecond, Throughput: 1024 * 1024, Integrity: prober.IntegrityReport{HTTPStatus: 200}, Validation: prober.ValidationResult{CertificateMatch: true, OriginMatch: true}},
+		{Success: true, TCPDuration: 150 * time.Millisecond, TLSDuration: 150 * time.Millisecond, HTTPDuration: 100 * time.Millisecond, Throughput: 50 * 1024 * 1024 * 8, Integrity: prober.IntegrityReport{HTTPStatus: 200}, Validation: prober.ValidationResult{CertificateMatch: true, OriginMatch: true}},
+	}
+	original := Rescore(New().Config, measurements)
+
+	cfg := New().Config
+	cfg.LatencyWeight *= 4
+	rescored := Rescore(cfg, measurements)
+	if len(rescored) != len(measurements) {
+		t.Fatalf("expected one result per measurement, got %d", len(rescored))
+	}
+	if rescored[0].Grade == original[0].Grade && rescored[1].Grade == original[1].Grade {
+		t.Fatalf("expected a heavier latency weight to change grades, got %s/%s before and after", original[0].Grade, original[1].Grade)
+	}
+	if rescored[0].Score <= original[0].Score || rescored[1].Score >= original[1].Score {
+		t.Fatalf("expected the fast edge to gain and the slow edge to lose, got %v->%v and %v->%v", original[0].Score, rescored[0].Score, original[1].Score, rescored[1].Score)
+	}
+}
+
+func TestConfigJSONRoundTrip(t *testing.T) {
+	cfg := New().Config
+	cfg.LatencyWeight = 0.5
+	cfg.FamilyPreference = map[string]float64{"ipv6": 1.1}
+	cfg.MinTLSVersion = tls.VersionTLS12
+	cfg.PassThreshold = 0.7
+	cfg.LatencyCurve = LatencyCurve{Shape: CurveStep, Steps: []LatencyStep{{Below: 50 * time.Millisecond, Score: 1}, {Below: 200 * time.Millisecond, Score: 0.4}}}
+
+	path := filepath.Join(t.TempDir(), "scorer.json")
+	if err := SaveConfig(path, cfg); err != nil {
+		t.Fatalf("SaveConfig: %v", err)
+	}
+	loaded, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if !reflect.DeepEqual(loaded, cfg) {
+		t.Fatalf("config changed across the round trip:\nsaved  %+v\nloaded %+v", cfg, loaded)
+	}
+	m := prober.Measurement{Success: true, Family: "ipv6", TCPDuration: 40 * time.Millisecond, TLSDuration: 40 * time.Millisecond, HTTPDuration: 40 * time.Millisecond, Throughput: 8 * 1024 * 1024, TLSVersion: "TLS1.3", Integrity: prober.IntegrityReport{HTTPStatus: 200}}
+	want := (&Scorer{Config: cfg}).Score(m)
+	got := (&Scorer{Config: loaded}).Score(m)
+	if got.Score != want.Score || got.Grade != want.Grade || got.Status != want.Status {
+		t.Fatalf("loaded config scored differently: %+v vs %+v", got, want)
+	}
+
+	trimmed := New().Config
+	trimmed.SourcePreference = map[string]float64{"third-party": 0.9}
+	trimmed.GradeBoundaries = map[string]float64{"A": 0.8, "F": 0}
+	data, err := MarshalConfig(trimmed)
+	if err != nil {
+		t.Fatalf("MarshalConfig: %v", err)
+	}
+	reloaded, err := ParseConfig(data)
+	if err != nil {
+		t.Fatalf("ParseConfig: %v", err)
+	}
+	if !reflect.DeepEqual(reloaded, trimmed) {
+		t.Fatalf("expected maps to replace the defaults:\nsaved  %+v\nloaded %+v", trimmed, reloaded)
+	}
+	if partial, err := ParseConfig([]byte(`{"latencyWeight": 0.5}`)); err != nil || !reflect.DeepEqual(partial.GradeBoundaries, New().Config.GradeBoundaries) {
+		t.Fatalf("expected omitted maps to keep the defaults, got %v (%v)", partial.GradeBoundaries, err)
+	}
+
+	if _, err := ParseConfig([]byte(`{"passThreshold": 1.5}`)); err == nil {
+		t.Fatalf("expected an out-of-range pass threshold to be rejected")
+	}
+	if _, err := ParseConfig([]byte(`{"latencyWieght": 1}`)); err == nil {
+		t.Fatalf("expected unknown fields to be rejected")
+	}
+}
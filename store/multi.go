@@ -0,0 +1,76 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// MultiJSONLStore presents several JSONL files, such as the shards written by
+// scans on different machines, as a single Store. Save appends to the primary
+// file, the first path given; List concatenates every file in path order.
+type MultiJSONLStore struct {
+	// Dedupe collapses the merged records to the newest one per IP, keeping
+	// the position of the IP's first appearance. Records without an IP are
+	// always kept.
+	Dedupe bool
+
+	stores []*JSONLStore
+}
+
+// NewMultiJSONL creates a MultiJSONLStore over paths. The first path is the
+// primary written by Save; ".gz" paths are handled as by NewJSONL.
+func NewMultiJSONL(paths ...string) *MultiJSONLStore {
+	stores := make([]*JSONLStore, 0, len(paths))
+	for _, path := range paths {
+		stores = append(stores, NewJSONL(path))
+	}
+	return &MultiJSONLStore{stores: stores}
+}
+
+// Save appends the record to the primary file.
+func (s *MultiJSONLStore) Save(ctx context.Context, record Record) error {
+	if len(s.stores) == 0 {
+		return errors.New("no JSONL paths configured")
+	}
+	return s.stores[0].Save(ctx, record)
+}
+
+// List returns the records of every file, de-duplicated when Dedupe is set.
+func (s *MultiJSONLStore) List(ctx context.Context) ([]Record, error) {
+	var merged []Record
+	for _, st := range s.stores {
+		records, err := st.List(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", st.path, err)
+		}
+		merged = append(merged, records...)
+	}
+	if !s.Dedupe {
+		return merged, nil
+	}
+	return newestPerIP(merged), nil
+}
+
+// newestPerIP keeps the most recent record of each IP in the slot of its first
+// appearance.
+func newestPerIP(records []Record) []Record {
+	out := make([]Record, 0, len(records))
+	index := map[string]int{}
+	for _, record := range records {
+		if record.Measurement.IP == nil {
+			out = append(out, record)
+			continue
+		}
+		key := record.Measurement.IP.String()
+		if i, ok := index[key]; ok {
+			if record.Timestamp.After(out[i].Timestamp) {
+				out[i] = record
+			}
+			continue
+		}
+		index[key] = len(out)
+		out = append(out, record)
+	}
+	return out
+}
@@ -0,0 +1,53 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+// Publisher emits records to an external system such as a message bus.
+type Publisher interface {
+	Publish(ctx context.Context, record Record) error
+}
+
+// PublishingStore wraps a Store and publishes every record after it has been
+// saved. Publish failures are logged and otherwise ignored unless Strict is
+// set, so an unavailable bus does not interrupt a scan.
+type PublishingStore struct {
+	Store     Store
+	Publisher Publisher
+	// Strict makes Save return publish failures instead of logging them.
+	Strict bool
+	// Logf reports ignored publish failures. Nil uses log.Printf.
+	Logf func(format string, args ...any)
+}
+
+// NewPublishing returns a PublishingStore that saves to st and publishes to pub.
+func NewPublishing(st Store, pub Publisher) *PublishingStore {
+	return &PublishingStore{Store: st, Publisher: pub}
+}
+
+// Save persists the record and then publishes it. Records that fail to
+// persist are not published.
+func (s *PublishingStore) Save(ctx context.Context, record Record) error {
+	if err := s.Store.Save(ctx, record); err != nil {
+		return err
+	}
+	if err := s.Publisher.Publish(ctx, record); err != nil {
+		if s.Strict {
+			return fmt.Errorf("publish record: %w", err)
+		}
+		logf := s.Logf
+		if logf == nil {
+			logf = log.Printf
+		}
+		logf("publish record: %v", err)
+	}
+	return nil
+}
+
+// List returns the records of the wrapped Store.
+func (s *PublishingStore) List(ctx context.Context) ([]Record, error) {
+	return s.Store.List(ctx)
+}
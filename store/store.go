@@ -2,10 +2,13 @@ package store
 
 import (
 	"bufio"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"errors"
+	"io"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
@@ -14,14 +17,19 @@ import (
 
 // Record represents a scored measurement ready to be persisted.
 type Record struct {
-	Timestamp      time.Time          `json:"timestamp"`
-	Source         string             `json:"source"`
-	Score          float64            `json:"score"`
-	Grade          string             `json:"grade"`
-	Status         string             `json:"status"`
-	FailureReasons []string           `json:"failure_reasons,omitempty"`
-	Components     map[string]float64 `json:"components"`
-	Measurement    prober.Measurement `json:"measurement"`
+	Timestamp time.Time `json:"timestamp"`
+	RunID     string    `json:"run_id,omitempty"`
+	// BaselineLatency is the TCP+TLS+HTTP latency of the run's baseline
+	// probe, letting scores from machines on different networks be
+	// normalised later. Zero when the run had no baseline.
+	BaselineLatency time.Duration      `json:"baseline_latency,omitempty"`
+	Source          string             `json:"source"`
+	Score           float64            `json:"score"`
+	Grade           string             `json:"grade"`
+	Status          string             `json:"status"`
+	FailureReasons  []string           `json:"failure_reasons,omitempty"`
+	Components      map[string]float64 `json:"components"`
+	Measurement     prober.Measurement `json:"measurement"`
 }
 
 // Store persists and retrieves measurement records.
@@ -33,12 +41,21 @@ type Store interface {
 // JSONLStore appends records to a JSON Lines file and can read them back.
 type JSONLStore struct {
 	path string
+	gzip bool
 	mu   sync.Mutex
 }
 
-// NewJSONL creates a JSONLStore writing to the provided path.
+// NewJSONL creates a JSONLStore writing to the provided path. Paths ending in
+// ".gz" are gzip-compressed as with NewJSONLGzip.
 func NewJSONL(path string) *JSONLStore {
-	return &JSONLStore{path: path}
+	return &JSONLStore{path: path, gzip: strings.HasSuffix(path, ".gz")}
+}
+
+// NewJSONLGzip creates a JSONLStore that writes each Save as its own gzip
+// member, so appends never rewrite earlier data and List reads the
+// concatenated members back as one stream.
+func NewJSONLGzip(path string) *JSONLStore {
+	return &JSONLStore{path: path, gzip: true}
 }
 
 // Save appends the record as a JSON line.
@@ -59,8 +76,15 @@ func (s *JSONLStore) Save(ctx context.Context, record Record) error {
 		return ctx.Err()
 	default:
 	}
-	_, err = f.Write(append(data, '\n'))
-	return err
+	if !s.gzip {
+		_, err = f.Write(append(data, '\n'))
+		return err
+	}
+	zw := gzip.NewWriter(f)
+	if _, err := zw.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return zw.Close()
 }
 
 // List reads all records from the JSONL file.
@@ -78,7 +102,19 @@ func (s *JSONLStore) List(ctx context.Context) ([]Record, error) {
 		return nil, ctx.Err()
 	default:
 	}
-	scanner := bufio.NewScanner(f)
+	var r io.Reader = f
+	if s.gzip {
+		zr, err := gzip.NewReader(f)
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	}
+	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
 		select {
 		case <-ctx.Done():
@@ -144,5 +180,60 @@ func (s *MemoryStore) List(ctx context.Context) ([]Record, error) {
 	return out, nil
 }
 
+// RingStore keeps the most recent records in a fixed-size circular buffer,
+// bounding memory for long-running in-memory deployments.
+type RingStore struct {
+	mu      sync.Mutex
+	records []Record
+	next    int
+	full    bool
+}
+
+// NewMemoryRing creates a RingStore holding at most capacity records. Once
+// full, each Save overwrites the oldest record. Capacities below 1 are
+// treated as 1.
+func NewMemoryRing(capacity int) *RingStore {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &RingStore{records: make([]Record, capacity)}
+}
+
+// Save stores the record, evicting the oldest one when the buffer is full.
+func (s *RingStore) Save(ctx context.Context, record Record) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[s.next] = record
+	s.next = (s.next + 1) % len(s.records)
+	if s.next == 0 {
+		s.full = true
+	}
+	return nil
+}
+
+// List returns a snapshot of the buffered records, oldest first.
+func (s *RingStore) List(ctx context.Context) ([]Record, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.full {
+		out := make([]Record, s.next)
+		copy(out, s.records[:s.next])
+		return out, nil
+	}
+	out := make([]Record, 0, len(s.records))
+	out = append(out, s.records[s.next:]...)
+	return append(out, s.records[:s.next]...), nil
+}
+
 // ErrNotFound indicates the requested record is missing.
 var ErrNotFound = errors.New("record not found")
@@ -1,9 +1,16 @@
 package store
 
 import (
+	"bufio"
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -42,6 +49,69 @@ func TestMemoryStore(t *testing.T) {
 	}
 }
 
+func TestMemoryRingKeepsNewestRecords(t *testing.T) {
+	const capacity = 4
+	s := NewMemoryRing(capacity)
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < capacity+5; i++ {
+		if err := s.Save(context.Background(), Record{Timestamp: base.Add(time.Duration(i) * time.Minute), Score: float64(i)}); err != nil {
+			t.Fatalf("Save error = %v", err)
+		}
+	}
+	records, err := s.List(context.Background())
+	if err != nil {
+		t.Fatalf("List error = %v", err)
+	}
+	if len(records) != capacity {
+		t.Fatalf("expected %d records, got %d", capacity, len(records))
+	}
+	for i, record := range records {
+		if want := float64(5 + i); record.Score != want {
+			t.Fatalf("record %d: expected score %v, got %v", i, want, record.Score)
+		}
+	}
+}
+
+func TestMultiJSONLMergesFiles(t *testing.T) {
+	dir := t.TempDir()
+	primary, shard := filepath.Join(dir, "edges-a.jsonl"), filepath.Join(dir, "edges-b.jsonl")
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ip := func(s string) prober.Measurement { return prober.Measurement{IP: net.ParseIP(s)} }
+	if err := NewJSONL(shard).Save(context.Background(), Record{Timestamp: base.Add(time.Hour), Score: 0.9, Measurement: ip("1.1.1.1")}); err != nil {
+		t.Fatalf("Save shard error = %v", err)
+	}
+	if err := NewJSONL(shard).Save(context.Background(), Record{Timestamp: base, Score: 0.4, Measurement: ip("2.2.2.2")}); err != nil {
+		t.Fatalf("Save shard error = %v", err)
+	}
+	multi := NewMultiJSONL(primary, shard)
+	if err := multi.Save(context.Background(), Record{Timestamp: base, Score: 0.5, Measurement: ip("1.1.1.1")}); err != nil {
+		t.Fatalf("Save error = %v", err)
+	}
+	if written, _ := NewJSONL(primary).List(context.Background()); len(written) != 1 {
+		t.Fatalf("expected Save to write only to the primary, got %d records there", len(written))
+	}
+
+	records, err := multi.List(context.Background())
+	if err != nil {
+		t.Fatalf("List error = %v", err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("expected 3 merged records, got %d", len(records))
+	}
+
+	multi.Dedupe = true
+	records, err = multi.List(context.Background())
+	if err != nil {
+		t.Fatalf("List error = %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected one record per IP, got %d", len(records))
+	}
+	if records[0].Measurement.IP.String() != "1.1.1.1" || records[0].Score != 0.9 {
+		t.Fatalf("expected the newest 1.1.1.1 record to win, got %+v", records[0])
+	}
+}
+
 func TestJSONLStoreContextCancel(t *testing.T) {
 	dir := t.TempDir()
 	path := filepath.Join(dir, "records.jsonl")
@@ -60,3 +130,234 @@ func TestJSONLStoreContextCancel(t *testing.T) {
 		t.Fatalf("unexpected stat error: %v", err)
 	}
 }
+
+func TestJSONLGzipStoreRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "records.jsonl.gz")
+	ctx := context.Background()
+	first := NewJSONL(path)
+	if err := first.Save(ctx, Record{Timestamp: time.Now(), Score: 0.5, Measurement: prober.Measurement{Domain: "a.example"}}); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if err := first.Save(ctx, Record{Timestamp: time.Now(), Score: 0.6, Measurement: prober.Measurement{Domain: "b.example"}}); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	reopened := NewJSONLGzip(path)
+	if err := reopened.Save(ctx, Record{Timestamp: time.Now(), Score: 0.7, Measurement: prober.Measurement{Domain: "c.example"}}); err != nil {
+		t.Fatalf("save after reopen: %v", err)
+	}
+	records, err := NewJSONLGzip(path).List(ctx)
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(records) != 3 || records[0].Measurement.Domain != "a.example" || records[2].Score != 0.7 {
+		t.Fatalf("unexpected records %+v", records)
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if len(raw) < 2 || raw[0] != 0x1f || raw[1] != 0x8b {
+		t.Fatalf("expected gzip data on disk")
+	}
+
+	empty, err := NewJSONLGzip(filepath.Join(t.TempDir(), "empty.jsonl.gz")).List(ctx)
+	if err != nil || len(empty) != 0 {
+		t.Fatalf("expected empty list for new file, got %v %v", empty, err)
+	}
+}
+
+func TestEWMAViewCollapsesByIP(t *testing.T) {
+	ctx := context.Background()
+	mem := NewMemory()
+	view := NewEWMAView(mem, 0.5)
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	probes := []Record{
+		{Timestamp: base.Add(2 * time.Minute), Score: 0.4, Measurement: prober.Measurement{IP: net.ParseIP("1.1.1.1"), CFColo: "LAX"}},
+		{Timestamp: base, Score: 0.8, Measurement: prober.Measurement{IP: net.ParseIP("1.1.1.1"), CFColo: "SJC"}},
+		{Timestamp: base.Add(time.Minute), Score: 0.6, Measurement: prober.Measurement{IP: net.ParseIP("1.1.1.1"), CFColo: "SJC"}},
+		{Timestamp: base.Add(time.Minute), Score: 0.9, Measurement: prober.Measurement{IP: net.ParseIP("1.0.0.1")}},
+	}
+	for _, record := range probes {
+		if err := view.Save(ctx, record); err != nil {
+			t.Fatalf("save: %v", err)
+		}
+	}
+	records, err := view.List(ctx)
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected one record per IP, got %d", len(records))
+	}
+	smoothed := records[1]
+	// 0.8 -> 0.5*0.6+0.5*0.8 = 0.7 -> 0.5*0.4+0.5*0.7 = 0.55
+	if !smoothed.Measurement.IP.Equal(net.ParseIP("1.1.1.1")) || math.Abs(smoothed.Score-0.55) > 1e-9 || smoothed.Measurement.CFColo != "LAX" {
+		t.Fatalf("unexpected smoothed record %+v", smoothed)
+	}
+	raw, _ := mem.List(ctx)
+	if len(raw) != 4 {
+		t.Fatalf("expected raw store to keep every probe, got %d", len(raw))
+	}
+}
+
+type fakePublisher struct {
+	published []Record
+	err       error
+}
+
+func (p *fakePublisher) Publish(ctx context.Context, record Record) error {
+	p.published = append(p.published, record)
+	return p.err
+}
+
+func TestPublishingStorePublishesEverySave(t *testing.T) {
+	pub := &fakePublisher{}
+	st := NewPublishing(NewMemory(), pub)
+	for _, score := range []float64{0.5, 0.9} {
+		if err := st.Save(context.Background(), Record{Score: score}); err != nil {
+			t.Fatalf("Save error = %v", err)
+		}
+	}
+	if len(pub.published) != 2 || pub.published[1].Score != 0.9 {
+		t.Fatalf("expected each Save to publish, got %+v", pub.published)
+	}
+	records, _ := st.List(context.Background())
+	if len(records) != 2 {
+		t.Fatalf("expected records persisted, got %d", len(records))
+	}
+
+	pub.err = errors.New("bus down")
+	var logged []string
+	st.Logf = func(format string, args ...any) { logged = append(logged, fmt.Sprintf(format, args...)) }
+	if err := st.Save(context.Background(), Record{Score: 0.1}); err != nil {
+		t.Fatalf("publish failure should only be logged, got %v", err)
+	}
+	if len(logged) != 1 {
+		t.Fatalf("expected the failure to be logged, got %v", logged)
+	}
+	st.Strict = true
+	if err := st.Save(context.Background(), Record{Score: 0.2}); err == nil {
+		t.Fatal("expected strict mode to return the publish failure")
+	}
+	if records, _ := st.List(context.Background()); len(records) != 4 {
+		t.Fatalf("expected records persisted despite publish failures, got %d", len(records))
+	}
+}
+
+func TestNATSPublisherSendsPUB(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	lines := make(chan string, 4)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("INFO {\"server_id\":\"test\"}\r\n"))
+		reader := bufio.NewReader(conn)
+		for i := 0; i < 3; i++ {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			lines <- strings.TrimSpace(line)
+		}
+	}()
+
+	pub := NewNATSPublisher("nats://"+ln.Addr().String(), "edges")
+	defer pub.Close()
+	if err := pub.Publish(context.Background(), Record{Score: 0.75}); err != nil {
+		t.Fatalf("Publish error = %v", err)
+	}
+	if connect := <-lines; !strings.HasPrefix(connect, "CONNECT {") {
+		t.Fatalf("expected CONNECT, got %q", connect)
+	}
+	header, payload := <-lines, <-lines
+	if !strings.HasPrefix(header, "PUB edges ") {
+		t.Fatalf("expected PUB to subject, got %q", header)
+	}
+	if header != fmt.Sprintf("PUB edges %d", len(payload)) || !strings.Contains(payload, `"score":0.75`) {
+		t.Fatalf("unexpected message %q %q", header, payload)
+	}
+}
+
+func TestNATSPublisherAnswersPingAfterIdle(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	pong := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("INFO {\"server_id\":\"test\"}\r\n"))
+		reader := bufio.NewReader(conn)
+		// CONNECT, PUB header and payload.
+		for i := 0; i < 3; i++ {
+			if _, err := reader.ReadString('\n'); err != nil {
+				return
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+		conn.Write([]byte("PING\r\n"))
+		line, _ := reader.ReadString('\n')
+		pong <- strings.TrimSpace(line)
+	}()
+
+	pub := NewNATSPublisher("nats://"+ln.Addr().String(), "edges")
+	pub.Timeout = 20 * time.Millisecond
+	defer pub.Close()
+	if err := pub.Publish(context.Background(), Record{Score: 0.75}); err != nil {
+		t.Fatalf("Publish error = %v", err)
+	}
+	select {
+	case line := <-pong:
+		if line != "PONG" {
+			t.Fatalf("expected PONG after the publish deadline passed, got %q", line)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for PONG")
+	}
+}
+
+func TestNATSPublisherRejectsUnsupportedServers(t *testing.T) {
+	cases := []struct {
+		info string
+		want string
+	}{
+		{info: `{"tls_required":true}`, want: "requires TLS"},
+		{info: `{"auth_required":true}`, want: "requires authentication"},
+	}
+	for _, tc := range cases {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			conn.Write([]byte("INFO " + tc.info + "\r\n"))
+			io.Copy(io.Discard, conn)
+		}()
+		pub := NewNATSPublisher("nats://"+ln.Addr().String(), "edges")
+		err = pub.Publish(context.Background(), Record{Score: 0.75})
+		pub.Close()
+		ln.Close()
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Fatalf("INFO %s: expected an error mentioning %q, got %v", tc.info, tc.want, err)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package store
+
+import (
+	"context"
+	"sort"
+)
+
+// DefaultEWMAAlpha is the weight of the newest score used by EWMAView.
+const DefaultEWMAAlpha = 0.5
+
+// EWMAView wraps a Store and collapses repeated probes of the same IP in List.
+// Each IP is reported once using its most recent record, with Score replaced
+// by an exponentially-weighted moving average over its probes in time order.
+// The wrapped Store still returns the raw records.
+type EWMAView struct {
+	Store Store
+	// Alpha weights the newest score in (0,1]. Zero uses DefaultEWMAAlpha.
+	Alpha float64
+}
+
+// NewEWMAView returns a view over st using alpha for smoothing.
+func NewEWMAView(st Store, alpha float64) *EWMAView {
+	return &EWMAView{Store: st, Alpha: alpha}
+}
+
+// Save stores the record in the wrapped Store.
+func (v *EWMAView) Save(ctx context.Context, record Record) error {
+	return v.Store.Save(ctx, record)
+}
+
+// List returns one smoothed record per IP, ordered by latest timestamp.
+func (v *EWMAView) List(ctx context.Context) ([]Record, error) {
+	records, err := v.Store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	alpha := v.Alpha
+	if alpha <= 0 || alpha > 1 {
+		alpha = DefaultEWMAAlpha
+	}
+	sorted := append([]Record(nil), records...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Timestamp.Before(sorted[j].Timestamp)
+	})
+	index := map[string]int{}
+	var out []Record
+	for _, record := range sorted {
+		key := record.Measurement.IP.String()
+		i, seen := index[key]
+		if !seen {
+			index[key] = len(out)
+			out = append(out, record)
+			continue
+		}
+		smoothed := alpha*record.Score + (1-alpha)*out[i].Score
+		out[i] = record
+		out[i].Score = smoothed
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].Timestamp.Before(out[j].Timestamp)
+	})
+	return out, nil
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/example/cf-edgescout/scheduler"
+)
+
+// DaemonController is the subset of scheduler.DaemonControl exposed through
+// the admin endpoints.
+type DaemonController interface {
+	Pause()
+	Resume()
+	Status() scheduler.DaemonStatus
+}
+
+type adminStatusResponse struct {
+	State         string     `json:"state"`
+	Paused        bool       `json:"paused"`
+	LastCycle     *time.Time `json:"lastCycle,omitempty"`
+	SkippedCycles int        `json:"skippedCycles"`
+}
+
+// requireAdmin rejects requests that do not carry the configured admin token
+// as a bearer credential. Admin endpoints stay closed when no token is set.
+func (s *Server) requireAdmin(method string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if s.AdminToken == "" {
+			http.Error(w, "admin token not configured", http.StatusForbidden)
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.AdminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="edgescout-admin"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *Server) handleAdminPause(w http.ResponseWriter, r *http.Request) {
+	s.Daemon.Pause()
+	s.handleAdminStatus(w, r)
+}
+
+func (s *Server) handleAdminResume(w http.ResponseWriter, r *http.Request) {
+	s.Daemon.Resume()
+	s.handleAdminStatus(w, r)
+}
+
+func (s *Server) handleAdminStatus(w http.ResponseWriter, r *http.Request) {
+	status := s.Daemon.Status()
+	resp := adminStatusResponse{State: "running", Paused: status.Paused, SkippedCycles: status.Skipped}
+	if status.Paused {
+		resp.State = "paused"
+	}
+	if !status.LastCycle.IsZero() {
+		last := status.LastCycle.UTC()
+		resp.LastCycle = &last
+	}
+	s.writeJSON(w, r, resp)
+}
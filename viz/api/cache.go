@@ -0,0 +1,139 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// CacheEntry is a rendered response kept by a Cache.
+type CacheEntry struct {
+	Body        []byte
+	ContentType string
+	StoredAt    time.Time
+}
+
+// Cache stores rendered API responses for a bounded time. Implementations must
+// be safe for concurrent use; the in-process map is used when Server.Cache is nil.
+type Cache interface {
+	Get(key string) (CacheEntry, bool)
+	Set(key string, entry CacheEntry, ttl time.Duration)
+}
+
+type cacheItem struct {
+	entry   CacheEntry
+	expires time.Time
+}
+
+// responseCache is the default in-process Cache backed by a map.
+type responseCache struct {
+	mu    sync.Mutex
+	items map[string]cacheItem
+}
+
+func newResponseCache() *responseCache {
+	return &responseCache{items: make(map[string]cacheItem)}
+}
+
+// Get returns the entry for key unless it is missing or expired.
+func (c *responseCache) Get(key string) (CacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	item, ok := c.items[key]
+	if !ok {
+		return CacheEntry{}, false
+	}
+	if time.Now().After(item.expires) {
+		delete(c.items, key)
+		return CacheEntry{}, false
+	}
+	return item.entry, true
+}
+
+// Set stores the entry for ttl.
+func (c *responseCache) Set(key string, entry CacheEntry, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.items[key] = cacheItem{entry: entry, expires: time.Now().Add(ttl)}
+}
+
+// cache resolves the backend used by the wrap middleware, or nil when caching
+// is disabled.
+func (s *Server) cache() Cache {
+	if s.CacheTTL <= 0 {
+		return nil
+	}
+	if s.Cache != nil {
+		return s.Cache
+	}
+	s.cacheOnce.Do(func() {
+		s.defaultCache = newResponseCache()
+	})
+	return s.defaultCache
+}
+
+// wrap serves GET requests from the cache when possible and stores successful
+// responses produced by next. Responses carry X-Cache: HIT or MISS, and hits
+// also report the entry's age in whole seconds via the Age header.
+func (s *Server) wrap(cache Cache, next http.HandlerFunc) http.HandlerFunc {
+	if cache == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			next(w, r)
+			return
+		}
+		key := r.URL.RequestURI()
+		if entry, ok := cache.Get(key); ok {
+			w.Header().Set("Content-Type", entry.ContentType)
+			w.Header().Set("X-Cache", "HIT")
+			w.Header().Set("Age", strconv.Itoa(entryAge(entry)))
+			_, _ = w.Write(entry.Body)
+			return
+		}
+		rec := &bufferedWriter{header: http.Header{}, status: http.StatusOK}
+		next(rec, r)
+		if rec.status == http.StatusOK {
+			cache.Set(key, CacheEntry{Body: rec.body.Bytes(), ContentType: rec.header.Get("Content-Type"), StoredAt: time.Now()}, s.CacheTTL)
+		}
+		w.Header().Set("X-Cache", "MISS")
+		rec.flushTo(w)
+	}
+}
+
+// entryAge returns the seconds elapsed since entry was stored, never negative.
+// Entries without a StoredAt timestamp report an age of zero.
+func entryAge(entry CacheEntry) int {
+	if entry.StoredAt.IsZero() {
+		return 0
+	}
+	age := int(time.Since(entry.StoredAt) / time.Second)
+	if age < 0 {
+		return 0
+	}
+	return age
+}
+
+// bufferedWriter captures a handler's response so it can be cached.
+type bufferedWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedWriter) Header() http.Header { return b.header }
+
+func (b *bufferedWriter) WriteHeader(status int) { b.status = status }
+
+func (b *bufferedWriter) Write(p []byte) (int, error) { return b.body.Write(p) }
+
+func (b *bufferedWriter) flushTo(w http.ResponseWriter) {
+	for key, values := range b.header {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(b.status)
+	_, _ = w.Write(b.body.Bytes())
+}
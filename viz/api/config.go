@@ -0,0 +1,65 @@
+package api
+
+import (
+	"net/http"
+)
+
+// configResponse is the effective, non-secret server configuration.
+type configResponse struct {
+	MaxAge         string         `json:"maxAge"`
+	CacheTTL       string         `json:"cacheTtl"`
+	StoreTimeout   string         `json:"storeTimeout"`
+	MaxDataAge     string         `json:"maxDataAge"`
+	CompactJSON    bool           `json:"compactJson"`
+	LatencyBuckets []float64      `json:"latencyBuckets"`
+	SharedCache    bool           `json:"sharedCache"`
+	RangesEnabled  bool           `json:"rangesEnabled"`
+	AdminEnabled   bool           `json:"adminEnabled"`
+	AdminToken     string         `json:"adminToken,omitempty"`
+	Defaults       configDefaults `json:"defaults"`
+}
+
+// configDefaults are the filters applied when a request omits them.
+type configDefaults struct {
+	Limit  int     `json:"limit"`
+	Offset int     `json:"offset"`
+	Trim   float64 `json:"trim"`
+	Fresh  bool    `json:"fresh"`
+}
+
+// redacted replaces secrets in the config response.
+const redacted = "[redacted]"
+
+// handleConfig reports the configuration the server is running with. Secrets
+// are never echoed back.
+func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
+	buckets := s.LatencyBuckets
+	if buckets == nil {
+		buckets = defaultLatencyBuckets
+	}
+	resp := configResponse{
+		MaxAge:         s.MaxAge.String(),
+		CacheTTL:       s.CacheTTL.String(),
+		StoreTimeout:   s.StoreTimeout.String(),
+		MaxDataAge:     s.MaxDataAge.String(),
+		CompactJSON:    s.CompactJSON,
+		LatencyBuckets: buckets,
+		SharedCache:    s.Cache != nil,
+		RangesEnabled:  s.RangeCacheDir != "",
+		AdminEnabled:   s.Daemon != nil,
+		Defaults:       configDefaults{Limit: defaultLimit},
+	}
+	if s.AdminToken != "" {
+		resp.AdminToken = redacted
+	}
+	s.writeJSON(w, r, resp)
+}
+
+// configHandler serves /config openly unless an admin token is configured,
+// in which case the token is required.
+func (s *Server) configHandler() http.HandlerFunc {
+	if s.AdminToken != "" {
+		return s.requireAdmin(http.MethodGet, s.handleConfig)
+	}
+	return s.handleConfig
+}
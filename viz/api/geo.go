@@ -0,0 +1,31 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/example/cf-edgescout/geo"
+)
+
+// coloItem is one entry of the geo catalog as served to frontends.
+type coloItem struct {
+	Code    string `json:"code"`
+	City    string `json:"city"`
+	Country string `json:"country"`
+}
+
+// colosResponse lists the colo catalog used to resolve regions.
+type colosResponse struct {
+	Total int        `json:"total"`
+	Colos []coloItem `json:"colos"`
+}
+
+// handleGeoColos serves the colo code to city/country mapping so frontends
+// need not hardcode it.
+func (s *Server) handleGeoColos(w http.ResponseWriter, r *http.Request) {
+	catalog := geo.Catalog()
+	items := make([]coloItem, 0, len(catalog))
+	for _, info := range catalog {
+		items = append(items, coloItem{Code: info.Code, City: info.City, Country: info.Country})
+	}
+	s.writeJSON(w, r, colosResponse{Total: len(items), Colos: items})
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/example/cf-edgescout/fetcher"
+)
+
+type rangeProvenance struct {
+	Source      string    `json:"source"`
+	Endpoint    string    `json:"endpoint"`
+	RetrievedAt time.Time `json:"retrievedAt"`
+	Credibility float64   `json:"credibility"`
+}
+
+type rangeItem struct {
+	CIDR        string            `json:"cidr"`
+	Family      string            `json:"family"`
+	Credibility float64           `json:"credibility"`
+	Sources     []rangeProvenance `json:"sources"`
+}
+
+type rangesResponse struct {
+	Total int         `json:"total"`
+	Items []rangeItem `json:"items"`
+}
+
+func (s *Server) handleRanges(w http.ResponseWriter, r *http.Request) {
+	if s.RangeCacheDir == "" {
+		http.Error(w, "range data not configured", http.StatusNotFound)
+		return
+	}
+	family := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("family")))
+	switch family {
+	case "", "ipv4", "ipv6":
+	default:
+		http.Error(w, "invalid family", http.StatusBadRequest)
+		return
+	}
+	source := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("source")))
+	set, err := fetcher.LoadAggregatedFromCache(s.RangeCacheDir)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	items := make([]rangeItem, 0, len(set.Entries))
+	for _, entry := range set.Entries {
+		if entry.Network == nil {
+			continue
+		}
+		item := rangeItem{CIDR: entry.Network.String(), Family: "ipv6", Sources: []rangeProvenance{}}
+		if entry.Network.IP.To4() != nil {
+			item.Family = "ipv4"
+		}
+		if family != "" && item.Family != family {
+			continue
+		}
+		matched := source == ""
+		for _, meta := range entry.Metadata {
+			if strings.EqualFold(meta.Source, source) {
+				matched = true
+			}
+			if meta.Credibility > item.Credibility {
+				item.Credibility = meta.Credibility
+			}
+			item.Sources = append(item.Sources, rangeProvenance{
+				Source:      meta.Source,
+				Endpoint:    meta.Endpoint,
+				RetrievedAt: meta.RetrievedAt,
+				Credibility: meta.Credibility,
+			})
+		}
+		if matched {
+			items = append(items, item)
+		}
+	}
+	s.writeJSON(w, r, rangesResponse{Total: len(items), Items: items})
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/example/cf-edgescout/prober"
+	"github.com/example/cf-edgescout/scorer"
+)
+
+// maxRescoreBody bounds the scorer configuration accepted by /results/rescore.
+const maxRescoreBody = 64 << 10
+
+type rescoreItem struct {
+	Timestamp     time.Time          `json:"timestamp"`
+	IP            string             `json:"ip"`
+	Domain        string             `json:"domain"`
+	Score         float64            `json:"score"`
+	Grade         string             `json:"grade"`
+	Status        string             `json:"status"`
+	Components    map[string]float64 `json:"components"`
+	PreviousScore float64            `json:"previousScore"`
+	PreviousGrade string             `json:"previousGrade"`
+}
+
+type rescoreResponse struct {
+	Total int           `json:"total"`
+	Items []rescoreItem `json:"items"`
+}
+
+// handleRescore replays the filtered records through a scorer built from the
+// posted configuration. Fields missing from the body keep the default scorer
+// values; nothing is written back to the store.
+func (s *Server) handleRescore(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRescoreBody))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cfg, err := scorer.ParseConfig(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	records, ok := s.listRecords(w, r)
+	if !ok {
+		return
+	}
+	opts, err := s.parseQueryOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filtered := filterRecords(records, opts)
+	measurements := make([]prober.Measurement, 0, len(filtered))
+	for _, record := range filtered {
+		measurements = append(measurements, record.Measurement)
+	}
+	results := scorer.Rescore(cfg, measurements)
+	items := make([]rescoreItem, 0, len(results))
+	for i, result := range results {
+		item := rescoreItem{
+			Timestamp:     filtered[i].Timestamp,
+			Domain:        result.Measurement.Domain,
+			Score:         result.Score,
+			Grade:         result.Grade,
+			Status:        result.Status,
+			Components:    result.Components,
+			PreviousScore: filtered[i].Score,
+			PreviousGrade: filtered[i].Grade,
+		}
+		if result.Measurement.IP != nil {
+			item.IP = result.Measurement.IP.String()
+		}
+		items = append(items, item)
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].Score > items[j].Score
+	})
+	total := len(items)
+	start := opts.offset
+	if start > total {
+		start = total
+	}
+	end := start + opts.limit
+	if end > total {
+		end = total
+	}
+	s.writeJSON(w, r, rescoreResponse{Total: total, Items: items[start:end]})
+}
@@ -1,19 +1,56 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"net/url"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/example/cf-edgescout/prober"
 	"github.com/example/cf-edgescout/store"
 )
 
 type Server struct {
 	Store store.Store
+	// MaxAge marks records older than this as stale and backs the fresh=true
+	// filter. Zero disables staleness tracking unless max_age is supplied.
+	MaxAge time.Duration
+	// MaxDataAge makes /healthz report 503 when the newest stored record is
+	// older than this, or the store is empty, so orchestrators notice a dead
+	// daemon. Zero keeps /healthz a pure liveness check.
+	MaxDataAge time.Duration
+	// LatencyBuckets are the histogram edges in milliseconds used by the
+	// summary. Nil uses 50, 100 and 200ms.
+	LatencyBuckets []float64
+	// RangeCacheDir points at the fetcher cache directory holding ranges.json
+	// and enables the /ranges endpoint.
+	RangeCacheDir string
+	// Daemon exposes pause/resume controls for an in-process daemon under
+	// /admin. The routes are only registered when it is set.
+	Daemon DaemonController
+	// AdminToken is the bearer token required by the /admin endpoints.
+	AdminToken string
+	// StoreTimeout bounds each Store.List call made by a handler; requests
+	// whose listing exceeds it fail with 503. Zero leaves List unbounded.
+	StoreTimeout time.Duration
+	// CompactJSON encodes responses without indentation. Clients can still
+	// override per request with pretty=true|false.
+	CompactJSON bool
+	// CacheTTL enables response caching for the results endpoints when > 0.
+	CacheTTL time.Duration
+	// Cache overrides the in-process response cache, e.g. with a shared backend.
+	Cache Cache
+
+	cacheOnce    sync.Once
+	defaultCache *responseCache
 }
 
 type listResponse struct {
@@ -28,11 +65,43 @@ type providerSummary struct {
 	SuccessRate float64 `json:"successRate"`
 	AvgScore    float64 `json:"avgScore"`
 	AvgLatency  float64 `json:"avgLatencyMs"`
+	AvgTotal    float64 `json:"avgTotalMs"`
+}
+
+type regionSummary struct {
+	Region      string  `json:"region"`
+	City        string  `json:"city,omitempty"`
+	Country     string  `json:"country,omitempty"`
+	Count       int     `json:"count"`
+	SuccessRate float64 `json:"successRate"`
+	AvgScore    float64 `json:"avgScore"`
+	AvgLatency  float64 `json:"avgLatencyMs"`
+	AvgTotal    float64 `json:"avgTotalMs"`
 }
 
 type summaryResponse struct {
-	GeneratedAt time.Time         `json:"generatedAt"`
-	Providers   []providerSummary `json:"providers"`
+	GeneratedAt time.Time              `json:"generatedAt"`
+	Providers   []providerSummary      `json:"providers"`
+	Regions     []regionSummary        `json:"regions"`
+	Countries   []countryBest          `json:"bestByCountry"`
+	Errors      []errorCategorySummary `json:"errors"`
+	Latency     []latencyBucket        `json:"latencyHistogram"`
+	Scores      scoreSummary           `json:"scores"`
+	OldestShown *time.Time             `json:"oldestShown,omitempty"`
+	NewestShown *time.Time             `json:"newestShown,omitempty"`
+	Stale       bool                   `json:"stale"`
+	StaleCount  int                    `json:"staleCount"`
+}
+
+type errorsResponse struct {
+	Total      int                    `json:"total"`
+	Categories []errorCategorySummary `json:"categories"`
+}
+
+type protocolsResponse struct {
+	Total       int             `json:"total"`
+	TLSVersions []protocolCount `json:"tlsVersions"`
+	ALPN        []protocolCount `json:"alpn"`
 }
 
 type timeseriesPoint struct {
@@ -44,51 +113,157 @@ type timeseriesPoint struct {
 	Success   bool      `json:"success"`
 }
 
+// timeseriesBucket aggregates the records falling into one bucket interval.
+type timeseriesBucket struct {
+	Start        time.Time `json:"start"`
+	Count        int       `json:"count"`
+	SuccessCount int       `json:"successCount"`
+	SuccessRate  float64   `json:"successRate"`
+	AvgScore     float64   `json:"avgScore"`
+	AvgLatency   float64   `json:"avgLatencyMs"`
+}
+
 type timeseriesResponse struct {
-	Points []timeseriesPoint `json:"points"`
+	Points  []timeseriesPoint  `json:"points"`
+	Buckets []timeseriesBucket `json:"buckets,omitempty"`
 }
 
 type queryOptions struct {
-	source   string
-	provider string
-	success  *bool
+	sources   fieldFilter
+	providers fieldFilter
+	regions   fieldFilter
+	runID     string
+	success   *bool
+	valid     *bool
+	fresh     bool
+	// throughputMin and throughputMax bound Measurement.Throughput (bits/sec)
+	// inclusively; nil leaves that side open.
+	throughputMin *float64
+	throughputMax *float64
+	// distinct collapses the results list to the best-scoring record per
+	// distinctIP or distinctNetwork; empty keeps every record.
+	distinct string
+	trim     float64
+	buckets  []float64
+	maxAge   time.Duration
+	now      time.Time
 	limit    int
 	offset   int
 }
 
+type route struct {
+	pattern string
+	handler http.HandlerFunc
+}
+
 func (s *Server) Handler() http.Handler {
-	apiMux := http.NewServeMux()
-	apiMux.HandleFunc("/healthz", s.handleHealth)
-	apiMux.HandleFunc("/results", s.handleResults)
-	apiMux.HandleFunc("/results/summary", s.handleSummary)
-	apiMux.HandleFunc("/results/timeseries", s.handleTimeseries)
+	cache := s.cache()
+	routes := []route{
+		{"/healthz", s.handleHealth},
+		{"/results", s.wrap(cache, s.handleResults)},
+		{"/results/summary", s.wrap(cache, s.handleSummary)},
+		{"/results/timeseries", s.wrap(cache, s.handleTimeseries)},
+		{"/results/errors", s.wrap(cache, s.handleErrors)},
+		{"/results/protocols", s.wrap(cache, s.handleProtocols)},
+		{"/results/trends", s.wrap(cache, s.handleTrends)},
+		{"/results/rescore", s.handleRescore},
+		{"/ranges", s.wrap(cache, s.handleRanges)},
+		{"/geo/colos", s.wrap(cache, s.handleGeoColos)},
+		{"/config", s.configHandler()},
+	}
+	if s.Daemon != nil {
+		routes = append(routes,
+			route{"/admin/pause", s.requireAdmin(http.MethodPost, s.handleAdminPause)},
+			route{"/admin/resume", s.requireAdmin(http.MethodPost, s.handleAdminResume)},
+			route{"/admin/status", s.requireAdmin(http.MethodGet, s.handleAdminStatus)},
+		)
+	}
 
+	apiMux := http.NewServeMux()
 	root := http.NewServeMux()
-	root.HandleFunc("/healthz", s.handleHealth)
-	root.HandleFunc("/results", s.handleResults)
-	root.HandleFunc("/results/summary", s.handleSummary)
-	root.HandleFunc("/results/timeseries", s.handleTimeseries)
+	for _, route := range routes {
+		apiMux.HandleFunc(route.pattern, route.handler)
+		root.HandleFunc(route.pattern, route.handler)
+	}
 	root.Handle("/api/", http.StripPrefix("/api", apiMux))
 	return root
 }
 
-func (s *Server) handleHealth(w http.ResponseWriter, _ *http.Request) {
+// listRecords lists the store for a handler, writing the error response and
+// returning false on failure. The listing runs in its own goroutine so that a
+// store ignoring its context still cannot hold the request past StoreTimeout.
+func (s *Server) listRecords(w http.ResponseWriter, r *http.Request) ([]store.Record, bool) {
+	if s.StoreTimeout <= 0 {
+		records, err := s.Store.List(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return nil, false
+		}
+		return records, true
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), s.StoreTimeout)
+	defer cancel()
+	type listResult struct {
+		records []store.Record
+		err     error
+	}
+	st := s.Store
+	done := make(chan listResult, 1)
+	go func() {
+		records, err := st.List(ctx)
+		done <- listResult{records: records, err: err}
+	}()
+	select {
+	case res := <-done:
+		if res.err == nil {
+			return res.records, true
+		}
+		if !errors.Is(res.err, context.DeadlineExceeded) {
+			http.Error(w, res.err.Error(), http.StatusInternalServerError)
+			return nil, false
+		}
+	case <-ctx.Done():
+	}
+	http.Error(w, "store timed out", http.StatusServiceUnavailable)
+	return nil, false
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if s.MaxDataAge > 0 {
+		records, ok := s.listRecords(w, r)
+		if !ok {
+			return
+		}
+		var newest time.Time
+		for _, record := range records {
+			if record.Timestamp.After(newest) {
+				newest = record.Timestamp
+			}
+		}
+		if newest.IsZero() {
+			http.Error(w, "no records stored", http.StatusServiceUnavailable)
+			return
+		}
+		if age := time.Since(newest); age > s.MaxDataAge {
+			http.Error(w, fmt.Sprintf("newest record is %s old (max %s)", age.Round(time.Second), s.MaxDataAge), http.StatusServiceUnavailable)
+			return
+		}
+	}
 	w.WriteHeader(http.StatusOK)
 	_, _ = w.Write([]byte("ok"))
 }
 
 func (s *Server) handleResults(w http.ResponseWriter, r *http.Request) {
-	records, err := s.Store.List(r.Context())
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	records, ok := s.listRecords(w, r)
+	if !ok {
 		return
 	}
-	opts, err := parseQueryOptions(r)
+	opts, err := s.parseQueryOptions(r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	filtered := filterRecords(records, opts)
+	filtered := distinctRecords(filterRecords(records, opts), opts.distinct)
 	sort.Slice(filtered, func(i, j int) bool {
 		return filtered[i].Timestamp.After(filtered[j].Timestamp)
 	})
@@ -102,69 +277,98 @@ func (s *Server) handleResults(w http.ResponseWriter, r *http.Request) {
 		end = total
 	}
 	page := filtered[start:end]
-	writeJSON(w, listResponse{Total: total, Items: page})
+	s.writeJSON(w, r, listResponse{Total: total, Items: page})
 }
 
 func (s *Server) handleSummary(w http.ResponseWriter, r *http.Request) {
-	records, err := s.Store.List(r.Context())
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	records, ok := s.listRecords(w, r)
+	if !ok {
 		return
 	}
-	opts, err := parseQueryOptions(r)
+	opts, err := s.parseQueryOptions(r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 	filtered := filterRecords(records, opts)
-	stats := map[string]*providerSummary{}
+	response := summaryResponse{GeneratedAt: opts.now, Scores: summariseScores(filtered, opts.trim)}
 	for _, record := range filtered {
-		key := strings.ToLower(record.Measurement.Provider)
-		if key == "" {
-			key = strings.ToLower(record.Measurement.Source)
+		ts := record.Timestamp
+		if response.OldestShown == nil || ts.Before(*response.OldestShown) {
+			response.OldestShown = &ts
 		}
-		if key == "" {
-			key = "unknown"
-		}
-		summary := stats[key]
-		if summary == nil {
-			summary = &providerSummary{Source: record.Measurement.Source, Provider: record.Measurement.Provider}
-			stats[key] = summary
+		if response.NewestShown == nil || ts.After(*response.NewestShown) {
+			response.NewestShown = &ts
 		}
-		summary.Count++
-		if record.Measurement.Success {
-			summary.SuccessRate += 1
+		if opts.isStale(record) {
+			response.StaleCount++
 		}
-		summary.AvgScore += record.Score
-		latency := record.Measurement.TCPDuration + record.Measurement.TLSDuration + record.Measurement.HTTPDuration
-		summary.AvgLatency += latency.Seconds() * 1000
 	}
-	response := summaryResponse{GeneratedAt: time.Now()}
-	for _, summary := range stats {
-		if summary.Count > 0 {
-			summary.SuccessRate = summary.SuccessRate / float64(summary.Count)
-			summary.AvgScore = summary.AvgScore / float64(summary.Count)
-			summary.AvgLatency = summary.AvgLatency / float64(summary.Count)
-		}
-		response.Providers = append(response.Providers, *summary)
+	if opts.maxAge > 0 && response.NewestShown != nil {
+		response.Stale = opts.now.Sub(*response.NewestShown) > opts.maxAge
+	}
+	response.Providers = summariseGroups(filtered, opts.trim)
+	response.Regions = summariseRegions(filtered, opts.trim)
+	response.Countries = bestByCountry(filtered)
+	response.Errors = summariseErrors(filtered)
+	response.Latency = buildLatencyHistogram(filtered, opts.buckets)
+	s.writeJSON(w, r, response)
+}
+
+func (s *Server) handleErrors(w http.ResponseWriter, r *http.Request) {
+	records, ok := s.listRecords(w, r)
+	if !ok {
+		return
+	}
+	opts, err := s.parseQueryOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	categories := summariseErrors(filterRecords(records, opts))
+	total := 0
+	for _, category := range categories {
+		total += category.Count
+	}
+	s.writeJSON(w, r, errorsResponse{Total: total, Categories: categories})
+}
+
+func (s *Server) handleProtocols(w http.ResponseWriter, r *http.Request) {
+	records, ok := s.listRecords(w, r)
+	if !ok {
+		return
+	}
+	opts, err := s.parseQueryOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	sort.Slice(response.Providers, func(i, j int) bool {
-		return response.Providers[i].AvgScore > response.Providers[j].AvgScore
+	filtered := filterRecords(records, opts)
+	s.writeJSON(w, r, protocolsResponse{
+		Total:       len(filtered),
+		TLSVersions: countProtocols(filtered, func(m prober.Measurement) string { return m.TLSVersion }),
+		ALPN:        countProtocols(filtered, func(m prober.Measurement) string { return m.ALPN }),
 	})
-	writeJSON(w, response)
 }
 
 func (s *Server) handleTimeseries(w http.ResponseWriter, r *http.Request) {
-	records, err := s.Store.List(r.Context())
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	records, ok := s.listRecords(w, r)
+	if !ok {
 		return
 	}
-	opts, err := parseQueryOptions(r)
+	opts, err := s.parseQueryOptions(r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	var bucket time.Duration
+	if raw := r.URL.Query().Get("bucket"); raw != "" {
+		bucket, err = time.ParseDuration(raw)
+		if err != nil || bucket <= 0 {
+			http.Error(w, "invalid bucket", http.StatusBadRequest)
+			return
+		}
+	}
 	filtered := filterRecords(records, opts)
 	points := make([]timeseriesPoint, 0, len(filtered))
 	for _, record := range filtered {
@@ -181,11 +385,38 @@ func (s *Server) handleTimeseries(w http.ResponseWriter, r *http.Request) {
 	sort.Slice(points, func(i, j int) bool {
 		return points[i].Timestamp.Before(points[j].Timestamp)
 	})
-	writeJSON(w, timeseriesResponse{Points: points})
+	resp := timeseriesResponse{Points: points}
+	if bucket > 0 {
+		resp.Buckets = buildTimeseries(filtered, bucket)
+	}
+	s.writeJSON(w, r, resp)
+}
+
+// defaultLimit is the page size used when a request omits limit.
+const defaultLimit = 200
+
+func (s *Server) parseQueryOptions(r *http.Request) (queryOptions, error) {
+	opts, err := parseQueryOptions(r)
+	if err != nil {
+		return opts, err
+	}
+	if opts.maxAge == 0 {
+		opts.maxAge = s.MaxAge
+	}
+	if opts.buckets == nil {
+		opts.buckets = s.LatencyBuckets
+	}
+	if opts.buckets == nil {
+		opts.buckets = defaultLatencyBuckets
+	}
+	if opts.fresh && opts.maxAge <= 0 {
+		return opts, fmt.Errorf("fresh filter requires max_age")
+	}
+	return opts, nil
 }
 
 func parseQueryOptions(r *http.Request) (queryOptions, error) {
-	opts := queryOptions{limit: 200}
+	opts := queryOptions{limit: defaultLimit, now: time.Now()}
 	if limit := r.URL.Query().Get("limit"); limit != "" {
 		v, err := strconv.Atoi(limit)
 		if err != nil || v <= 0 {
@@ -200,11 +431,11 @@ func parseQueryOptions(r *http.Request) (queryOptions, error) {
 		}
 		opts.offset = v
 	}
-	if source := strings.TrimSpace(r.URL.Query().Get("source")); source != "" {
-		opts.source = strings.ToLower(source)
-	}
-	if provider := strings.TrimSpace(r.URL.Query().Get("provider")); provider != "" {
-		opts.provider = strings.ToLower(provider)
+	opts.sources = parseFieldFilter(r.URL.Query(), "source", strings.ToLower)
+	opts.providers = parseFieldFilter(r.URL.Query(), "provider", strings.ToLower)
+	opts.regions = parseFieldFilter(r.URL.Query(), "region", normaliseRegion)
+	if runID := strings.TrimSpace(r.URL.Query().Get("run_id")); runID != "" {
+		opts.runID = runID
 	}
 	if success := strings.TrimSpace(r.URL.Query().Get("success")); success != "" {
 		switch strings.ToLower(success) {
@@ -218,30 +449,241 @@ func parseQueryOptions(r *http.Request) (queryOptions, error) {
 			return opts, fmt.Errorf("invalid success filter")
 		}
 	}
+	if valid := strings.TrimSpace(r.URL.Query().Get("valid")); valid != "" {
+		switch strings.ToLower(valid) {
+		case "true", "1", "yes":
+			value := true
+			opts.valid = &value
+		case "false", "0", "no":
+			value := false
+			opts.valid = &value
+		default:
+			return opts, fmt.Errorf("invalid valid filter")
+		}
+	}
+	if maxAge := strings.TrimSpace(r.URL.Query().Get("max_age")); maxAge != "" {
+		v, err := time.ParseDuration(maxAge)
+		if err != nil || v <= 0 {
+			return opts, fmt.Errorf("invalid max_age")
+		}
+		opts.maxAge = v
+	}
+	if distinct := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("distinct"))); distinct != "" {
+		switch distinct {
+		case distinctIP, distinctNetwork:
+			opts.distinct = distinct
+		default:
+			return opts, fmt.Errorf("invalid distinct")
+		}
+	}
+	if trim := strings.TrimSpace(r.URL.Query().Get("trim")); trim != "" {
+		v, err := strconv.ParseFloat(trim, 64)
+		if err != nil || v < 0 || v >= 50 {
+			return opts, fmt.Errorf("invalid trim")
+		}
+		opts.trim = v
+	}
+	if buckets := strings.TrimSpace(r.URL.Query().Get("buckets")); buckets != "" {
+		edges, err := parseBucketEdges(buckets)
+		if err != nil {
+			return opts, err
+		}
+		opts.buckets = edges
+	}
+	var err error
+	if opts.throughputMin, err = parseThroughputBound(r.URL.Query(), "throughput_min"); err != nil {
+		return opts, err
+	}
+	if opts.throughputMax, err = parseThroughputBound(r.URL.Query(), "throughput_max"); err != nil {
+		return opts, err
+	}
+	if opts.throughputMin != nil && opts.throughputMax != nil && *opts.throughputMin > *opts.throughputMax {
+		return opts, fmt.Errorf("throughput_min exceeds throughput_max")
+	}
+	if fresh := strings.TrimSpace(r.URL.Query().Get("fresh")); fresh != "" {
+		switch strings.ToLower(fresh) {
+		case "true", "1", "yes":
+			opts.fresh = true
+		case "false", "0", "no":
+		default:
+			return opts, fmt.Errorf("invalid fresh filter")
+		}
+	}
 	return opts, nil
 }
 
+// parseBucketEdges parses a comma separated, strictly ascending list of
+// positive millisecond edges.
+func parseBucketEdges(value string) ([]float64, error) {
+	var edges []float64
+	for _, part := range strings.Split(value, ",") {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || v <= 0 || (len(edges) > 0 && v <= edges[len(edges)-1]) {
+			return nil, fmt.Errorf("invalid buckets")
+		}
+		edges = append(edges, v)
+	}
+	return edges, nil
+}
+
+// parseThroughputBound parses a non-negative bits/sec bound, returning nil when
+// the parameter is absent.
+func parseThroughputBound(query url.Values, name string) (*float64, error) {
+	raw := strings.TrimSpace(query.Get(name))
+	if raw == "" {
+		return nil, nil
+	}
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil || v < 0 || math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil, fmt.Errorf("invalid %s", name)
+	}
+	return &v, nil
+}
+
+// isStale reports whether the record is older than the configured max age.
+func (o queryOptions) isStale(record store.Record) bool {
+	return o.maxAge > 0 && o.now.Sub(record.Timestamp) > o.maxAge
+}
+
+// fieldFilter holds the include and exclude values for one record field.
+// Includes are ORed: a record passes when its value matches any of them, or
+// when none are given. Excludes always remove matching records, even when the
+// same value is also included. filterRecords ANDs the fields together.
+type fieldFilter struct {
+	include map[string]bool
+	exclude map[string]bool
+}
+
+// parseFieldFilter reads name and exclude_<name>. Each may be repeated and
+// hold comma separated values; blanks are ignored and normalise maps every
+// value onto the key compared by allows.
+func parseFieldFilter(query url.Values, name string, normalise func(string) string) fieldFilter {
+	collect := func(key string) map[string]bool {
+		var values map[string]bool
+		for _, raw := range query[key] {
+			for _, part := range strings.Split(raw, ",") {
+				if part = strings.TrimSpace(part); part == "" {
+					continue
+				}
+				if values == nil {
+					values = map[string]bool{}
+				}
+				values[normalise(part)] = true
+			}
+		}
+		return values
+	}
+	return fieldFilter{include: collect(name), exclude: collect("exclude_" + name)}
+}
+
+func (f fieldFilter) allows(value string) bool {
+	if f.exclude[value] {
+		return false
+	}
+	return len(f.include) == 0 || f.include[value]
+}
+
+// Values accepted by the distinct query parameter.
+const (
+	distinctIP      = "ip"
+	distinctNetwork = "network"
+)
+
+// distinctRecords keeps the best-scoring record per IP or per
+// Measurement.Network, preferring the newer record on ties. Records without
+// the grouping key are kept as they are.
+func distinctRecords(records []store.Record, by string) []store.Record {
+	if by == "" {
+		return records
+	}
+	out := make([]store.Record, 0, len(records))
+	index := map[string]int{}
+	for _, record := range records {
+		key := record.Measurement.Network
+		if by == distinctIP {
+			key = ""
+			if ip := record.Measurement.IP; ip != nil {
+				key = ip.String()
+			}
+		}
+		if key == "" {
+			out = append(out, record)
+			continue
+		}
+		i, ok := index[key]
+		if !ok {
+			index[key] = len(out)
+			out = append(out, record)
+			continue
+		}
+		best := out[i]
+		if record.Score > best.Score || (record.Score == best.Score && record.Timestamp.After(best.Timestamp)) {
+			out[i] = record
+		}
+	}
+	return out
+}
+
 func filterRecords(records []store.Record, opts queryOptions) []store.Record {
 	result := make([]store.Record, 0, len(records))
 	for _, record := range records {
 		m := record.Measurement
-		if opts.source != "" && strings.ToLower(m.Source) != opts.source {
+		if !opts.sources.allows(strings.ToLower(m.Source)) {
+			continue
+		}
+		if !opts.providers.allows(strings.ToLower(m.Provider)) {
+			continue
+		}
+		if opts.runID != "" && record.RunID != opts.runID {
 			continue
 		}
-		if opts.provider != "" && strings.ToLower(m.Provider) != opts.provider {
+		if !opts.regions.allows(regionOf(record)) {
 			continue
 		}
 		if opts.success != nil && m.Success != *opts.success {
 			continue
 		}
+		if opts.valid != nil && isValidated(m) != *opts.valid {
+			continue
+		}
+		if opts.fresh && opts.isStale(record) {
+			continue
+		}
+		if opts.throughputMin != nil && m.Throughput < *opts.throughputMin {
+			continue
+		}
+		if opts.throughputMax != nil && m.Throughput > *opts.throughputMax {
+			continue
+		}
 		result = append(result, record)
 	}
 	return result
 }
 
-func writeJSON(w http.ResponseWriter, v any) {
+// isValidated reports whether both the certificate and the origin checks passed.
+func isValidated(m prober.Measurement) bool {
+	return m.Validation.CertificateMatch && m.Validation.OriginMatch
+}
+
+// writeJSON encodes v with two-space indentation unless the server is set to
+// CompactJSON; a pretty=true|false query parameter overrides either default.
+func (s *Server) writeJSON(w http.ResponseWriter, r *http.Request, v any) {
 	w.Header().Set("Content-Type", "application/json")
 	encoder := json.NewEncoder(w)
 	encoder.SetEscapeHTML(false)
+	if wantPretty(r, !s.CompactJSON) {
+		encoder.SetIndent("", "  ")
+	}
 	_ = encoder.Encode(v)
 }
+
+func wantPretty(r *http.Request, fallback bool) bool {
+	switch strings.ToLower(strings.TrimSpace(r.URL.Query().Get("pretty"))) {
+	case "true", "1", "yes":
+		return true
+	case "false", "0", "no":
+		return false
+	default:
+		return fallback
+	}
+}
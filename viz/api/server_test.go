@@ -3,12 +3,18 @@ package api
 import (
     "context"
     "encoding/json"
+    "math"
+    "net"
     "net/http"
     "net/http/httptest"
+    "strconv"
+    "strings"
     "testing"
     "time"
 
+    "github.com/example/cf-edgescout/fetcher"
     "github.com/example/cf-edgescout/prober"
+    "github.com/example/cf-edgescout/scheduler"
     "github.com/example/cf-edgescout/store"
 )
 
@@ -110,3 +116,959 @@ func TestTimeseriesEndpoint(t *testing.T) {
         t.Fatalf("expected chronological order")
     }
 }
+
+func TestStaleRecordsFlaggedAndFiltered(t *testing.T) {
+    mem := store.NewMemory()
+    now := time.Now()
+    for _, ts := range []time.Time{now.Add(-2 * time.Hour), now.Add(-time.Minute)} {
+        record := store.Record{Timestamp: ts, Score: 0.8, Measurement: prober.Measurement{Source: "official", Success: true}}
+        if err := mem.Save(context.Background(), record); err != nil {
+            t.Fatalf("save: %v", err)
+        }
+    }
+    server := &Server{Store: mem, MaxAge: time.Hour}
+
+    rr := httptest.NewRecorder()
+    server.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/results/summary", nil))
+    var summary summaryResponse
+    if err := json.Unmarshal(rr.Body.Bytes(), &summary); err != nil {
+        t.Fatalf("decode: %v", err)
+    }
+    if summary.StaleCount != 1 {
+        t.Fatalf("expected 1 stale record got %d", summary.StaleCount)
+    }
+    if summary.Stale {
+        t.Fatalf("expected dataset to be fresh while the newest record is recent")
+    }
+    if summary.OldestShown == nil || summary.NewestShown == nil || !summary.OldestShown.Before(*summary.NewestShown) {
+        t.Fatalf("expected oldest/newest timestamps got %v %v", summary.OldestShown, summary.NewestShown)
+    }
+
+    rr = httptest.NewRecorder()
+    server.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/results?fresh=true", nil))
+    var list listResponse
+    if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil {
+        t.Fatalf("decode: %v", err)
+    }
+    if list.Total != 1 {
+        t.Fatalf("expected stale record to be excluded, got %d", list.Total)
+    }
+
+    rr = httptest.NewRecorder()
+    (&Server{Store: mem}).Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/results?fresh=true", nil))
+    if rr.Code != http.StatusBadRequest {
+        t.Fatalf("expected 400 without a max age got %d", rr.Code)
+    }
+}
+
+type countingStore struct {
+    store.Store
+    lists int
+}
+
+func (c *countingStore) List(ctx context.Context) ([]store.Record, error) {
+    c.lists++
+    return c.Store.List(ctx)
+}
+
+type fakeCache struct {
+    entries map[string]CacheEntry
+    gets    int
+    sets    int
+}
+
+func (f *fakeCache) Get(key string) (CacheEntry, bool) {
+    f.gets++
+    entry, ok := f.entries[key]
+    return entry, ok
+}
+
+func (f *fakeCache) Set(key string, entry CacheEntry, ttl time.Duration) {
+    f.sets++
+    f.entries[key] = entry
+}
+
+func TestWrapUsesPluggableCache(t *testing.T) {
+    backing := &countingStore{Store: prepareStore(t)}
+    cache := &fakeCache{entries: map[string]CacheEntry{}}
+    server := &Server{Store: backing, CacheTTL: time.Minute, Cache: cache}
+    handler := server.Handler()
+
+    var bodies []string
+    for i := 0; i < 2; i++ {
+        rr := httptest.NewRecorder()
+        handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/results/summary", nil))
+        if rr.Code != http.StatusOK {
+            t.Fatalf("expected 200 got %d", rr.Code)
+        }
+        if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+            t.Fatalf("unexpected content type %q", ct)
+        }
+        bodies = append(bodies, rr.Body.String())
+    }
+    if backing.lists != 1 {
+        t.Fatalf("expected store to be listed once, got %d", backing.lists)
+    }
+    if cache.sets != 1 || cache.gets != 2 {
+        t.Fatalf("expected 2 gets and 1 set, got %d/%d", cache.gets, cache.sets)
+    }
+    if bodies[0] != bodies[1] {
+        t.Fatalf("expected cached body to match original")
+    }
+}
+
+func TestSummaryTrimmedMean(t *testing.T) {
+    mem := store.NewMemory()
+    scores := []float64{0.8, 0.81, 0.79, 0.8, 0.82, 0.78, 0.8, 0.81, 0.79, 0.05}
+    for i, score := range scores {
+        record := store.Record{
+            Timestamp:   time.Date(2024, 1, 1, 10, i, 0, 0, time.UTC),
+            Score:       score,
+            Measurement: prober.Measurement{Source: "official", Provider: "Cloudflare 官方发布", Success: true},
+        }
+        if err := mem.Save(context.Background(), record); err != nil {
+            t.Fatalf("save: %v", err)
+        }
+    }
+    server := &Server{Store: mem}
+    decode := func(target string) summaryResponse {
+        rr := httptest.NewRecorder()
+        server.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, target, nil))
+        if rr.Code != http.StatusOK {
+            t.Fatalf("expected 200 got %d", rr.Code)
+        }
+        var resp summaryResponse
+        if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+            t.Fatalf("decode: %v", err)
+        }
+        return resp
+    }
+    plain := decode("/api/results/summary")
+    trimmed := decode("/api/results/summary?trim=10")
+    bulk := 0.8
+    if math.Abs(trimmed.Scores.Average-bulk) >= math.Abs(plain.Scores.Average-bulk) {
+        t.Fatalf("expected trimmed average %.3f closer to %.2f than plain %.3f", trimmed.Scores.Average, bulk, plain.Scores.Average)
+    }
+    if math.Abs(trimmed.Providers[0].AvgScore-bulk) > 0.01 {
+        t.Fatalf("expected trimmed provider average near %.2f got %.3f", bulk, trimmed.Providers[0].AvgScore)
+    }
+    rr := httptest.NewRecorder()
+    server.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/results/summary?trim=60", nil))
+    if rr.Code != http.StatusBadRequest {
+        t.Fatalf("expected 400 for out of range trim got %d", rr.Code)
+    }
+}
+
+func TestRegionNormalisation(t *testing.T) {
+    mem := store.NewMemory()
+    measurements := []prober.Measurement{
+        {CFColo: "SJC", Success: true},
+        {CFColo: "sjc", Success: true},
+        {Location: prober.LocationInfo{City: "San Jose"}, Success: true},
+        {CFColo: "LHR", Success: true},
+    }
+    for i, m := range measurements {
+        record := store.Record{Timestamp: time.Date(2024, 1, 1, 10, i, 0, 0, time.UTC), Score: 0.8, Measurement: m}
+        if err := mem.Save(context.Background(), record); err != nil {
+            t.Fatalf("save: %v", err)
+        }
+    }
+    server := &Server{Store: mem}
+
+    rr := httptest.NewRecorder()
+    server.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/results/summary", nil))
+    var summary summaryResponse
+    if err := json.Unmarshal(rr.Body.Bytes(), &summary); err != nil {
+        t.Fatalf("decode: %v", err)
+    }
+    if len(summary.Regions) != 2 {
+        t.Fatalf("expected 2 regions got %+v", summary.Regions)
+    }
+    for _, region := range summary.Regions {
+        if region.Region == "SJC" && (region.Count != 3 || region.City != "San Jose") {
+            t.Fatalf("expected 3 SJC records grouped together got %+v", region)
+        }
+    }
+
+    for _, query := range []string{"region=sjc", "region=San%20Jose"} {
+        rr := httptest.NewRecorder()
+        server.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/results?"+query, nil))
+        var list listResponse
+        if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil {
+            t.Fatalf("decode: %v", err)
+        }
+        if list.Total != 3 {
+            t.Fatalf("%s: expected 3 records got %d", query, list.Total)
+        }
+    }
+}
+
+func TestErrorsEndpoint(t *testing.T) {
+    mem := store.NewMemory()
+    measurements := []prober.Measurement{
+        {IP: net.ParseIP("1.1.1.1"), Error: "tls dial: remote error: tls: handshake failure"},
+        {IP: net.ParseIP("1.1.1.2"), Error: "tls dial: x509: certificate is not valid"},
+        {IP: net.ParseIP("1.1.1.3"), Error: "tcp dial: i/o timeout"},
+        {IP: net.ParseIP("1.1.1.4"), Success: true},
+    }
+    for _, m := range measurements {
+        if err := mem.Save(context.Background(), store.Record{Timestamp: time.Now(), Measurement: m}); err != nil {
+            t.Fatalf("save: %v", err)
+        }
+    }
+    server := &Server{Store: mem}
+    rr := httptest.NewRecorder()
+    server.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/results/errors", nil))
+    if rr.Code != http.StatusOK {
+        t.Fatalf("expected 200 got %d", rr.Code)
+    }
+    var resp errorsResponse
+    if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+        t.Fatalf("decode: %v", err)
+    }
+    if resp.Total != 3 || len(resp.Categories) != 2 {
+        t.Fatalf("unexpected breakdown %+v", resp)
+    }
+    tls := resp.Categories[0]
+    if tls.Category != "tls" || tls.Count != 2 || len(tls.Examples) != 2 || tls.Examples[0] != "1.1.1.1" {
+        t.Fatalf("expected tls-dial errors categorised as tls, got %+v", tls)
+    }
+
+    rr = httptest.NewRecorder()
+    server.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/results/summary", nil))
+    var summary summaryResponse
+    if err := json.Unmarshal(rr.Body.Bytes(), &summary); err != nil {
+        t.Fatalf("decode: %v", err)
+    }
+    if len(summary.Errors) != 2 {
+        t.Fatalf("expected error breakdown in summary got %+v", summary.Errors)
+    }
+}
+
+func TestSummaryLatencyHistogram(t *testing.T) {
+    mem := store.NewMemory()
+    for i, ms := range []int{10, 49, 50, 120, 150, 450} {
+        record := store.Record{
+            Timestamp:   time.Date(2024, 1, 1, 10, i, 0, 0, time.UTC),
+            Measurement: prober.Measurement{TCPDuration: time.Duration(ms) * time.Millisecond / 2, HTTPDuration: time.Duration(ms) * time.Millisecond / 2},
+        }
+        if err := mem.Save(context.Background(), record); err != nil {
+            t.Fatalf("save: %v", err)
+        }
+    }
+    server := &Server{Store: mem}
+    rr := httptest.NewRecorder()
+    server.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/results/summary", nil))
+    var summary summaryResponse
+    if err := json.Unmarshal(rr.Body.Bytes(), &summary); err != nil {
+        t.Fatalf("decode: %v", err)
+    }
+    want := map[string]int{"0-50": 2, "50-100": 1, "100-200": 2, "200+": 1}
+    if len(summary.Latency) != len(want) {
+        t.Fatalf("expected %d buckets got %+v", len(want), summary.Latency)
+    }
+    for _, bucket := range summary.Latency {
+        if bucket.Count != want[bucket.Label] {
+            t.Fatalf("bucket %s: expected %d got %d", bucket.Label, want[bucket.Label], bucket.Count)
+        }
+    }
+
+    rr = httptest.NewRecorder()
+    server.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/results/summary?buckets=100", nil))
+    summary = summaryResponse{}
+    if err := json.Unmarshal(rr.Body.Bytes(), &summary); err != nil {
+        t.Fatalf("decode: %v", err)
+    }
+    if len(summary.Latency) != 2 || summary.Latency[0].Count != 3 || summary.Latency[1].Count != 3 {
+        t.Fatalf("unexpected custom buckets %+v", summary.Latency)
+    }
+}
+
+func TestRangesEndpoint(t *testing.T) {
+    dir := t.TempDir()
+    aggregator := fetcher.NewAggregator()
+    _, v4, _ := net.ParseCIDR("1.1.1.0/24")
+    _, v6, _ := net.ParseCIDR("2400:cb00::/32")
+    retrieved := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+    aggregator.Add([]fetcher.RangeRecord{
+        {Network: v4, Metadata: fetcher.RangeMetadata{Source: "cloudflare", Endpoint: "https://cf/ips-v4", RetrievedAt: retrieved, Credibility: 1}},
+        {Network: v4, Metadata: fetcher.RangeMetadata{Source: "bestip", Endpoint: "https://bestip/ips", RetrievedAt: retrieved, Credibility: 0.8}},
+        {Network: v6, Metadata: fetcher.RangeMetadata{Source: "cloudflare", Endpoint: "https://cf/ips-v6", RetrievedAt: retrieved, Credibility: 1}},
+    })
+    if err := aggregator.Result().Persist(dir); err != nil {
+        t.Fatalf("persist: %v", err)
+    }
+    server := &Server{Store: store.NewMemory(), RangeCacheDir: dir}
+    get := func(target string) rangesResponse {
+        rr := httptest.NewRecorder()
+        server.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, target, nil))
+        if rr.Code != http.StatusOK {
+            t.Fatalf("%s: expected 200 got %d", target, rr.Code)
+        }
+        var resp rangesResponse
+        if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+            t.Fatalf("decode: %v", err)
+        }
+        return resp
+    }
+    all := get("/api/ranges")
+    if all.Total != 2 {
+        t.Fatalf("expected 2 ranges got %d", all.Total)
+    }
+    v4Only := get("/api/ranges?family=ipv4")
+    if v4Only.Total != 1 || v4Only.Items[0].CIDR != "1.1.1.0/24" {
+        t.Fatalf("unexpected ipv4 ranges %+v", v4Only.Items)
+    }
+    item := v4Only.Items[0]
+    if len(item.Sources) != 2 || item.Credibility != 1 || item.Sources[0].Source != "bestip" || item.Sources[0].Credibility != 0.8 {
+        t.Fatalf("unexpected provenance %+v", item)
+    }
+    bestip := get("/api/ranges?source=bestip")
+    if bestip.Total != 1 {
+        t.Fatalf("expected source filter to keep 1 range got %d", bestip.Total)
+    }
+
+    rr := httptest.NewRecorder()
+    (&Server{Store: store.NewMemory()}).Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/ranges", nil))
+    if rr.Code != http.StatusNotFound {
+        t.Fatalf("expected 404 without cache dir got %d", rr.Code)
+    }
+}
+
+func TestAdminPauseResume(t *testing.T) {
+    control := &scheduler.DaemonControl{}
+    handler := (&Server{Store: store.NewMemory(), Daemon: control, AdminToken: "secret"}).Handler()
+    do := func(method, target, token string) *httptest.ResponseRecorder {
+        req := httptest.NewRequest(method, target, nil)
+        if token != "" {
+            req.Header.Set("Authorization", "Bearer "+token)
+        }
+        rr := httptest.NewRecorder()
+        handler.ServeHTTP(rr, req)
+        return rr
+    }
+
+    if rr := do(http.MethodPost, "/api/admin/pause", ""); rr.Code != http.StatusUnauthorized {
+        t.Fatalf("expected 401 without token got %d", rr.Code)
+    }
+    if rr := do(http.MethodPost, "/api/admin/pause", "wrong"); rr.Code != http.StatusUnauthorized {
+        t.Fatalf("expected 401 with wrong token got %d", rr.Code)
+    }
+    if rr := do(http.MethodGet, "/api/admin/pause", "secret"); rr.Code != http.StatusMethodNotAllowed {
+        t.Fatalf("expected 405 for GET pause got %d", rr.Code)
+    }
+    if control.Status().Paused {
+        t.Fatalf("rejected requests must not pause the daemon")
+    }
+
+    rr := do(http.MethodPost, "/api/admin/pause", "secret")
+    if rr.Code != http.StatusOK || !control.Status().Paused {
+        t.Fatalf("expected pause to succeed, code %d", rr.Code)
+    }
+    var status adminStatusResponse
+    if err := json.Unmarshal(do(http.MethodGet, "/admin/status", "secret").Body.Bytes(), &status); err != nil {
+        t.Fatalf("decode: %v", err)
+    }
+    if status.State != "paused" || !status.Paused || status.LastCycle != nil {
+        t.Fatalf("unexpected status %+v", status)
+    }
+
+    if rr := do(http.MethodPost, "/api/admin/resume", "secret"); rr.Code != http.StatusOK || control.Status().Paused {
+        t.Fatalf("expected resume to succeed, code %d", rr.Code)
+    }
+
+    rr = httptest.NewRecorder()
+    (&Server{Store: store.NewMemory(), Daemon: control}).Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/admin/status", nil))
+    if rr.Code != http.StatusForbidden {
+        t.Fatalf("expected 403 without configured token got %d", rr.Code)
+    }
+}
+
+func TestTimeseriesBucketSuccessRate(t *testing.T) {
+    mem := store.NewMemory()
+    base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+    records := []store.Record{
+        {Timestamp: base.Add(1 * time.Minute), Score: 0.9, Measurement: prober.Measurement{Success: true}},
+        {Timestamp: base.Add(2 * time.Minute), Score: 0.8, Measurement: prober.Measurement{Success: true}},
+        {Timestamp: base.Add(3 * time.Minute), Score: 0.1, Measurement: prober.Measurement{Success: false}},
+        {Timestamp: base.Add(4 * time.Minute), Score: 0.2, Measurement: prober.Measurement{Success: false}},
+        {Timestamp: base.Add(6 * time.Minute), Score: 0.7, Measurement: prober.Measurement{Success: true}},
+    }
+    for _, record := range records {
+        if err := mem.Save(context.Background(), record); err != nil {
+            t.Fatalf("save: %v", err)
+        }
+    }
+    server := &Server{Store: mem}
+    rr := httptest.NewRecorder()
+    server.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/results/timeseries?bucket=5m", nil))
+    if rr.Code != http.StatusOK {
+        t.Fatalf("expected 200 got %d", rr.Code)
+    }
+    var resp timeseriesResponse
+    if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+        t.Fatalf("decode: %v", err)
+    }
+    if len(resp.Points) != 5 || len(resp.Buckets) != 2 {
+        t.Fatalf("expected 5 points in 2 buckets, got %d and %d", len(resp.Points), len(resp.Buckets))
+    }
+    first := resp.Buckets[0]
+    if !first.Start.Equal(base) || first.Count != 4 || first.SuccessCount != 2 || first.SuccessRate != 0.5 {
+        t.Fatalf("unexpected first bucket %+v", first)
+    }
+    if second := resp.Buckets[1]; second.SuccessCount != 1 || second.SuccessRate != 1 {
+        t.Fatalf("unexpected second bucket %+v", second)
+    }
+
+    rr = httptest.NewRecorder()
+    server.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/results/timeseries?bucket=soon", nil))
+    if rr.Code != http.StatusBadRequest {
+        t.Fatalf("expected 400 for invalid bucket got %d", rr.Code)
+    }
+}
+
+func TestValidFilterExcludesValidationFailures(t *testing.T) {
+    mem := store.NewMemory()
+    records := []store.Record{
+        {Timestamp: time.Now(), Score: 0.9, Measurement: prober.Measurement{Source: "official", Success: true, Validation: prober.ValidationResult{CertificateMatch: true, OriginMatch: true}}},
+        {Timestamp: time.Now(), Score: 0.6, Measurement: prober.Measurement{Source: "official", Success: true, Validation: prober.ValidationResult{CertificateMatch: false, OriginMatch: true, Failures: []string{"certificate_mismatch"}}}},
+        {Timestamp: time.Now(), Score: 0.5, Measurement: prober.Measurement{Source: "official", Success: true, Validation: prober.ValidationResult{CertificateMatch: true, OriginMatch: false, Failures: []string{"origin_mismatch"}}}},
+    }
+    for _, record := range records {
+        if err := mem.Save(context.Background(), record); err != nil {
+            t.Fatalf("save: %v", err)
+        }
+    }
+    server := &Server{Store: mem}
+
+    for query, want := range map[string]int{"valid=true": 1, "valid=false": 2} {
+        rr := httptest.NewRecorder()
+        server.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/results?"+query, nil))
+        var list listResponse
+        if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil {
+            t.Fatalf("decode: %v", err)
+        }
+        if list.Total != want {
+            t.Fatalf("%s: expected %d records got %d", query, want, list.Total)
+        }
+        for _, item := range list.Items {
+            v := item.Measurement.Validation
+            if query == "valid=true" && (!v.CertificateMatch || !v.OriginMatch) {
+                t.Fatalf("valid=true returned a record with validation failures: %+v", v)
+            }
+        }
+    }
+
+    rr := httptest.NewRecorder()
+    server.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/results?valid=maybe", nil))
+    if rr.Code != http.StatusBadRequest {
+        t.Fatalf("expected 400 for an invalid filter got %d", rr.Code)
+    }
+}
+
+type slowStore struct {
+    store.Store
+    delay time.Duration
+}
+
+func (s slowStore) List(ctx context.Context) ([]store.Record, error) {
+    time.Sleep(s.delay)
+    return s.Store.List(ctx)
+}
+
+func TestStoreTimeoutReturns503(t *testing.T) {
+    server := &Server{Store: slowStore{Store: prepareStore(t), delay: 500 * time.Millisecond}, StoreTimeout: 20 * time.Millisecond}
+    start := time.Now()
+    rr := httptest.NewRecorder()
+    server.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/results", nil))
+    if rr.Code != http.StatusServiceUnavailable {
+        t.Fatalf("expected 503 got %d", rr.Code)
+    }
+    if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+        t.Fatalf("expected the handler to give up after the timeout, took %s", elapsed)
+    }
+
+    fast := &Server{Store: slowStore{Store: prepareStore(t)}, StoreTimeout: 20 * time.Millisecond}
+    rr = httptest.NewRecorder()
+    fast.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/results/summary", nil))
+    if rr.Code != http.StatusOK {
+        t.Fatalf("expected a fast store to succeed, got %d", rr.Code)
+    }
+}
+
+func TestCompactJSONOutput(t *testing.T) {
+    fetch := func(server *Server, target string) string {
+        rr := httptest.NewRecorder()
+        server.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, target, nil))
+        if rr.Code != http.StatusOK {
+            t.Fatalf("%s: unexpected status %d", target, rr.Code)
+        }
+        return strings.TrimSuffix(rr.Body.String(), "\n")
+    }
+    compact := func(body string) bool {
+        return !strings.Contains(body, "\n") && !strings.Contains(body, "  ")
+    }
+
+    pretty := &Server{Store: prepareStore(t)}
+    if body := fetch(pretty, "/api/results/summary"); compact(body) {
+        t.Fatalf("expected indented output by default, got %s", body)
+    }
+    if body := fetch(pretty, "/api/results/summary?pretty=false"); !compact(body) {
+        t.Fatalf("expected pretty=false to produce compact output, got %s", body)
+    }
+
+    server := &Server{Store: prepareStore(t), CompactJSON: true}
+    if body := fetch(server, "/api/results"); !compact(body) {
+        t.Fatalf("expected compact output, got %s", body)
+    }
+    if body := fetch(server, "/api/results?pretty=true"); compact(body) {
+        t.Fatalf("expected pretty=true to override CompactJSON, got %s", body)
+    }
+}
+
+func TestConfigEndpoint(t *testing.T) {
+    server := &Server{Store: prepareStore(t), CacheTTL: 30 * time.Second, MaxAge: time.Hour, MaxDataAge: 30 * time.Minute}
+    rr := httptest.NewRecorder()
+    server.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/config", nil))
+    if rr.Code != http.StatusOK {
+        t.Fatalf("expected 200 got %d", rr.Code)
+    }
+    var cfg configResponse
+    if err := json.Unmarshal(rr.Body.Bytes(), &cfg); err != nil {
+        t.Fatalf("decode: %v", err)
+    }
+    if cfg.CacheTTL != "30s" || cfg.MaxAge != "1h0m0s" || cfg.MaxDataAge != "30m0s" {
+        t.Fatalf("unexpected durations %+v", cfg)
+    }
+    if cfg.Defaults.Limit != 200 || len(cfg.LatencyBuckets) != len(defaultLatencyBuckets) {
+        t.Fatalf("unexpected defaults %+v", cfg)
+    }
+
+    server = &Server{Store: prepareStore(t), CacheTTL: time.Minute, AdminToken: "s3cret"}
+    rr = httptest.NewRecorder()
+    server.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/config", nil))
+    if rr.Code != http.StatusUnauthorized {
+        t.Fatalf("expected 401 without the admin token got %d", rr.Code)
+    }
+    req := httptest.NewRequest(http.MethodGet, "/config", nil)
+    req.Header.Set("Authorization", "Bearer s3cret")
+    rr = httptest.NewRecorder()
+    server.Handler().ServeHTTP(rr, req)
+    if rr.Code != http.StatusOK {
+        t.Fatalf("expected 200 with the admin token got %d", rr.Code)
+    }
+    if strings.Contains(rr.Body.String(), "s3cret") {
+        t.Fatalf("expected the admin token to be redacted: %s", rr.Body.String())
+    }
+    if err := json.Unmarshal(rr.Body.Bytes(), &cfg); err != nil || cfg.CacheTTL != "1m0s" || cfg.AdminToken != redacted {
+        t.Fatalf("unexpected config %+v (%v)", cfg, err)
+    }
+}
+
+func TestRunIDFilter(t *testing.T) {
+    mem := store.NewMemory()
+    for i, runID := range []string{"run-a", "run-a", "run-b"} {
+        record := store.Record{Timestamp: time.Now().Add(time.Duration(i) * time.Second), RunID: runID, Measurement: prober.Measurement{Source: "official"}}
+        if err := mem.Save(context.Background(), record); err != nil {
+            t.Fatalf("save: %v", err)
+        }
+    }
+    rr := httptest.NewRecorder()
+    (&Server{Store: mem}).Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/results?run_id=run-a", nil))
+    var list listResponse
+    if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil {
+        t.Fatalf("decode: %v", err)
+    }
+    if list.Total != 2 {
+        t.Fatalf("expected 2 records for run-a got %d", list.Total)
+    }
+    for _, item := range list.Items {
+        if item.RunID != "run-a" {
+            t.Fatalf("unexpected run %q in filtered results", item.RunID)
+        }
+    }
+}
+
+func TestFilterCompositionSemantics(t *testing.T) {
+    mem := store.NewMemory()
+    fixtures := []struct {
+        source string
+        colo   string
+    }{
+        {"a", "SJC"}, {"a", "LHR"}, {"b", "SJC"}, {"b", "SIN"}, {"c", "SJC"}, {"a", "SIN"},
+    }
+    for i, f := range fixtures {
+        record := store.Record{Timestamp: time.Date(2024, 1, 1, 10, i, 0, 0, time.UTC), Score: 0.5, Measurement: prober.Measurement{Source: f.source, CFColo: f.colo}}
+        if err := mem.Save(context.Background(), record); err != nil {
+            t.Fatalf("save: %v", err)
+        }
+    }
+    server := &Server{Store: mem}
+    cases := map[string]int{
+        "source=a,b":                                      5,
+        "source=a&source=b":                               5,
+        "source=a,b&region=sjc":                           2,
+        "source=a,b&region=SJC,SIN":                       4,
+        "source=a,b&exclude_region=sin":                   3,
+        "source=a,b&region=SJC,SIN&exclude_region=Singapore": 2,
+        "exclude_source=a":                                3,
+        "source=a&exclude_source=a":                       0,
+        "source=A,,%20b%20":                               5,
+    }
+    for query, want := range cases {
+        rr := httptest.NewRecorder()
+        server.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/results?"+query, nil))
+        var list listResponse
+        if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil {
+            t.Fatalf("%s: decode: %v", query, err)
+        }
+        if list.Total != want {
+            t.Fatalf("%s: expected %d records got %d", query, want, list.Total)
+        }
+        for _, item := range list.Items {
+            if strings.Contains(query, "exclude_region") && regionOf(item) == "SIN" {
+                t.Fatalf("%s: excluded region returned", query)
+            }
+        }
+    }
+}
+
+func TestRescoreEndpoint(t *testing.T) {
+    mem := store.NewMemory()
+    record := store.Record{
+        Timestamp: time.Now(),
+        Score:     0.1,
+        Grade:     "F",
+        Measurement: prober.Measurement{
+            IP:           net.ParseIP("104.16.0.1"),
+            Success:      true,
+            TCPDuration:  10 * time.Millisecond,
+            TLSDuration:  10 * time.Millisecond,
+            HTTPDuration: 10 * time.Millisecond,
+            Integrity:    prober.IntegrityReport{HTTPStatus: 200},
+            Validation:   prober.ValidationResult{CertificateMatch: true, OriginMatch: true},
+        },
+    }
+    if err := mem.Save(context.Background(), record); err != nil {
+        t.Fatalf("save: %v", err)
+    }
+    handler := (&Server{Store: mem}).Handler()
+
+    rr := httptest.NewRecorder()
+    handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/results/rescore", strings.NewReader(`{"latencyWeight": 4}`)))
+    if rr.Code != http.StatusOK {
+        t.Fatalf("unexpected status %d: %s", rr.Code, rr.Body.String())
+    }
+    var resp rescoreResponse
+    if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+        t.Fatalf("decode: %v", err)
+    }
+    if resp.Total != 1 || resp.Items[0].IP != "104.16.0.1" {
+        t.Fatalf("unexpected rescore response %+v", resp)
+    }
+    if resp.Items[0].PreviousGrade != "F" || resp.Items[0].Score <= resp.Items[0].PreviousScore {
+        t.Fatalf("expected a fresh score alongside the stored one, got %+v", resp.Items[0])
+    }
+    stored, _ := mem.List(context.Background())
+    if stored[0].Score != 0.1 {
+        t.Fatalf("rescoring must not persist, stored score is %v", stored[0].Score)
+    }
+
+    rr = httptest.NewRecorder()
+    handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/results/rescore", strings.NewReader(`{"passThreshold": 2}`)))
+    if rr.Code != http.StatusBadRequest {
+        t.Fatalf("expected invalid config to be rejected, got %d", rr.Code)
+    }
+}
+
+func TestCacheHeaders(t *testing.T) {
+    server := &Server{Store: prepareStore(t), CacheTTL: time.Minute}
+    handler := server.Handler()
+
+    first := httptest.NewRecorder()
+    handler.ServeHTTP(first, httptest.NewRequest(http.MethodGet, "/api/results", nil))
+    if got := first.Header().Get("X-Cache"); got != "MISS" {
+        t.Fatalf("expected MISS on first request got %q", got)
+    }
+    if first.Header().Get("Age") != "" {
+        t.Fatalf("expected no Age header on a miss")
+    }
+
+    second := httptest.NewRecorder()
+    handler.ServeHTTP(second, httptest.NewRequest(http.MethodGet, "/api/results", nil))
+    if got := second.Header().Get("X-Cache"); got != "HIT" {
+        t.Fatalf("expected HIT on second request got %q", got)
+    }
+    age, err := strconv.Atoi(second.Header().Get("Age"))
+    if err != nil || age < 0 {
+        t.Fatalf("expected a non-negative Age header got %q", second.Header().Get("Age"))
+    }
+}
+
+func TestGeoColosEndpoint(t *testing.T) {
+    rr := httptest.NewRecorder()
+    (&Server{Store: store.NewMemory()}).Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/geo/colos", nil))
+    if rr.Code != http.StatusOK {
+        t.Fatalf("unexpected status %d", rr.Code)
+    }
+    var resp colosResponse
+    if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+        t.Fatalf("decode: %v", err)
+    }
+    if resp.Total == 0 || resp.Total != len(resp.Colos) {
+        t.Fatalf("unexpected catalog %+v", resp)
+    }
+    found := false
+    for _, colo := range resp.Colos {
+        if colo.Code == "SJC" {
+            found = colo.City == "San Jose" && colo.Country == "US"
+        }
+    }
+    if !found {
+        t.Fatalf("expected SJC -> San Jose, US in %+v", resp.Colos)
+    }
+}
+
+func TestProtocolsEndpoint(t *testing.T) {
+    mem := store.NewMemory()
+    fixtures := []struct {
+        source string
+        tls    string
+        alpn   string
+    }{
+        {"official", "TLS1.3", "h2"}, {"official", "TLS1.3", "h2"}, {"official", "TLS1.2", "http/1.1"}, {"official", "", ""}, {"bestip", "TLS1.2", "h2"},
+    }
+    for i, f := range fixtures {
+        record := store.Record{Timestamp: time.Date(2024, 1, 1, 10, i, 0, 0, time.UTC), Measurement: prober.Measurement{Source: f.source, TLSVersion: f.tls, ALPN: f.alpn}}
+        if err := mem.Save(context.Background(), record); err != nil {
+            t.Fatalf("save: %v", err)
+        }
+    }
+    rr := httptest.NewRecorder()
+    (&Server{Store: mem}).Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/results/protocols?source=official", nil))
+    if rr.Code != http.StatusOK {
+        t.Fatalf("unexpected status %d", rr.Code)
+    }
+    var resp protocolsResponse
+    if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+        t.Fatalf("decode: %v", err)
+    }
+    if resp.Total != 4 {
+        t.Fatalf("expected 4 filtered records got %d", resp.Total)
+    }
+    wantTLS := []protocolCount{{"TLS1.3", 2}, {"TLS1.2", 1}, {"unknown", 1}}
+    wantALPN := []protocolCount{{"h2", 2}, {"http/1.1", 1}, {"unknown", 1}}
+    if len(resp.TLSVersions) != len(wantTLS) || len(resp.ALPN) != len(wantALPN) {
+        t.Fatalf("unexpected groups %+v / %+v", resp.TLSVersions, resp.ALPN)
+    }
+    for i := range wantTLS {
+        if resp.TLSVersions[i] != wantTLS[i] {
+            t.Fatalf("tls version %d: expected %+v got %+v", i, wantTLS[i], resp.TLSVersions[i])
+        }
+    }
+    for i := range wantALPN {
+        if resp.ALPN[i] != wantALPN[i] {
+            t.Fatalf("alpn %d: expected %+v got %+v", i, wantALPN[i], resp.ALPN[i])
+        }
+    }
+}
+
+func TestSummaryBestByCountry(t *testing.T) {
+    mem := store.NewMemory()
+    fixtures := []struct {
+        ip    string
+        colo  string
+        score float64
+        age   time.Duration
+    }{
+        {"104.16.0.1", "SJC", 0.7, 0},
+        {"104.16.0.2", "SJC", 0.9, 0},
+        {"104.16.0.3", "SJC", 0.95, time.Hour},
+        {"104.16.0.3", "SJC", 0.4, 0},
+        {"104.16.1.1", "LHR", 0.6, 0},
+        {"104.16.1.2", "LHR", 0.8, 0},
+        {"104.16.2.1", "XXX", 0.99, 0},
+    }
+    now := time.Now()
+    for _, f := range fixtures {
+        record := store.Record{Timestamp: now.Add(-f.age), Score: f.score, Measurement: prober.Measurement{IP: net.ParseIP(f.ip), CFColo: f.colo}}
+        if err := mem.Save(context.Background(), record); err != nil {
+            t.Fatalf("save: %v", err)
+        }
+    }
+    rr := httptest.NewRecorder()
+    (&Server{Store: mem}).Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/results/summary", nil))
+    var summary summaryResponse
+    if err := json.Unmarshal(rr.Body.Bytes(), &summary); err != nil {
+        t.Fatalf("decode: %v", err)
+    }
+    if len(summary.Countries) != 2 {
+        t.Fatalf("expected GB and US only, got %+v", summary.Countries)
+    }
+    gb, us := summary.Countries[0], summary.Countries[1]
+    if gb.Country != "GB" || gb.IP != "104.16.1.2" || gb.Colo != "LHR" {
+        t.Fatalf("unexpected best GB edge %+v", gb)
+    }
+    if us.Country != "US" || us.IP != "104.16.0.2" || us.Colo != "SJC" {
+        t.Fatalf("unexpected best US edge %+v", us)
+    }
+}
+
+func TestDistinctByNetwork(t *testing.T) {
+    mem := store.NewMemory()
+    fixtures := []struct {
+        ip      string
+        network string
+        score   float64
+    }{
+        {"10.0.1.1", "10.0.1.0/24", 0.5}, {"10.0.1.2", "10.0.1.0/24", 0.9}, {"10.0.1.3", "10.0.1.0/24", 0.7},
+        {"10.0.2.1", "10.0.2.0/24", 0.4}, {"10.0.2.2", "10.0.2.0/24", 0.6},
+    }
+    for i, f := range fixtures {
+        record := store.Record{Timestamp: time.Date(2024, 1, 1, 10, i, 0, 0, time.UTC), Score: f.score, Measurement: prober.Measurement{IP: net.ParseIP(f.ip), Network: f.network}}
+        if err := mem.Save(context.Background(), record); err != nil {
+            t.Fatalf("save: %v", err)
+        }
+    }
+    server := &Server{Store: mem}
+    rr := httptest.NewRecorder()
+    server.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/results?distinct=network", nil))
+    var list listResponse
+    if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil {
+        t.Fatalf("decode: %v", err)
+    }
+    if list.Total != 2 {
+        t.Fatalf("expected one record per network got %d", list.Total)
+    }
+    best := map[string]string{}
+    for _, item := range list.Items {
+        best[item.Measurement.Network] = item.Measurement.IP.String()
+    }
+    if best["10.0.1.0/24"] != "10.0.1.2" || best["10.0.2.0/24"] != "10.0.2.2" {
+        t.Fatalf("expected the best-scoring IP per network, got %v", best)
+    }
+
+    rr = httptest.NewRecorder()
+    server.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/results?distinct=colo", nil))
+    if rr.Code != http.StatusBadRequest {
+        t.Fatalf("expected 400 for an unknown distinct key got %d", rr.Code)
+    }
+}
+
+func TestThroughputFilter(t *testing.T) {
+    mem := store.NewMemory()
+    for i, throughput := range []float64{2e6, 8e6, 12e6, 40e6} {
+        record := store.Record{Timestamp: time.Date(2024, 1, 1, 10, i, 0, 0, time.UTC), Measurement: prober.Measurement{Source: "official", Throughput: throughput}}
+        if err := mem.Save(context.Background(), record); err != nil {
+            t.Fatalf("save: %v", err)
+        }
+    }
+    server := &Server{Store: mem}
+    cases := map[string]int{
+        "throughput_min=10000000":                2,
+        "throughput_max=8000000":                 2,
+        "throughput_min=5e6&throughput_max=20e6": 2,
+        "throughput_min=0":                       4,
+    }
+    for query, want := range cases {
+        rr := httptest.NewRecorder()
+        server.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/results?"+query, nil))
+        var list listResponse
+        if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil {
+            t.Fatalf("%s: decode: %v", query, err)
+        }
+        if list.Total != want {
+            t.Fatalf("%s: expected %d records got %d", query, want, list.Total)
+        }
+        for _, item := range list.Items {
+            if query == "throughput_min=10000000" && item.Measurement.Throughput < 10e6 {
+                t.Fatalf("record below the floor returned: %v", item.Measurement.Throughput)
+            }
+        }
+    }
+    for _, query := range []string{"throughput_min=fast", "throughput_max=-1", "throughput_min=20e6&throughput_max=1e6"} {
+        rr := httptest.NewRecorder()
+        server.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/results?"+query, nil))
+        if rr.Code != http.StatusBadRequest {
+            t.Fatalf("%s: expected 400 got %d", query, rr.Code)
+        }
+    }
+}
+
+func TestTrendsEndpointReportsImprovingRegion(t *testing.T) {
+    mem := store.NewMemory()
+    now := time.Now()
+    fixtures := []struct {
+        colo  string
+        age   time.Duration
+        score float64
+    }{
+        {"SJC", 110 * time.Minute, 0.4},
+        {"SJC", 80 * time.Minute, 0.5},
+        {"SJC", 40 * time.Minute, 0.8},
+        {"SJC", 10 * time.Minute, 0.9},
+        {"LHR", 90 * time.Minute, 0.9},
+        {"LHR", 20 * time.Minute, 0.6},
+        {"NRT", 20 * time.Minute, 0.7},
+    }
+    for _, f := range fixtures {
+        record := store.Record{Timestamp: now.Add(-f.age), Score: f.score, Measurement: prober.Measurement{CFColo: f.colo, Success: true}}
+        if err := mem.Save(context.Background(), record); err != nil {
+            t.Fatalf("save: %v", err)
+        }
+    }
+    rr := httptest.NewRecorder()
+    (&Server{Store: mem}).Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/results/trends?window=1h", nil))
+    if rr.Code != http.StatusOK {
+        t.Fatalf("unexpected status %d: %s", rr.Code, rr.Body.String())
+    }
+    var resp trendsResponse
+    if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+        t.Fatalf("decode: %v", err)
+    }
+    if len(resp.Regions) != 2 {
+        t.Fatalf("expected SJC and LHR trends (NRT lacks a prior window), got %+v", resp.Regions)
+    }
+    sjc := resp.Regions[0]
+    if sjc.Region != "SJC" || sjc.Trend != trendImproving || math.Abs(sjc.Delta-0.4) > 1e-9 {
+        t.Fatalf("expected SJC improving by 0.4, got %+v", sjc)
+    }
+    if lhr := resp.Regions[1]; lhr.Region != "LHR" || lhr.Trend != trendDeclining || lhr.Delta >= 0 {
+        t.Fatalf("expected LHR declining, got %+v", lhr)
+    }
+
+    rr = httptest.NewRecorder()
+    (&Server{Store: mem}).Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/results/trends?window=-1h", nil))
+    if rr.Code != http.StatusBadRequest {
+        t.Fatalf("expected invalid window to be rejected, got %d", rr.Code)
+    }
+}
+
+func TestHealthzReflectsDataAge(t *testing.T) {
+    check := func(records []store.Record) int {
+        mem := store.NewMemory()
+        for _, record := range records {
+            if err := mem.Save(context.Background(), record); err != nil {
+                t.Fatalf("save: %v", err)
+            }
+        }
+        rr := httptest.NewRecorder()
+        (&Server{Store: mem, MaxDataAge: time.Hour}).Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+        return rr.Code
+    }
+    stale := []store.Record{{Timestamp: time.Now().Add(-3 * time.Hour)}, {Timestamp: time.Now().Add(-2 * time.Hour)}}
+    if code := check(stale); code != http.StatusServiceUnavailable {
+        t.Fatalf("expected 503 for stale data, got %d", code)
+    }
+    if code := check(append(stale, store.Record{Timestamp: time.Now().Add(-time.Minute)})); code != http.StatusOK {
+        t.Fatalf("expected 200 for fresh data, got %d", code)
+    }
+    if code := check(nil); code != http.StatusServiceUnavailable {
+        t.Fatalf("expected 503 for an empty store, got %d", code)
+    }
+    rr := httptest.NewRecorder()
+    (&Server{Store: store.NewMemory()}).Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+    if rr.Code != http.StatusOK {
+        t.Fatalf("expected liveness-only healthz without MaxDataAge, got %d", rr.Code)
+    }
+}
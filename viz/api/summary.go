@@ -0,0 +1,384 @@
+package api
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/example/cf-edgescout/geo"
+	"github.com/example/cf-edgescout/prober"
+	"github.com/example/cf-edgescout/store"
+)
+
+// scoreSummary describes the score distribution of the filtered records.
+type scoreSummary struct {
+	Count   int     `json:"count"`
+	Average float64 `json:"average"`
+	Min     float64 `json:"min"`
+	Max     float64 `json:"max"`
+}
+
+// groupAccumulator collects the samples needed to summarise one group.
+type groupAccumulator struct {
+	count     int
+	successes int
+	scores    []float64
+	latencies []float64
+	totals    []float64
+}
+
+func (a *groupAccumulator) add(record store.Record) {
+	a.count++
+	if record.Measurement.Success {
+		a.successes++
+	}
+	a.scores = append(a.scores, record.Score)
+	a.latencies = append(a.latencies, latencyMs(record))
+	a.totals = append(a.totals, record.Measurement.TotalDuration.Seconds()*1000)
+}
+
+func (a *groupAccumulator) successRate() float64 {
+	if a.count == 0 {
+		return 0
+	}
+	return float64(a.successes) / float64(a.count)
+}
+
+// summariseScores computes the overall score distribution. trim is the
+// percentage of samples dropped from each end before averaging.
+func summariseScores(records []store.Record, trim float64) scoreSummary {
+	if len(records) == 0 {
+		return scoreSummary{}
+	}
+	scores := make([]float64, 0, len(records))
+	for _, record := range records {
+		scores = append(scores, record.Score)
+	}
+	sort.Float64s(scores)
+	return scoreSummary{
+		Count:   len(scores),
+		Average: trimmedMean(scores, trim),
+		Min:     scores[0],
+		Max:     scores[len(scores)-1],
+	}
+}
+
+// summariseGroups aggregates the records per provider, falling back to the
+// source key when the provider name is missing.
+func summariseGroups(records []store.Record, trim float64) []providerSummary {
+	groups := map[string]*groupAccumulator{}
+	meta := map[string]providerSummary{}
+	for _, record := range records {
+		key := strings.ToLower(record.Measurement.Provider)
+		if key == "" {
+			key = strings.ToLower(record.Measurement.Source)
+		}
+		if key == "" {
+			key = "unknown"
+		}
+		acc := groups[key]
+		if acc == nil {
+			acc = &groupAccumulator{}
+			groups[key] = acc
+			meta[key] = providerSummary{Source: record.Measurement.Source, Provider: record.Measurement.Provider}
+		}
+		acc.add(record)
+	}
+	out := make([]providerSummary, 0, len(groups))
+	for key, acc := range groups {
+		summary := meta[key]
+		summary.Count = acc.count
+		summary.SuccessRate = acc.successRate()
+		summary.AvgScore = trimmedMean(acc.scores, trim)
+		summary.AvgLatency = trimmedMean(acc.latencies, trim)
+		summary.AvgTotal = trimmedMean(acc.totals, trim)
+		out = append(out, summary)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].AvgScore > out[j].AvgScore
+	})
+	return out
+}
+
+// latencyBucket counts records whose total latency falls in [MinMs, MaxMs).
+// MaxMs is omitted for the open-ended final bucket.
+type latencyBucket struct {
+	Label string   `json:"label"`
+	MinMs float64  `json:"minMs"`
+	MaxMs *float64 `json:"maxMs,omitempty"`
+	Count int      `json:"count"`
+}
+
+// defaultLatencyBuckets are the bucket edges (ms) used when none are configured.
+var defaultLatencyBuckets = []float64{50, 100, 200}
+
+// buildLatencyHistogram counts the records per latency bucket. edges must be
+// ascending; they produce len(edges)+1 buckets.
+func buildLatencyHistogram(records []store.Record, edges []float64) []latencyBucket {
+	buckets := make([]latencyBucket, 0, len(edges)+1)
+	lower := 0.0
+	for _, edge := range edges {
+		upper := edge
+		buckets = append(buckets, latencyBucket{Label: formatEdge(lower) + "-" + formatEdge(upper), MinMs: lower, MaxMs: &upper})
+		lower = edge
+	}
+	buckets = append(buckets, latencyBucket{Label: formatEdge(lower) + "+", MinMs: lower})
+	for _, record := range records {
+		latency := latencyMs(record)
+		idx := sort.SearchFloat64s(edges, latency)
+		if idx < len(edges) && edges[idx] == latency {
+			idx++
+		}
+		buckets[idx].Count++
+	}
+	return buckets
+}
+
+func formatEdge(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// errorCategorySummary counts failed probes sharing a normalised cause.
+type errorCategorySummary struct {
+	Category string   `json:"category"`
+	Count    int      `json:"count"`
+	Examples []string `json:"examples"`
+}
+
+// maxErrorExamples bounds the example IPs reported per category.
+const maxErrorExamples = 3
+
+// summariseErrors groups failed records by prober.Measurement.FailureCategory.
+func summariseErrors(records []store.Record) []errorCategorySummary {
+	groups := map[string]*errorCategorySummary{}
+	for _, record := range records {
+		category := record.Measurement.FailureCategory()
+		if category == "" {
+			continue
+		}
+		summary := groups[category]
+		if summary == nil {
+			summary = &errorCategorySummary{Category: category, Examples: []string{}}
+			groups[category] = summary
+		}
+		summary.Count++
+		if ip := record.Measurement.IP; ip != nil && len(summary.Examples) < maxErrorExamples {
+			summary.Examples = append(summary.Examples, ip.String())
+		}
+	}
+	out := make([]errorCategorySummary, 0, len(groups))
+	for _, summary := range groups {
+		out = append(out, *summary)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Category < out[j].Category
+	})
+	return out
+}
+
+// protocolCount is the number of records that negotiated one protocol value.
+type protocolCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// unknownProtocol labels records without a negotiated value, such as probes
+// that failed before the TLS handshake completed.
+const unknownProtocol = "unknown"
+
+// countProtocols groups records by the protocol value returned by field,
+// ordered by descending count.
+func countProtocols(records []store.Record, field func(prober.Measurement) string) []protocolCount {
+	counts := map[string]int{}
+	for _, record := range records {
+		value := strings.TrimSpace(field(record.Measurement))
+		if value == "" {
+			value = unknownProtocol
+		}
+		counts[value]++
+	}
+	out := make([]protocolCount, 0, len(counts))
+	for value, count := range counts {
+		out = append(out, protocolCount{Value: value, Count: count})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Value < out[j].Value
+	})
+	return out
+}
+
+// summariseRegions aggregates the records per normalised region key.
+func summariseRegions(records []store.Record, trim float64) []regionSummary {
+	groups := map[string]*groupAccumulator{}
+	for _, record := range records {
+		key := regionOf(record)
+		acc := groups[key]
+		if acc == nil {
+			acc = &groupAccumulator{}
+			groups[key] = acc
+		}
+		acc.add(record)
+	}
+	out := make([]regionSummary, 0, len(groups))
+	for key, acc := range groups {
+		summary := regionSummary{
+			Region:      key,
+			Count:       acc.count,
+			SuccessRate: acc.successRate(),
+			AvgScore:    trimmedMean(acc.scores, trim),
+			AvgLatency:  trimmedMean(acc.latencies, trim),
+			AvgTotal:    trimmedMean(acc.totals, trim),
+		}
+		if info, ok := geo.LookupColo(key); ok {
+			summary.City = info.City
+			summary.Country = info.Country
+		}
+		out = append(out, summary)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].AvgScore != out[j].AvgScore {
+			return out[i].AvgScore > out[j].AvgScore
+		}
+		return out[i].Region < out[j].Region
+	})
+	return out
+}
+
+// countryBest is the best-scoring edge observed in one country.
+type countryBest struct {
+	Country   string    `json:"country"`
+	IP        string    `json:"ip"`
+	Colo      string    `json:"colo"`
+	City      string    `json:"city"`
+	Score     float64   `json:"score"`
+	Grade     string    `json:"grade"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// bestByCountry picks the highest-scoring IP per country, judging each IP by
+// its most recent record so an old high score cannot outrank a fresh
+// regression. Records whose colo is not in the geo catalog are skipped.
+func bestByCountry(records []store.Record) []countryBest {
+	latest := map[string]store.Record{}
+	for _, record := range records {
+		ip := record.Measurement.IP
+		if ip == nil {
+			continue
+		}
+		key := ip.String()
+		if prev, ok := latest[key]; !ok || record.Timestamp.After(prev.Timestamp) {
+			latest[key] = record
+		}
+	}
+	best := map[string]countryBest{}
+	for ip, record := range latest {
+		info, ok := geo.LookupColo(regionOf(record))
+		if !ok || info.Country == "" {
+			continue
+		}
+		candidate := countryBest{Country: info.Country, IP: ip, Colo: info.Code, City: info.City, Score: record.Score, Grade: record.Grade, Timestamp: record.Timestamp}
+		current, ok := best[info.Country]
+		if !ok || candidate.Score > current.Score || (candidate.Score == current.Score && candidate.IP < current.IP) {
+			best[info.Country] = candidate
+		}
+	}
+	out := make([]countryBest, 0, len(best))
+	for _, entry := range best {
+		out = append(out, entry)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Country < out[j].Country
+	})
+	return out
+}
+
+// regionOf returns the normalised region key for a record. Known places are
+// always keyed by their colo code; unknown values are upper-cased verbatim.
+func regionOf(record store.Record) string {
+	m := record.Measurement
+	candidates := []string{m.CFColo, m.Location.Colo, m.Geo.Code, m.Location.City, m.Geo.City}
+	for _, candidate := range candidates {
+		if info, ok := geo.Resolve(candidate); ok {
+			return info.Code
+		}
+	}
+	for _, candidate := range candidates {
+		if trimmed := strings.TrimSpace(candidate); trimmed != "" {
+			return strings.ToUpper(trimmed)
+		}
+	}
+	return "UNKNOWN"
+}
+
+// normaliseRegion maps a user supplied region (colo code or city) onto the
+// key produced by regionOf.
+func normaliseRegion(value string) string {
+	if info, ok := geo.Resolve(value); ok {
+		return info.Code
+	}
+	return strings.ToUpper(strings.TrimSpace(value))
+}
+
+// trimmedMean averages values after dropping trim percent of the samples from
+// both the top and the bottom. A trim of 0 yields the plain mean.
+func trimmedMean(values []float64, trim float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	drop := int(math.Floor(float64(len(sorted)) * trim / 100))
+	if 2*drop >= len(sorted) {
+		drop = (len(sorted) - 1) / 2
+	}
+	kept := sorted[drop : len(sorted)-drop]
+	var sum float64
+	for _, v := range kept {
+		sum += v
+	}
+	return sum / float64(len(kept))
+}
+
+// buildTimeseries groups records into fixed intervals aligned to the Unix
+// epoch, ordered by start time.
+func buildTimeseries(records []store.Record, interval time.Duration) []timeseriesBucket {
+	index := map[int64]*timeseriesBucket{}
+	for _, record := range records {
+		start := record.Timestamp.Truncate(interval)
+		b := index[start.UnixNano()]
+		if b == nil {
+			b = &timeseriesBucket{Start: start.UTC()}
+			index[start.UnixNano()] = b
+		}
+		b.Count++
+		b.AvgScore += record.Score
+		b.AvgLatency += latencyMs(record)
+		if record.Measurement.Success {
+			b.SuccessCount++
+		}
+	}
+	buckets := make([]timeseriesBucket, 0, len(index))
+	for _, b := range index {
+		b.AvgScore /= float64(b.Count)
+		b.AvgLatency /= float64(b.Count)
+		b.SuccessRate = float64(b.SuccessCount) / float64(b.Count)
+		buckets = append(buckets, *b)
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i].Start.Before(buckets[j].Start)
+	})
+	return buckets
+}
+
+func latencyMs(record store.Record) float64 {
+	m := record.Measurement
+	return (m.TCPDuration + m.TLSDuration + m.HTTPDuration).Seconds() * 1000
+}
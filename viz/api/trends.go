@@ -0,0 +1,133 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/example/cf-edgescout/geo"
+	"github.com/example/cf-edgescout/store"
+)
+
+// defaultTrendWindow is the window length used when a request omits window.
+const defaultTrendWindow = time.Hour
+
+// Trend labels reported per region.
+const (
+	trendImproving = "improving"
+	trendDeclining = "declining"
+	trendStable    = "stable"
+)
+
+// regionTrend compares a region's average score in the most recent window
+// with the window before it. Delta is recent minus prior.
+type regionTrend struct {
+	Region      string  `json:"region"`
+	City        string  `json:"city,omitempty"`
+	Country     string  `json:"country,omitempty"`
+	RecentCount int     `json:"recentCount"`
+	PriorCount  int     `json:"priorCount"`
+	RecentAvg   float64 `json:"recentAvgScore"`
+	PriorAvg    float64 `json:"priorAvgScore"`
+	Delta       float64 `json:"delta"`
+	Trend       string  `json:"trend"`
+}
+
+type trendsResponse struct {
+	GeneratedAt time.Time     `json:"generatedAt"`
+	Window      string        `json:"window"`
+	Regions     []regionTrend `json:"regions"`
+}
+
+// handleTrends reports the score trend per region. window sets the length of
+// both compared windows.
+func (s *Server) handleTrends(w http.ResponseWriter, r *http.Request) {
+	records, ok := s.listRecords(w, r)
+	if !ok {
+		return
+	}
+	opts, err := s.parseQueryOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	window := defaultTrendWindow
+	if raw := r.URL.Query().Get("window"); raw != "" {
+		window, err = time.ParseDuration(raw)
+		if err != nil || window <= 0 {
+			http.Error(w, "invalid window", http.StatusBadRequest)
+			return
+		}
+	}
+	s.writeJSON(w, r, trendsResponse{
+		GeneratedAt: opts.now,
+		Window:      window.String(),
+		Regions:     buildRegionTrends(filterRecords(records, opts), opts.now, window),
+	})
+}
+
+// buildRegionTrends splits the records into (now-window, now] and the window
+// before it and compares the per-region average scores. Regions without
+// records in both windows have no trend and are omitted. Regions are ordered
+// by descending delta.
+func buildRegionTrends(records []store.Record, now time.Time, window time.Duration) []regionTrend {
+	type sums struct {
+		recent, prior           float64
+		recentCount, priorCount int
+	}
+	recentStart, priorStart := now.Add(-window), now.Add(-2*window)
+	groups := map[string]*sums{}
+	for _, record := range records {
+		ts := record.Timestamp
+		if !ts.After(priorStart) || ts.After(now) {
+			continue
+		}
+		key := regionOf(record)
+		acc := groups[key]
+		if acc == nil {
+			acc = &sums{}
+			groups[key] = acc
+		}
+		if ts.After(recentStart) {
+			acc.recent += record.Score
+			acc.recentCount++
+		} else {
+			acc.prior += record.Score
+			acc.priorCount++
+		}
+	}
+	out := make([]regionTrend, 0, len(groups))
+	for key, acc := range groups {
+		if acc.recentCount == 0 || acc.priorCount == 0 {
+			continue
+		}
+		trend := regionTrend{
+			Region:      key,
+			RecentCount: acc.recentCount,
+			PriorCount:  acc.priorCount,
+			RecentAvg:   acc.recent / float64(acc.recentCount),
+			PriorAvg:    acc.prior / float64(acc.priorCount),
+		}
+		trend.Delta = trend.RecentAvg - trend.PriorAvg
+		switch {
+		case trend.Delta > 0:
+			trend.Trend = trendImproving
+		case trend.Delta < 0:
+			trend.Trend = trendDeclining
+		default:
+			trend.Trend = trendStable
+		}
+		if info, ok := geo.LookupColo(key); ok {
+			trend.City = info.City
+			trend.Country = info.Country
+		}
+		out = append(out, trend)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Delta != out[j].Delta {
+			return out[i].Delta > out[j].Delta
+		}
+		return out[i].Region < out[j].Region
+	})
+	return out
+}